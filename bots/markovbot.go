@@ -45,7 +45,7 @@ func (b *MarkovBot) OnMessage(msg sdk.ChatMessage, lc *sdk.Lanchat) error {
 
 	if parts[0] == "markov" {
 		if len(parts) < 2 {
-			return b.showHelp(lc)
+			return b.showHelp(lc, msg.Room)
 		}
 
 		switch parts[1] {
@@ -54,13 +54,13 @@ func (b *MarkovBot) OnMessage(msg sdk.ChatMessage, lc *sdk.Lanchat) error {
 			if len(parts) > 2 {
 				fmt.Sscanf(parts[2], "%d", &length)
 			}
-			return b.generateStory(lc, length)
+			return b.generateStory(lc, msg.Room, length)
 		case "stats":
-			return b.showStats(lc)
+			return b.showStats(lc, msg.Room)
 		case "reset":
-			return b.resetChain(lc)
+			return b.resetChain(lc, msg.Room)
 		case "help":
-			return b.showHelp(lc)
+			return b.showHelp(lc, msg.Room)
 		}
 		return nil
 	}
@@ -74,7 +74,7 @@ func (b *MarkovBot) OnMessage(msg sdk.ChatMessage, lc *sdk.Lanchat) error {
 			time.Sleep(time.Duration(1+rand.Intn(3)) * time.Second)
 			response := b.generateContextual(text, 10, 20)
 			if response != "" && !strings.Contains(strings.ToLower(response), "markov") {
-				lc.SendMessage(fmt.Sprintf("💭 %s", response))
+				lc.SendMessageTo(msg.Room, fmt.Sprintf("💭 %s", response))
 			}
 		}()
 	}
@@ -86,13 +86,13 @@ func (b *MarkovBot) OnRoomJoined(room sdk.Room, lc *sdk.Lanchat) error {
 	return nil
 }
 
-func (b *MarkovBot) showHelp(lc *sdk.Lanchat) error {
+func (b *MarkovBot) showHelp(lc *sdk.Lanchat, room string) error {
 	help := `Commands:
 - markov generate [length] 
 - markov personality <mode> 
 - markov stats 
 - markov reset `
-	return lc.SendMessage(help)
+	return lc.SendMessageTo(room, help)
 }
 
 func (b *MarkovBot) learn(text string) {
@@ -262,7 +262,7 @@ func (b *MarkovBot) formatSentence(words []string) string {
 	return sentence
 }
 
-func (b *MarkovBot) generateStory(lc *sdk.Lanchat, length int) error {
+func (b *MarkovBot) generateStory(lc *sdk.Lanchat, room string, length int) error {
 	if length > 200 {
 		length = 200
 	}
@@ -273,13 +273,13 @@ func (b *MarkovBot) generateStory(lc *sdk.Lanchat, length int) error {
 	story := b.generate(length-10, length+10)
 
 	if story == "" {
-		return lc.SendMessage("Need to learn more words")
+		return lc.SendMessageTo(room, "Need to learn more words")
 	}
 
-	return lc.SendMessage(story)
+	return lc.SendMessageTo(room, story)
 }
 
-func (b *MarkovBot) showStats(lc *sdk.Lanchat) error {
+func (b *MarkovBot) showStats(lc *sdk.Lanchat, room string) error {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
@@ -299,12 +299,12 @@ func (b *MarkovBot) showStats(lc *sdk.Lanchat) error {
 	}
 
 	for _, v := range stats {
-		lc.SendMessage(v)
+		lc.SendMessageTo(room, v)
 	}
 	return nil
 }
 
-func (b *MarkovBot) resetChain(lc *sdk.Lanchat) error {
+func (b *MarkovBot) resetChain(lc *sdk.Lanchat, room string) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
@@ -312,5 +312,5 @@ func (b *MarkovBot) resetChain(lc *sdk.Lanchat) error {
 	b.starters = make(map[string]int)
 	b.messageLog = make([]string, 0)
 
-	return lc.SendMessage("Chain reset successfully")
+	return lc.SendMessageTo(room, "Chain reset successfully")
 }
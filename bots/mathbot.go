@@ -37,13 +37,13 @@ func (b *MathBot) OnMessage(msg sdk.ChatMessage, lc *sdk.Lanchat) error {
 	for _, part := range parts[2:] {
 		num, err := strconv.Atoi(part)
 		if err != nil {
-			return lc.SendMessage(fmt.Sprintf("invalid number: %s", part))
+			return lc.Reply(msg, fmt.Sprintf("invalid number: %s", part))
 		}
 		nums = append(nums, num)
 	}
 
 	if len(nums) == 0 {
-		return lc.SendMessage("no numbers provided")
+		return lc.Reply(msg, "no numbers provided")
 	}
 
 	var result int
@@ -59,17 +59,17 @@ func (b *MathBot) OnMessage(msg sdk.ChatMessage, lc *sdk.Lanchat) error {
 	case "divide", "/":
 		result, err = b.divide(nums)
 		if err != nil {
-			return lc.SendMessage(err.Error())
+			return lc.Reply(msg, err.Error())
 		}
 	default:
 		return nil
 	}
 
-	return lc.SendMessage(fmt.Sprintf("%d", result))
+	return lc.Reply(msg, fmt.Sprintf("%d", result))
 }
 
 func (b *MathBot) OnRoomJoined(room sdk.Room, lc *sdk.Lanchat) error {
-	return lc.SendMessage("MathBot online. Use: mathbot add/subtract/multiply/divide <numbers>")
+	return lc.SendMessageTo(room.Name, "MathBot online. Use: mathbot add/subtract/multiply/divide <numbers>")
 }
 
 func (b *MathBot) add(args []int) int {
@@ -38,16 +38,32 @@ func (b *OpenaiBot) OnMessage(msg sdk.ChatMessage, lc *sdk.Lanchat) error {
 	case sdk.MessageTypeJoin:
 	case sdk.MessageTypeLeave:
 	case sdk.MessageTypeText:
+		// the model takes a while, show that an answer is coming
+		stop, err := lc.KeepTyping(msg.Room)
+		if err != nil {
+			stop = func() {}
+		}
 		resp, err := b.invoke(msg.Content)
+		stop()
 		if err != nil {
-			lc.SendMessage(fmt.Sprintf("[Error] %v", err))
+			lc.Reply(msg, fmt.Sprintf("[Error] %v", err))
 			return err
 		}
-		lc.SendMessage(resp)
+		lc.Reply(msg, resp)
 	}
 	return nil
 }
 
+// OnDirectMessage answers private questions privately
+func (b *OpenaiBot) OnDirectMessage(msg sdk.ChatMessage, lc *sdk.Lanchat) error {
+	resp, err := b.invoke(msg.Content)
+	if err != nil {
+		lc.SendDirect(msg.From, fmt.Sprintf("[Error] %v", err))
+		return err
+	}
+	return lc.SendDirect(msg.From, resp)
+}
+
 func (b *OpenaiBot) OnRoomJoined(room sdk.Room, lc *sdk.Lanchat) error {
 	return nil
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"context"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
@@ -16,6 +17,24 @@ import (
 )
 
 func main() {
+	dataDir := flag.String("data-dir", "", "directory for persistent state (default: user config dir)")
+	identityPath := flag.String("identity", "", "path to the identity key (default: <data-dir>/identity.key)")
+	downloadDir := flag.String("download-dir", "", "directory for received files (default: <data-dir>/downloads)")
+	listen := flag.String("listen", "", "comma-separated multiaddrs to listen on")
+	transports := flag.String("transports", "", "comma-separated transports to enable: tcp, quic (default: all)")
+	announce := flag.String("announce", "", "comma-separated multiaddrs to advertise instead of the listen addresses")
+	iface := flag.String("iface", "", "comma-separated network interfaces to use (default: all)")
+	excludeIface := flag.String("exclude-iface", "", "comma-separated network interfaces to ignore")
+	peers := flag.String("peer", "", "comma-separated peer multiaddrs (with /p2p/<peer-id>) to connect to on startup")
+	noMDNS := flag.Bool("no-mdns", false, "disable mDNS discovery")
+	relay := flag.Bool("relay", false, "relay traffic for peers that can't reach each other directly")
+	historyRetention := flag.Duration("history-retention", 0, "how long to keep room messages on disk (default: 720h)")
+	noHistory := flag.Bool("no-history", false, "don't store room messages on disk")
+	noReadReceipts := flag.Bool("no-read-receipts", false, "don't tell senders which messages you have seen")
+	awayAfter := flag.Duration("away-after", 0, "how long without activity before your status turns to away (default: 10m)")
+	noAutoAway := flag.Bool("no-auto-away", false, "never set your status to away automatically")
+	flag.Parse()
+
 	logger.SetLevel(logger.LevelNone)
 
 	ctx, cancel := signal.NotifyContext(
@@ -34,7 +53,27 @@ func main() {
 		domain = "lanchat"
 	}
 
-	chatApp, err := app.NewApp(ctx, nickname, domain)
+	chatApp, err := app.NewApp(ctx, nickname, domain, app.Options{
+		DataDir:            *dataDir,
+		IdentityPath:       *identityPath,
+		IdentityPassphrase: os.Getenv("LANCHAT_PASSPHRASE"),
+		DomainSecret:       os.Getenv("LANCHAT_DOMAIN_SECRET"),
+		ListenAddrs:        splitList(*listen),
+		Transports:         splitList(*transports),
+		AnnounceAddrs:      splitList(*announce),
+		AllowInterfaces:    splitList(*iface),
+		DenyInterfaces:     splitList(*excludeIface),
+		BootstrapPeers:     splitList(*peers),
+		DisableMDNS:        *noMDNS,
+		RelayService:       *relay,
+		DownloadDir:        *downloadDir,
+		HistoryRetention:   *historyRetention,
+		DisableHistory:     *noHistory,
+
+		DisableReadReceipts: *noReadReceipts,
+		AwayAfter:           *awayAfter,
+		DisableAutoAway:     *noAutoAway,
+	})
 	if err != nil {
 		fmt.Printf("Failed to start: %v\n", err)
 		return
@@ -56,3 +95,13 @@ func getInput(scanner *bufio.Scanner, prompt string) string {
 	}
 	return strings.TrimSpace(scanner.Text())
 }
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -5,10 +5,15 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/matt0792/lanchat/internal/logger"
 	"github.com/matt0792/lanchat/internal/p2p"
@@ -21,8 +26,18 @@ const (
 
 	rateLimitAmount = 20
 	rateLimitWindow = 10 * time.Second
+	// per peer across all rooms, on top of rateLimitAmount
+	typingLimitAmount = 20
+	// a receipt per room every receiptInterval, with room for flushes
+	// split in two
+	receiptLimitAmount = 2 * maxJoinedRooms * int(rateLimitWindow/receiptInterval)
+	// a status change goes to every room we are in
+	statusLimitAmount = 3 * maxJoinedRooms
 
 	maxMessagesPerRoom = 50
+	maxJoinedRooms     = 10
+
+	connectTimeout = 10 * time.Second
 )
 
 const (
@@ -36,23 +51,60 @@ type App struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 
-	host *p2p.Host
-	user *User
-
-	currentRoom     *Room
-	currentRoomName string
-	topic           *p2p.Topic
+	host     *p2p.Host
+	identity crypto.PrivKey
+	domain   string
+
+	// user's status changes, lastActive and autoAway drive automatic away
+	user       *User
+	userMu     sync.RWMutex
+	lastActive time.Time
+	autoAway   bool
+	// awayAfter is zero when automatic away is disabled
+	awayAfter time.Duration
+
+	// metadataMu serializes changes to our metadata
+	metadataMu sync.Mutex
+
+	// rooms we are in by name and by topic, messages without a room
+	// argument go to activeRoom
+	rooms        map[string]*Room
+	roomsByTopic map[string]*Room
+	activeRoom   string
+	roomsMu      sync.RWMutex
+	// joinMu serializes joins from the membership check until the room is
+	// added, so concurrent joins can't both open a topic. Taken before roomsMu.
+	joinMu sync.Mutex
 
 	// all known peers across all rooms
 	peers   map[peer.ID]*PeerInfo
 	peersMu sync.RWMutex
 
-	rateLimiter *RateLimiter
+	rateLimiter    *RateLimiter
+	typingLimiter  *RateLimiter
+	receiptLimiter *RateLimiter
+	statusLimiter  *RateLimiter
+
+	transfers   map[string]*transfer
+	transfersMu sync.RWMutex
+	downloadDir string
+
+	// directSeen holds the sender and ID of recent direct messages, so a
+	// message sent again after a lost acknowledgement is shown once
+	directSeen *seenCache
+
+	// historyDir is empty when history is not stored
+	historyDir       string
+	historyRetention time.Duration
 
-	events chan Event
+	disableReadReceipts bool
+
+	events   chan Event
+	eventsMu sync.RWMutex
+	closed   bool
 }
 
-func NewApp(ctx context.Context, nickname string, domain string) (*App, error) {
+func NewApp(ctx context.Context, nickname string, domain string, opts Options) (*App, error) {
 	appCtx, cancel := context.WithCancel(ctx)
 
 	nickname = sanitize(nickname)
@@ -64,7 +116,34 @@ func NewApp(ctx context.Context, nickname string, domain string) (*App, error) {
 		nickname = nickname[:maxNicknameLength]
 	}
 
-	host, err := p2p.NewHost(appCtx)
+	opts, err := opts.withDefaults()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	identity, err := p2p.LoadOrCreateIdentity(opts.IdentityPath, opts.IdentityPassphrase)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to load identity: %w", err)
+	}
+
+	hostOpts := p2p.HostOptions{
+		Identity:        identity,
+		ListenAddrs:     opts.ListenAddrs,
+		Transports:      opts.Transports,
+		AnnounceAddrs:   opts.AnnounceAddrs,
+		AllowInterfaces: opts.AllowInterfaces,
+		DenyInterfaces:  opts.DenyInterfaces,
+		RelayService:    opts.RelayService,
+		AddressBookPath: filepath.Join(opts.DataDir, addressBookFileName),
+		Domain:          domain,
+	}
+	if opts.DomainSecret != "" {
+		hostOpts.PrivateNetworkKey = DeriveNetworkKey(domain, opts.DomainSecret)
+	}
+
+	host, err := p2p.NewHost(appCtx, hostOpts)
 	if err != nil {
 		cancel()
 		return nil, fmt.Errorf("failed to create host: %w", err)
@@ -73,10 +152,11 @@ func NewApp(ctx context.Context, nickname string, domain string) (*App, error) {
 	// set metadata
 	user := &User{
 		Nickname: nickname,
-		Status:   "online",
+		Status:   StatusOnline,
 	}
 
 	host.SetMetadata(p2p.MetadataResponse{
+		Domain:   domain,
 		Nickname: nickname,
 		Version:  "1.0.0",
 		Custom: map[string]string{
@@ -85,17 +165,45 @@ func NewApp(ctx context.Context, nickname string, domain string) (*App, error) {
 	})
 
 	app := &App{
-		ctx:         appCtx,
-		cancel:      cancel,
-		host:        host,
-		user:        user,
-		peers:       make(map[peer.ID]*PeerInfo),
-		events:      make(chan Event, 100),
-		rateLimiter: NewRateLimiter(rateLimitAmount, rateLimitWindow),
+		ctx:            appCtx,
+		cancel:         cancel,
+		host:           host,
+		identity:       identity,
+		user:           user,
+		domain:         domain,
+		rooms:          make(map[string]*Room),
+		roomsByTopic:   make(map[string]*Room),
+		peers:          make(map[peer.ID]*PeerInfo),
+		events:         make(chan Event, 100),
+		rateLimiter:    NewRateLimiter(rateLimitAmount, rateLimitWindow),
+		typingLimiter:  NewRateLimiter(typingLimitAmount, rateLimitWindow),
+		receiptLimiter: NewRateLimiter(receiptLimitAmount, rateLimitWindow),
+		statusLimiter:  NewRateLimiter(statusLimitAmount, rateLimitWindow),
+		transfers:      make(map[string]*transfer),
+		directSeen:     newSeenCache(maxSeenMessages),
+		downloadDir:    opts.DownloadDir,
+
+		disableReadReceipts: opts.DisableReadReceipts,
+		lastActive:          time.Now(),
+	}
+	if !opts.DisableAutoAway {
+		app.awayAfter = opts.AwayAfter
 	}
+	if !opts.DisableHistory {
+		app.historyDir = filepath.Join(opts.DataDir, historyDirName)
+		app.historyRetention = opts.HistoryRetention
+	}
+
+	host.RegisterMessageValidator(p2p.MessageTypeChat, app.validateChatMessage)
+	host.RegisterMessageHandler(p2p.MessageTypeChat, app.handleChatMessage)
+	host.RegisterMessageValidator(p2p.MessageTypeStatus, app.validateStatusMessage)
+	host.RegisterMessageHandler(p2p.MessageTypeStatus, app.handleStatusMessage)
+	host.SetStreamHandler(p2p.ProtocolDirectMessage, app.handleDirectStream)
+	app.registerFileTransfer()
+	app.registerHistory()
 
 	// start discovery
-	if err := host.StartDiscovery(domain); err != nil {
+	if err := host.StartDiscovery(discoveryBackends(domain, opts)...); err != nil {
 		cancel()
 		host.Close()
 		return nil, fmt.Errorf("failed to start discovery: %w", err)
@@ -103,37 +211,49 @@ func NewApp(ctx context.Context, nickname string, domain string) (*App, error) {
 
 	go app.handlePeerDiscovery()
 	go app.handlePeerEvents()
+	go app.handleMetadataUpdates()
 	go app.startRateLimiterCleanup()
+	go app.watchActivity()
 
 	logger.Info("app initialized for user: %s (ID: %s)", nickname, host.ID().String()[:8])
 
 	return app, nil
 }
 
+// JoinRoom joins a room and makes it the active one. Rooms joined earlier
+// are kept, joining one of them again switches back to it.
 func (a *App) JoinRoom(roomName, password string) error {
-	roomName = sanitize(roomName)
+	roomName = cleanRoomName(roomName)
 	if len(roomName) == 0 {
 		return fmt.Errorf("invalid room name")
 	}
-	if len(roomName) > maxRoomNameLength {
-		roomName = roomName[:maxRoomNameLength]
-	}
 
-	if a.currentRoom != nil {
-		if err := a.LeaveRoom(); err != nil {
-			logger.Warn("Error leaving current room: %v", err)
-			return fmt.Errorf("failed to leave room")
+	// joinMu is released before anything is emitted, handlers may join
+	// rooms themselves
+	a.joinMu.Lock()
+	a.roomsMu.RLock()
+	existing := a.rooms[roomName]
+	joined := len(a.rooms)
+	a.roomsMu.RUnlock()
+
+	if existing != nil {
+		a.joinMu.Unlock()
+		if existing.Password != password {
+			return fmt.Errorf("already in room %s with a different password", roomName)
 		}
+		_, err := a.SwitchRoom(roomName)
+		return err
 	}
-
-	topicName := fmt.Sprintf("chat/rooms/%s", roomName)
-	if password != "" {
-		hash := sha256.Sum256([]byte(password))
-		topicName = fmt.Sprintf("chat/rooms/%s/%x", roomName, hash[:8])
+	if joined >= maxJoinedRooms {
+		a.joinMu.Unlock()
+		return fmt.Errorf("too many rooms (max %d)", maxJoinedRooms)
 	}
 
+	topicName := a.roomTopic(roomName, password)
+
 	topic, err := a.host.JoinTopic(topicName)
 	if err != nil {
+		a.joinMu.Unlock()
 		return fmt.Errorf("failed to join topic: %w", err)
 	}
 
@@ -143,6 +263,9 @@ func (a *App) JoinRoom(roomName, password string) error {
 		Peers:    make(map[peer.ID]*PeerInfo),
 		Messages: make([]*ChatMessage, 0),
 		Password: password,
+		topic:    topic,
+		seen:     newSeenCache(maxSeenMessages),
+		syncing:  true,
 	}
 
 	if password != "" {
@@ -150,98 +273,227 @@ func (a *App) JoinRoom(roomName, password string) error {
 		logger.Info("Room encryption enabled")
 	}
 
-	a.currentRoom = room
-	a.currentRoomName = roomName
-	a.topic = topic
+	if a.historyDir != "" {
+		room.log, err = openRoomLog(a.historyDir, room, a.historyRetention)
+		if err != nil {
+			logger.Warn("Failed to open history of room %s: %v", roomName, err)
+		} else {
+			room.clock = room.log.clock
+		}
+	}
 
-	a.host.RegisterMessageHandler(p2p.MessageTypeChat, a.handleChatMessage)
+	a.roomsMu.Lock()
+	a.rooms[roomName] = room
+	a.roomsByTopic[topicName] = room
+	a.activeRoom = roomName
+	a.roomsMu.Unlock()
+	a.joinMu.Unlock()
 
-	go a.readMessages()
+	go a.readMessages(topic)
 
-	joinMsg := map[string]string{
-		"type":     string(MessageTypeJoin),
-		"nickname": a.user.Nickname,
+	joinMsg := chatPayload{
+		ID:       newMessageID(),
+		Type:     MessageTypeJoin,
+		Nickname: a.nickname(),
+		Clock:    room.tick(),
 	}
 	if err := topic.Publish(p2p.MessageTypeChat, joinMsg); err != nil {
 		logger.Warn("Failed to announce join: %v", err)
 	}
 
-	md := a.host.GetMetadata()
-	md.CurrentRoom = roomName
-	if password != "" {
-		md.Custom["room_encrypted"] = "true"
-	}
-	a.host.SetMetadata(md)
+	a.updateRoomMetadata()
+
+	go a.syncHistory(room)
 
 	logger.Info("Joined room: %s", roomName)
-	a.events <- Event{
-		Type: EventRoomJoined,
-		Data: room,
-	}
+	a.emit(Event{Type: EventRoomJoined, Data: room})
 
 	return nil
 }
 
+func cleanRoomName(roomName string) string {
+	roomName = sanitize(roomName)
+	if len(roomName) > maxRoomNameLength {
+		roomName = roomName[:maxRoomNameLength]
+	}
+	return roomName
+}
+
+// roomTopic namespaces room topics by domain so that peers from other
+// domains never share a mesh with us
+func (a *App) roomTopic(roomName, password string) string {
+	topicName := fmt.Sprintf("lanchat/%s/rooms/%s", a.domain, roomName)
+	if password != "" {
+		hash := sha256.Sum256([]byte(password))
+		topicName = fmt.Sprintf("%s/%x", topicName, hash[:8])
+	}
+	return topicName
+}
+
+// LeaveRoom leaves the active room
 func (a *App) LeaveRoom() error {
-	md := a.host.GetMetadata()
-	md.CurrentRoom = ""
-	delete(md.Custom, "room_encrypted")
-	a.host.SetMetadata(md)
+	a.roomsMu.RLock()
+	active := a.activeRoom
+	a.roomsMu.RUnlock()
 
-	if a.currentRoom == nil {
+	if active == "" {
 		return nil
 	}
+	return a.LeaveRoomByName(active)
+}
+
+// LeaveRoomByName leaves one of the joined rooms. When it was the active
+// room, the first remaining room by name becomes active.
+func (a *App) LeaveRoomByName(roomName string) error {
+	roomName = cleanRoomName(roomName)
+
+	a.roomsMu.Lock()
+	room, exists := a.rooms[roomName]
+	if !exists {
+		a.roomsMu.Unlock()
+		return fmt.Errorf("not in room %s", roomName)
+	}
+	delete(a.rooms, roomName)
+	delete(a.roomsByTopic, room.Topic)
+	if a.activeRoom == roomName {
+		a.activeRoom = ""
+		if remaining := slices.Sorted(maps.Keys(a.rooms)); len(remaining) > 0 {
+			a.activeRoom = remaining[0]
+		}
+	}
+	a.roomsMu.Unlock()
 
-	leaveMsg := map[string]string{
-		"type":     string(MessageTypeLeave),
-		"nickname": a.user.Nickname,
+	a.updateRoomMetadata()
+
+	leaveMsg := chatPayload{
+		ID:       newMessageID(),
+		Type:     MessageTypeLeave,
+		Nickname: a.nickname(),
+		Clock:    room.tick(),
 	}
-	if err := a.topic.Publish(p2p.MessageTypeChat, leaveMsg); err != nil {
+	if err := room.topic.Publish(p2p.MessageTypeChat, leaveMsg); err != nil {
 		logger.Warn("Failed to announce leave: %v", err)
 	}
 
-	if err := a.topic.Close(); err != nil {
+	if err := room.topic.Close(); err != nil {
 		logger.Warn("Error closing topic: %v", err)
 	}
+	if room.log != nil {
+		room.log.close()
+	}
 
-	logger.Info("Left room: %s", a.currentRoom.Name)
-
-	a.currentRoom = nil
-	a.currentRoomName = ""
-	a.topic = nil
+	logger.Info("Left room: %s", roomName)
 
 	return nil
 }
 
+// SwitchRoom makes a joined room the active one and returns the messages
+// that arrived in it while it wasn't
+func (a *App) SwitchRoom(roomName string) ([]*ChatMessage, error) {
+	roomName = cleanRoomName(roomName)
+
+	a.roomsMu.Lock()
+	room, exists := a.rooms[roomName]
+	if !exists {
+		a.roomsMu.Unlock()
+		return nil, fmt.Errorf("not in room %s", roomName)
+	}
+	a.activeRoom = roomName
+
+	room.mu.Lock()
+	// our own messages aren't counted but are shown between the others
+	start := len(room.Messages)
+	for unread := room.Unread; unread > 0 && start > 0; start-- {
+		if room.Messages[start-1].From != a.host.ID() {
+			unread--
+		}
+	}
+	missed := slices.Clone(room.Messages[start:])
+	room.Unread = 0
+	room.mu.Unlock()
+	a.roomsMu.Unlock()
+
+	for _, msg := range missed {
+		if msg.Type == MessageTypeText && msg.From != a.host.ID() {
+			a.acknowledge(room, msg.ID, true)
+		}
+	}
+
+	a.updateRoomMetadata()
+
+	return missed, nil
+}
+
+// updateRoomMetadata advertises the active room and every unencrypted room
+// we are in, encrypted rooms stay hidden
+func (a *App) updateRoomMetadata() {
+	a.metadataMu.Lock()
+	defer a.metadataMu.Unlock()
+
+	a.roomsMu.RLock()
+	active := a.rooms[a.activeRoom]
+	rooms := make([]string, 0, len(a.rooms))
+	for name, room := range a.rooms {
+		if room.Password == "" {
+			rooms = append(rooms, name)
+		}
+	}
+	a.roomsMu.RUnlock()
+	sort.Strings(rooms)
+
+	md := a.host.GetMetadata()
+	md.CurrentRoom = ""
+	md.Rooms = rooms
+	delete(md.Custom, "room_encrypted")
+	if active != nil {
+		md.CurrentRoom = active.Name
+		if active.Password != "" {
+			md.Custom["room_encrypted"] = "true"
+		}
+	}
+	a.host.SetMetadata(md)
+}
+
+// GetRoomList lists the rooms we are in, marking the active one and unread
+// counts, followed by the rooms other peers are in
 func (a *App) GetRoomList() []string {
-	roomSet := make(map[string]bool)
+	active := a.GetCurrentRoom()
+
+	rooms := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, room := range a.GetJoinedRooms() {
+		seen[room.Name] = true
 
-	if a.currentRoomName != "" {
-		roomName := a.currentRoomName
-		if a.currentRoom.Password != "" {
-			roomName = fmt.Sprintf("%s (encrypted)", roomName)
+		var tags []string
+		if room == active {
+			tags = append(tags, "active")
 		}
-		roomSet[roomName] = true
+		if room.Password != "" {
+			tags = append(tags, "encrypted")
+		}
+		if unread := room.UnreadCount(); unread > 0 {
+			tags = append(tags, fmt.Sprintf("%d unread", unread))
+		}
+
+		entry := room.Name
+		if len(tags) > 0 {
+			entry = fmt.Sprintf("%s (%s)", room.Name, strings.Join(tags, ", "))
+		}
+		rooms = append(rooms, entry)
 	}
 
-	for peerID := range a.peers {
-		metadata, err := a.host.RequestPeerMetadata(peerID)
-		if err == nil && metadata.CurrentRoom != "" {
-			if metadata.Custom["room_encrypted"] == "true" {
-				continue
-			}
-			roomName := sanitize(metadata.CurrentRoom)
-			if len(roomName) > 0 && len(roomName) <= maxRoomNameLength {
-				roomSet[roomName] = true
+	var others []string
+	for _, p := range a.GetPeers() {
+		for _, name := range p.Rooms {
+			if !seen[name] {
+				seen[name] = true
+				others = append(others, name)
 			}
 		}
 	}
+	sort.Strings(others)
 
-	rooms := make([]string, 0, len(roomSet))
-	for room := range roomSet {
-		rooms = append(rooms, room)
-	}
-	return rooms
+	return append(rooms, others...)
 }
 
 func (a *App) GetPeerList() []string {
@@ -249,60 +501,233 @@ func (a *App) GetPeerList() []string {
 	peerList := make([]string, 0)
 
 	for _, peer := range peers {
-		nickname := sanitize(peer.Nickname)
-		metadata, err := a.host.RequestPeerMetadata(peer.ID)
-		if err == nil && metadata.CurrentRoom != "" && metadata.Custom["room_encrypted"] != "true" {
-			roomName := sanitize(metadata.CurrentRoom)
-			peerList = append(peerList, fmt.Sprintf("%s (In room: %s)", nickname, roomName))
-		} else {
-			peerList = append(peerList, nickname)
+		entry := a.displayName(peer.ID, peer.Nickname)
+		if peer.Status != "" {
+			entry += fmt.Sprintf(" [%s]", peer.Status)
+		}
+		if idle := time.Since(peer.LastSeen); peer.Status != StatusOnline && idle >= time.Minute {
+			entry += fmt.Sprintf(" (last seen %s ago)", idle.Round(time.Minute))
 		}
+		if len(peer.Rooms) > 0 {
+			entry += fmt.Sprintf(" (In rooms: %s)", strings.Join(peer.Rooms, ", "))
+		}
+		peerList = append(peerList, entry)
 	}
 	return peerList
 }
 
+// SendMessage sends a message to the active room
 func (a *App) SendMessage(text string) error {
-	if a.currentRoom == nil {
+	room := a.GetCurrentRoom()
+	if room == nil {
 		return fmt.Errorf("not in a room")
 	}
+	return a.sendToRoom(room, text, "")
+}
+
+// SendMessageTo sends a message to one of the joined rooms
+func (a *App) SendMessageTo(roomName, text string) error {
+	room := a.GetRoom(roomName)
+	if room == nil {
+		return fmt.Errorf("not in room %s", roomName)
+	}
+	return a.sendToRoom(room, text, "")
+}
+
+// SendReply answers the message with ID parentID in a joined room. An
+// empty room name means the active room.
+func (a *App) SendReply(roomName, parentID, text string) error {
+	room, err := a.lookupRoom(roomName)
+	if err != nil {
+		return err
+	}
+	if !validMessageID(parentID) {
+		return fmt.Errorf("invalid message id %q", parentID)
+	}
+	return a.sendToRoom(room, text, parentID)
+}
+
+func (a *App) sendToRoom(room *Room, text, replyTo string) error {
+	messageText, err := encodeText(room, text)
+	if err != nil {
+		return err
+	}
 
+	msg := chatPayload{
+		ID:       newMessageID(),
+		Type:     MessageTypeText,
+		Text:     messageText,
+		Nickname: a.nickname(),
+		Clock:    room.tick(),
+		ReplyTo:  replyTo,
+	}
+
+	if err := room.topic.Publish(p2p.MessageTypeChat, msg); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	a.MarkActive()
+
+	// peers stop showing us as typing once the message arrives, so typing
+	// the next one should tell them right away
+	room.mu.Lock()
+	room.typingSent = time.Time{}
+	room.mu.Unlock()
+
+	return nil
+}
+
+// encodeText checks the text of a message we send and encrypts it in
+// password protected rooms
+func encodeText(room *Room, text string) (string, error) {
 	text = sanitize(text)
 	if len(text) == 0 {
-		return fmt.Errorf("message cannot be empty after sanitization")
+		return "", fmt.Errorf("message cannot be empty after sanitization")
 	}
 	if len(text) > maxMessageLength {
-		return fmt.Errorf("message too long (max %d characters)", maxMessageLength)
+		return "", fmt.Errorf("message too long (max %d characters)", maxMessageLength)
 	}
 
-	messageText := text
+	return sealText(room, text)
+}
 
-	if a.currentRoom.EncryptionKey != nil {
-		encrypted, err := Encrypt(text, a.currentRoom.EncryptionKey)
-		if err != nil {
-			return fmt.Errorf("failed to encrypt message: %w", err)
-		}
-		messageText = encrypted
+// sealText encrypts text in password protected rooms
+func sealText(room *Room, text string) (string, error) {
+	if room.EncryptionKey == nil {
+		return text, nil
 	}
+	encrypted, err := Encrypt(text, room.EncryptionKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt message: %w", err)
+	}
+	return encrypted, nil
+}
 
-	msg := map[string]string{
-		"type":     string(MessageTypeText),
-		"text":     messageText,
-		"nickname": a.user.Nickname,
+// openText is the reverse of sealText
+func openText(room *Room, nickname, text string) (string, bool) {
+	if room.EncryptionKey == nil {
+		return text, true
 	}
+	decrypted, err := Decrypt(text, room.EncryptionKey)
+	if err != nil {
+		logger.Warn("Failed to decrypt message from %s (wrong password?): %v", nickname, err)
+		return "", false
+	}
+	return decrypted, true
+}
 
-	if err := a.topic.Publish(p2p.MessageTypeChat, msg); err != nil {
-		return fmt.Errorf("failed to send message: %w", err)
+// decodeText is the reverse of encodeText for received messages, ok is
+// false when there is nothing to show
+func decodeText(room *Room, nickname, text string) (string, bool) {
+	text, ok := openText(room, nickname, text)
+	if !ok {
+		return "", false
 	}
 
-	return nil
+	text = sanitize(text)
+	if len(text) == 0 {
+		logger.Debug("Dropped empty message after sanitization from %s", nickname)
+		return "", false
+	}
+	if len(text) > maxMessageLength {
+		text = text[:maxMessageLength]
+		logger.Debug("Truncated oversized message from %s", nickname)
+	}
+	return text, true
 }
 
 func (a *App) GetEvents() <-chan Event {
 	return a.events
 }
 
+// GetUser returns a copy of our user
+func (a *App) GetUser() *User {
+	a.userMu.RLock()
+	defer a.userMu.RUnlock()
+
+	user := *a.user
+	return &user
+}
+
+// GetIdentity returns the local "@adjective-animal-N" handle
+func (a *App) GetIdentity() string {
+	return GetIdentity(a.host.ID())
+}
+
+func (a *App) GetPeerID() string {
+	return a.host.ID().String()
+}
+
+// GetAddrs returns the multiaddrs other peers can use to dial us
+func (a *App) GetAddrs() []string {
+	return a.host.DialableAddrs()
+}
+
+// GetNetInfo lists the peers we are connected to with their gossipsub
+// scores, sorted by nickname
+func (a *App) GetNetInfo() []PeerNetInfo {
+	scores := a.host.PeerScores()
+
+	connected := a.host.Network().Peers()
+	info := make([]PeerNetInfo, 0, len(connected))
+	for _, id := range connected {
+		pi := PeerNetInfo{
+			ID:       id,
+			Nickname: a.peerNickname(id, ""),
+			Identity: GetIdentity(id),
+		}
+		for _, conn := range a.host.Network().ConnsToPeer(id) {
+			pi.Addrs = append(pi.Addrs, conn.RemoteMultiaddr().String())
+		}
+		pi.Score, pi.HasScore = scores[id]
+		info = append(info, pi)
+	}
+
+	sort.Slice(info, func(i, j int) bool {
+		return info[i].Nickname < info[j].Nickname
+	})
+	return info
+}
+
+// GetCurrentRoom returns the active room, nil when not in any
 func (a *App) GetCurrentRoom() *Room {
-	return a.currentRoom
+	a.roomsMu.RLock()
+	defer a.roomsMu.RUnlock()
+	return a.rooms[a.activeRoom]
+}
+
+// GetRoom returns a joined room by name, nil when not in it
+func (a *App) GetRoom(roomName string) *Room {
+	a.roomsMu.RLock()
+	defer a.roomsMu.RUnlock()
+	return a.rooms[cleanRoomName(roomName)]
+}
+
+// GetRoomMembers returns the IDs of the peers we know to be in a joined
+// room, not counting us
+func (a *App) GetRoomMembers(roomName string) []string {
+	room := a.GetRoom(roomName)
+	if room == nil {
+		return nil
+	}
+
+	members := room.topic.ListPeers()
+	ids := make([]string, 0, len(members))
+	for _, id := range members {
+		ids = append(ids, id.String())
+	}
+	return ids
+}
+
+// GetJoinedRooms returns every room we are in, sorted by name
+func (a *App) GetJoinedRooms() []*Room {
+	a.roomsMu.RLock()
+	defer a.roomsMu.RUnlock()
+
+	rooms := make([]*Room, 0, len(a.rooms))
+	for _, name := range slices.Sorted(maps.Keys(a.rooms)) {
+		rooms = append(rooms, a.rooms[name])
+	}
+	return rooms
 }
 
 func (a *App) GetPeers() []*PeerInfo {
@@ -347,30 +772,124 @@ func (a *App) isPeerMuted(peerId peer.ID) bool {
 	return false
 }
 
+// Close leaves all rooms and shuts the host down, later calls do nothing
 func (a *App) Close() error {
+	a.eventsMu.RLock()
+	closed := a.closed
+	a.eventsMu.RUnlock()
+	if closed {
+		return nil
+	}
+
 	logger.Info("Closing app")
 
-	if a.currentRoom != nil {
-		a.LeaveRoom()
+	for _, room := range a.GetJoinedRooms() {
+		a.LeaveRoomByName(room.Name)
 	}
 
 	a.cancel()
+
+	a.eventsMu.Lock()
+	a.closed = true
 	close(a.events)
+	a.eventsMu.Unlock()
+
 	return a.host.Close()
 }
 
+// emit delivers an event unless the app is shutting down
+func (a *App) emit(event Event) {
+	a.eventsMu.RLock()
+	defer a.eventsMu.RUnlock()
+
+	if a.closed {
+		return
+	}
+
+	select {
+	case a.events <- event:
+	case <-a.ctx.Done():
+	}
+}
+
 func (a *App) handlePeerDiscovery() {
 	for {
 		select {
 		case <-a.ctx.Done():
 			return
 		case peerInfo := <-a.host.GetPeerChan():
-			// connect, let network events handle rest
-			if err := a.host.Connect(a.ctx, peerInfo); err != nil {
-				logger.Debug("Failed to connect to peer %s: %v", peerInfo.ID.String()[:8], err)
+			// a dial can hang until it times out, e.g. against a peer
+			// holding another domain secret, so it mustn't hold up the rest
+			go func() {
+				if err := a.connectPeer(peerInfo); err != nil {
+					logger.Debug("Failed to connect to peer %s: %v", peerInfo.ID.String()[:8], err)
+				}
+			}()
+		}
+	}
+}
+
+// connectPeer dials a discovered or manually added peer, network events
+// handle the rest
+func (a *App) connectPeer(peerInfo peer.AddrInfo) error {
+	if peerInfo.ID == a.host.ID() {
+		return fmt.Errorf("cannot connect to self")
+	}
+
+	ctx, cancel := context.WithTimeout(a.ctx, connectTimeout)
+	defer cancel()
+	return a.host.Connect(ctx, peerInfo)
+}
+
+// ConnectPeer dials a peer by multiaddr, which must include /p2p/<peer-id>
+func (a *App) ConnectPeer(addr string) error {
+	peerInfo, err := p2p.ParseAddrInfo(addr)
+	if err != nil {
+		return err
+	}
+
+	if err := a.connectPeer(*peerInfo); err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", peerInfo.ID.String()[:8], err)
+	}
+	return nil
+}
+
+func discoveryBackends(domain string, opts Options) []p2p.Discovery {
+	var backends []p2p.Discovery
+
+	if !opts.DisableMDNS {
+		backends = append(backends, p2p.NewMDNSDiscovery(domain))
+	}
+
+	if len(opts.BootstrapPeers) > 0 {
+		peers := make([]peer.AddrInfo, 0, len(opts.BootstrapPeers))
+		for _, addr := range opts.BootstrapPeers {
+			peerInfo, err := p2p.ParseAddrInfo(addr)
+			if err != nil {
+				logger.Warn("Skipping bootstrap peer %s: %v", addr, err)
+				continue
 			}
+			peers = append(peers, *peerInfo)
 		}
+		backends = append(backends, p2p.NewStaticDiscovery(peers))
+	}
+
+	return append(backends, opts.Discovery...)
+}
+
+// RegisterRPC serves a custom method to peers. A nil opts uses the
+// defaults.
+func (a *App) RegisterRPC(method string, handler p2p.RPCHandler, opts *p2p.RPCOptions) {
+	a.host.RegisterRPC(method, handler, opts)
+}
+
+// CallRPC invokes a method on the peer with the given peer ID
+func (a *App) CallRPC(ctx context.Context, peerID, method string, req, resp any, opts *p2p.RPCOptions) error {
+	id, err := peer.Decode(peerID)
+	if err != nil {
+		return fmt.Errorf("invalid peer id: %w", err)
 	}
+	return a.host.Call(ctx, id, method, req, resp, opts)
 }
 
 func (a *App) handlePeerEvents() {
@@ -399,10 +918,7 @@ func (a *App) removePeer(peerId peer.ID) {
 
 	if exists {
 		logger.Info("Peer %s disconnected: %s", peerId.String()[:8], peer.Nickname)
-		a.events <- Event{
-			Type: EventPeerLeft,
-			Data: peer,
-		}
+		a.emit(Event{Type: EventPeerLeft, Data: peer})
 	}
 }
 
@@ -413,14 +929,53 @@ func (a *App) onPeerConnected(peerId peer.ID) {
 
 	logger.Debug("Peer connected: %s", peerId.String()[:8])
 
-	time.Sleep(500 * time.Millisecond)
-
 	md, err := a.host.RequestPeerMetadata(peerId)
 	if err != nil {
 		logger.Warn("Failed to get metadata from peer %s: %v", peerId.String()[:8], err)
 		return
 	}
 
+	if md.Domain != a.domain {
+		logger.Debug("Ignoring peer %s from domain %q", peerId.String()[:8], md.Domain)
+		return
+	}
+
+	peerInfo, isNew := a.applyPeerMetadata(peerId, md)
+	if !isNew || peerInfo == nil {
+		return
+	}
+
+	logger.Info("Peer %s connected: %s", peerId.String()[:8], peerInfo.Nickname)
+
+	a.emit(Event{Type: EventPeerJoined, Data: peerInfo})
+}
+
+// handleMetadataUpdates keeps the peer cache current with metadata that
+// peers push to us whenever it changes
+func (a *App) handleMetadataUpdates() {
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case update := <-a.host.GetMetadataChan():
+			md := update.Metadata
+			if !a.host.IsConnected(update.PeerID) {
+				continue
+			}
+
+			peerInfo, isNew := a.applyPeerMetadata(update.PeerID, &md)
+			if isNew && peerInfo != nil {
+				logger.Info("Peer %s connected: %s", update.PeerID.String()[:8], peerInfo.Nickname)
+				a.emit(Event{Type: EventPeerJoined, Data: peerInfo})
+			}
+		}
+	}
+}
+
+// applyPeerMetadata sanitizes a peer's metadata into the peer cache and
+// reports whether the peer was previously unknown. It returns nil when the
+// cache already holds newer metadata.
+func (a *App) applyPeerMetadata(peerId peer.ID, md *p2p.MetadataResponse) (*PeerInfo, bool) {
 	nickname := sanitize(md.Nickname)
 	if len(nickname) == 0 {
 		nickname = "Unknown"
@@ -429,43 +984,121 @@ func (a *App) onPeerConnected(peerId peer.ID) {
 		nickname = nickname[:maxNicknameLength]
 	}
 
-	status := md.Custom["status"]
-	if len(status) > 50 {
-		status = status[:50]
+	status := cleanStatus(md.Custom["status"])
+
+	currentRoom := ""
+	if md.Custom["room_encrypted"] != "true" {
+		currentRoom = sanitize(md.CurrentRoom)
+		if len(currentRoom) > maxRoomNameLength {
+			currentRoom = ""
+		}
+	}
+
+	// peers that predate multiple rooms only advertise their current one
+	rooms := make([]string, 0, len(md.Rooms))
+	for _, name := range md.Rooms {
+		name = sanitize(name)
+		if len(name) == 0 || len(name) > maxRoomNameLength || slices.Contains(rooms, name) {
+			continue
+		}
+		rooms = append(rooms, name)
+		if len(rooms) == maxJoinedRooms {
+			break
+		}
+	}
+	if len(rooms) == 0 && currentRoom != "" {
+		rooms = append(rooms, currentRoom)
+	}
+
+	metadata := make(map[string]string, len(md.Custom))
+	for k, v := range md.Custom {
+		metadata[k] = v
 	}
 
 	a.peersMu.Lock()
+	previous, exists := a.peers[peerId]
+	if exists {
+		if md.Seq != 0 && md.Seq <= previous.seq {
+			a.peersMu.Unlock()
+			return nil, false
+		}
+		if muted, ok := previous.Metadata["is_muted"]; ok {
+			metadata["is_muted"] = muted
+		}
+	}
 	peerInfo := &PeerInfo{
-		ID:       peerId,
-		Nickname: nickname,
-		Status:   status,
-		LastSeen: time.Now(),
-		Metadata: md.Custom,
+		ID:          peerId,
+		Nickname:    nickname,
+		Status:      status,
+		CurrentRoom: currentRoom,
+		Rooms:       rooms,
+		LastSeen:    time.Now(),
+		Metadata:    metadata,
+		seq:         md.Seq,
 	}
 	a.peers[peerId] = peerInfo
 	a.peersMu.Unlock()
 
-	logger.Info("Peer %s connected: %s", peerId.String()[:8], md.Nickname)
+	a.host.AddressBook().SetNickname(peerId, nickname)
+	if exists {
+		a.updateRoomPeers(peerInfo)
+	}
 
-	a.events <- Event{
-		Type: EventPeerJoined,
-		Data: peerInfo,
+	if exists && previous.Nickname != nickname {
+		a.emit(Event{Type: EventNicknameChange, Data: &NicknameChange{
+			From:     peerId,
+			Identity: GetIdentity(peerId),
+			Previous: previous.Nickname,
+			Nickname: nickname,
+		}})
 	}
+	if exists && previous.Status != status {
+		a.emit(Event{Type: EventStatusChange, Data: peerInfo})
+	}
+
+	return peerInfo, !exists
 }
 
-func (a *App) readMessages() {
-	msgChan := a.topic.ReadMessages(a.ctx)
-	for msg := range msgChan {
+func (a *App) readMessages(topic *p2p.Topic) {
+	for range topic.ReadMessages(a.ctx) {
 		// messages dealt with by handler
-		_ = msg
 	}
 }
 
+// roomForTopic returns the joined room a pubsub topic belongs to
+func (a *App) roomForTopic(topicName string) *Room {
+	a.roomsMu.RLock()
+	defer a.roomsMu.RUnlock()
+	return a.roomsByTopic[topicName]
+}
+
 func (a *App) handleChatMessage(msg *p2p.Message) error {
-	if a.currentRoom == nil {
+	room := a.roomForTopic(msg.Topic)
+	if room == nil {
+		return nil
+	}
+
+	room.mu.Lock()
+	if room.syncing {
+		room.pending = append(room.pending, msg)
+		room.mu.Unlock()
 		return nil
 	}
+	room.mu.Unlock()
 
+	// handlers run in arrival order and pubsub hands us our own messages
+	// in the order we published them, so they need no reordering
+	if msg.Local {
+		return a.deliverChatMessage(room, msg, false)
+	}
+	a.buffer(room, msg)
+	return nil
+}
+
+// deliverChatMessage adds a chat message to its room and emits it
+func (a *App) deliverChatMessage(room *Room, msg *p2p.Message, fromHistory bool) error {
+	// From has been checked against the signed origin, by the topic
+	// validator or OpenSignedMessage
 	peerID, err := peer.Decode(msg.From)
 	if err != nil {
 		logger.Warn("Invalid peer ID in message: %v", err)
@@ -477,20 +1110,41 @@ func (a *App) handleChatMessage(msg *p2p.Message) error {
 		return nil
 	}
 
-	if !a.rateLimiter.Allow(peerID) {
-		logger.Warn("Rate limit exceeded for peer %s", peerID.String()[:8])
-		return nil
-	}
-
-	var content map[string]interface{}
+	var content chatPayload
 	if err := json.Unmarshal(msg.Data, &content); err != nil {
 		logger.Warn("Failed to parse message: %v", err)
 		return err
 	}
 
-	msgType := MessageTypeText
-	if t, ok := content["type"].(string); ok {
-		msgType = MessageType(t)
+	msgType := content.Type
+	msgID := content.ID
+	if msgID == "" {
+		msgID = legacyMessageID(msg.PubsubID)
+	}
+
+	// IDs are only unique per sender, so nobody can suppress someone else's
+	// message by reusing its ID
+	seenKey := msg.From + "/" + msgID
+	if msgType == MessageTypeTyping {
+		// typing signals are not worth a place in the seen cache, and
+		// we know when we are typing
+		if msg.Local || fromHistory {
+			return nil
+		}
+	} else {
+		if !room.seen.add(seenKey) {
+			return nil
+		}
+		room.observe(content.Clock)
+	}
+
+	// our own live joins, leaves and nickname changes are already on screen
+	if msg.Local && !fromHistory && (msgType == MessageTypeJoin || msgType == MessageTypeLeave || msgType == MessageTypeNick) {
+		return nil
+	}
+
+	if !fromHistory {
+		a.touchPeer(peerID)
 	}
 
 	// Get peer info
@@ -501,8 +1155,8 @@ func (a *App) handleChatMessage(msg *p2p.Message) error {
 	nickname := "Unknown"
 	if peerInfo != nil {
 		nickname = peerInfo.Nickname
-	} else if n, ok := content["nickname"].(string); ok {
-		nickname = sanitize(n)
+	} else if content.Nickname != "" {
+		nickname = sanitize(content.Nickname)
 		if len(nickname) == 0 {
 			nickname = "Unknown"
 		}
@@ -512,96 +1166,231 @@ func (a *App) handleChatMessage(msg *p2p.Message) error {
 	}
 
 	identity := GetIdentity(peerID)
+	if msgType == MessageTypeNick {
+		nickname = cleanNickname(content.Nickname)
+	}
+	nickname = a.displayName(peerID, nickname)
 
 	switch msgType {
 	case MessageTypeJoin:
-		logger.Debug("Peer %s joined room", nickname)
+		logger.Debug("Peer %s joined room %s", nickname, room.Name)
 		if peerInfo != nil {
-			a.currentRoom.Peers[peerID] = peerInfo
+			room.mu.Lock()
+			room.Peers[peerID] = peerInfo
+			room.mu.Unlock()
 		}
 
 		chatMsg := &ChatMessage{
-			ID:        fmt.Sprintf("%d", time.Now().UnixNano()),
+			ID:        msgID,
+			Room:      room.Name,
 			From:      peerID,
 			Identity:  identity,
 			Nickname:  nickname,
 			Content:   fmt.Sprintf("%s joined the room", nickname),
 			Timestamp: msg.Timestamp,
+			Clock:     content.Clock,
 			Type:      MessageTypeJoin,
 		}
-		a.addMessageToRoom(chatMsg)
-		a.events <- Event{Type: EventMessageRecv, Data: chatMsg}
+		a.addMessageToRoom(room, chatMsg)
+		a.emit(Event{Type: EventMessageRecv, Data: chatMsg})
 
 	case MessageTypeLeave:
-		logger.Debug("Peer %s left room", nickname)
-		delete(a.currentRoom.Peers, peerID)
+		logger.Debug("Peer %s left room %s", nickname, room.Name)
+		room.mu.Lock()
+		delete(room.Peers, peerID)
+		room.mu.Unlock()
 
 		chatMsg := &ChatMessage{
-			ID:        fmt.Sprintf("%d", time.Now().UnixNano()),
+			ID:        msgID,
+			Room:      room.Name,
 			From:      peerID,
 			Identity:  identity,
 			Nickname:  nickname,
 			Content:   fmt.Sprintf("%s left the room", nickname),
 			Timestamp: msg.Timestamp,
+			Clock:     content.Clock,
 			Type:      MessageTypeLeave,
 		}
-		a.currentRoom.Messages = append(a.currentRoom.Messages, chatMsg)
-		a.events <- Event{Type: EventMessageRecv, Data: chatMsg}
+		a.addMessageToRoom(room, chatMsg)
+		a.emit(Event{Type: EventMessageRecv, Data: chatMsg})
+
+	case MessageTypeNick:
+		previous := a.renamePeer(peerID, cleanNickname(content.Nickname))
+		if previous == "" {
+			previous = cleanNickname(content.Previous)
+		}
+
+		chatMsg := &ChatMessage{
+			ID:        msgID,
+			Room:      room.Name,
+			From:      peerID,
+			Identity:  identity,
+			Nickname:  nickname,
+			Content:   fmt.Sprintf("%s is now %s", previous, nickname),
+			Timestamp: msg.Timestamp,
+			Clock:     content.Clock,
+			Type:      MessageTypeNick,
+		}
+		a.addMessageToRoom(room, chatMsg)
+		a.emit(Event{Type: EventMessageRecv, Data: chatMsg})
 
 	case MessageTypeText:
-		text, ok := content["text"].(string)
+		text, ok := decodeText(room, nickname, content.Text)
 		if !ok {
 			return nil
 		}
 
-		if a.currentRoom.EncryptionKey != nil {
-			decrypted, err := Decrypt(text, a.currentRoom.EncryptionKey)
-			if err != nil {
-				logger.Warn("Failed to decrypt message from %s (wrong password?): %v", nickname, err)
+		chatMsg := &ChatMessage{
+			ID:        msgID,
+			Room:      room.Name,
+			From:      peerID,
+			Identity:  identity,
+			Nickname:  nickname,
+			Content:   text,
+			Timestamp: msg.Timestamp,
+			Clock:     content.Clock,
+			Type:      MessageTypeText,
+			History:   fromHistory,
+			ReplyTo:   content.ReplyTo,
+		}
+		room.remember(msg.Signed)
+		a.storeMessage(room, newStoredMessage(seenKey, chatMsg))
+		a.addMessageToRoom(room, chatMsg)
+		if msg.Local {
+			if !fromHistory {
+				return nil
+			}
+		} else {
+			a.acknowledge(room, msgID, a.isActiveRoom(room))
+		}
+		a.emit(Event{Type: EventMessageRecv, Data: chatMsg})
+
+	case MessageTypeEdit, MessageTypeDelete:
+		text := ""
+		if msgType == MessageTypeEdit {
+			var ok bool
+			if text, ok = decodeText(room, nickname, content.Text); !ok {
 				return nil
-			} else {
-				text = decrypted
 			}
+		}
 
+		change := &ChatMessage{
+			ID:        msgID,
+			Room:      room.Name,
+			From:      peerID,
+			Identity:  identity,
+			Nickname:  nickname,
+			Content:   text,
+			Timestamp: msg.Timestamp,
+			Clock:     content.Clock,
+			Type:      msgType,
 		}
+		room.remember(msg.Signed)
+		stored := newStoredMessage(seenKey, change)
+		stored.Target = content.Target
+		a.storeMessage(room, stored)
 
-		text = sanitize(text)
-		if len(text) == 0 {
-			logger.Debug("Dropped empty message after sanitization from %s", nickname)
+		changed := room.applyChange(change, content.Target)
+		if changed == nil || msg.Local && !fromHistory {
 			return nil
 		}
-		if len(text) > maxMessageLength {
-			text = text[:maxMessageLength]
-			logger.Debug("Truncated oversized message from %s", nickname)
+		eventType := EventMessageEdited
+		if msgType == MessageTypeDelete {
+			eventType = EventMessageDeleted
 		}
+		a.emit(Event{Type: eventType, Data: changed})
 
-		chatMsg := &ChatMessage{
-			ID:        fmt.Sprintf("%d", time.Now().UnixNano()),
+	case MessageTypeReaction:
+		reaction, ok := openText(room, nickname, content.Reaction)
+		if !ok {
+			return nil
+		}
+		reaction = sanitizeReaction(reaction)
+		if reaction == "" || len(reaction) > maxReactionLength {
+			logger.Debug("Dropped invalid reaction from %s", nickname)
+			return nil
+		}
+
+		r := &Reaction{
+			Room:      room.Name,
+			MessageID: content.Target,
 			From:      peerID,
 			Identity:  identity,
 			Nickname:  nickname,
-			Content:   text,
+			Reaction:  reaction,
+			Removed:   content.Remove,
+			History:   fromHistory,
+		}
+		room.remember(msg.Signed)
+		a.storeMessage(room, &storedMessage{
+			Key:       seenKey,
+			ID:        msgID,
+			From:      msg.From,
+			Nickname:  nickname,
+			Content:   reaction,
 			Timestamp: msg.Timestamp,
-			Type:      MessageTypeText,
+			Clock:     content.Clock,
+			Type:      MessageTypeReaction,
+			Target:    content.Target,
+			Remove:    content.Remove,
+		})
+
+		r.Message = room.applyReaction(r)
+		if r.Message == nil || msg.Local && !fromHistory {
+			return nil
+		}
+		a.emit(Event{Type: EventReaction, Data: r})
+
+	case MessageTypeTyping:
+		a.emit(Event{Type: EventTyping, Data: &Typing{
+			Room:     room.Name,
+			From:     peerID,
+			Identity: identity,
+			Nickname: nickname,
+			Expires:  time.Now().Add(typingTimeout),
+		}})
+
+	case MessageTypeReceipt:
+		if msg.Local {
+			return nil
+		}
+		for i, id := range append(content.Delivered, content.Read...) {
+			changed := room.applyReceipt(peerID, id, i >= len(content.Delivered))
+			if changed != nil && changed.From == a.host.ID() {
+				a.emit(Event{Type: EventReceipt, Data: changed})
+			}
 		}
-		a.currentRoom.Messages = append(a.currentRoom.Messages, chatMsg)
-		a.events <- Event{Type: EventMessageRecv, Data: chatMsg}
 	}
 
 	return nil
 }
 
-func (a *App) addMessageToRoom(msg *ChatMessage) {
-	if a.currentRoom == nil {
-		return
-	}
+func (a *App) isActiveRoom(room *Room) bool {
+	a.roomsMu.RLock()
+	defer a.roomsMu.RUnlock()
+	return a.activeRoom == room.Name
+}
+
+// addMessageToRoom adds a message to the room in order, counting it as
+// unread unless the room is active
+func (a *App) addMessageToRoom(room *Room, msg *ChatMessage) {
+	a.roomsMu.RLock()
+	defer a.roomsMu.RUnlock()
 
-	a.currentRoom.mu.Lock()
-	defer a.currentRoom.mu.Unlock()
+	room.mu.Lock()
+	defer room.mu.Unlock()
 
-	a.currentRoom.Messages = append(a.currentRoom.Messages, msg)
-	if len(a.currentRoom.Messages) > maxMessagesPerRoom {
-		a.currentRoom.Messages = a.currentRoom.Messages[len(a.currentRoom.Messages)-maxMessagesPerRoom:]
+	// late messages go where they belong rather than at the end
+	i := len(room.Messages)
+	for i > 0 && compareKeys(chatMessageKey(msg), chatMessageKey(room.Messages[i-1])) < 0 {
+		i--
+	}
+	room.Messages = slices.Insert(room.Messages, i, msg)
+	if len(room.Messages) > maxMessagesPerRoom {
+		room.Messages = room.Messages[len(room.Messages)-maxMessagesPerRoom:]
+	}
+	if a.activeRoom != room.Name && msg.From != a.host.ID() {
+		room.Unread++
 	}
 }
 
@@ -624,22 +1413,25 @@ func (a *App) startRateLimiterCleanup() {
 				ticker.Stop()
 				return
 			case <-ticker.C:
-				a.cleanupRateLimiter()
+				a.cleanupRateLimiter(a.rateLimiter)
+				a.cleanupRateLimiter(a.typingLimiter)
+				a.cleanupRateLimiter(a.receiptLimiter)
+				a.cleanupRateLimiter(a.statusLimiter)
 			}
 		}
 	}()
 }
 
-func (a *App) cleanupRateLimiter() {
-	a.rateLimiter.mu.Lock()
-	defer a.rateLimiter.mu.Unlock()
+func (a *App) cleanupRateLimiter(rl *RateLimiter) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
 
 	now := time.Now()
-	cutoff := now.Add(-a.rateLimiter.window * 2)
+	cutoff := now.Add(-rl.window * 2)
 
-	for peerID, times := range a.rateLimiter.messages {
+	for peerID, times := range rl.messages {
 		if len(times) == 0 || times[len(times)-1].Before(cutoff) {
-			delete(a.rateLimiter.messages, peerID)
+			delete(rl.messages, peerID)
 		}
 	}
 }
@@ -3,12 +3,18 @@ package app
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"math/big"
 
+	"github.com/libp2p/go-libp2p/core/crypto"
+	pb "github.com/libp2p/go-libp2p/core/crypto/pb"
 	"golang.org/x/crypto/pbkdf2"
 )
 
@@ -23,6 +29,13 @@ func DeriveKey(password, roomName string) []byte {
 	return pbkdf2.Key([]byte(password), salt[:], iterations, keySize, sha256.New)
 }
 
+// DeriveNetworkKey turns a domain secret into the 32 byte pre-shared key of
+// the domain's private network
+func DeriveNetworkKey(domain, secret string) []byte {
+	salt := sha256.Sum256([]byte("lanchat/pnet/" + domain))
+	return pbkdf2.Key([]byte(secret), salt[:], iterations, keySize, sha256.New)
+}
+
 func Encrypt(text string, key []byte) (string, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -73,6 +86,144 @@ func Decrypt(ciphertext string, key []byte) (string, error) {
 	return string(plaintext), nil
 }
 
+const directKeyInfo = "lanchat/dm/1"
+
+// sealDirect encrypts plaintext so that only the holder of the recipient's
+// libp2p key can read it. A fresh X25519 key is agreed with the recipient's
+// Ed25519 key (in Montgomery form) for every message.
+func sealDirect(plaintext, aad []byte, recipient crypto.PubKey) (ephemeral, sealed []byte, err error) {
+	remote, err := x25519PublicKey(recipient)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	shared, err := eph.ECDH(remote)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	gcm, err := directCipher(shared, eph.PublicKey(), remote)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+
+	return eph.PublicKey().Bytes(), gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// openDirect reverses sealDirect with our own libp2p key
+func openDirect(ephemeral, sealed, aad []byte, priv crypto.PrivKey) ([]byte, error) {
+	own, err := x25519PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	ephPub, err := ecdh.X25519().NewPublicKey(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %w", err)
+	}
+
+	shared, err := own.ECDH(ephPub)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := directCipher(shared, ephPub, own.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := gcm.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return gcm.Open(nil, sealed[:nonceSize], sealed[nonceSize:], aad)
+}
+
+// directCipher derives the message key from the shared secret, bound to
+// both public keys of the exchange
+func directCipher(shared []byte, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	salt := append(ephemeral.Bytes(), recipient.Bytes()...)
+
+	key, err := hkdf.Key(sha256.New, shared, salt, directKeyInfo, keySize)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// curve25519 field prime, 2^255 - 19
+var fieldPrime, _ = new(big.Int).SetString("7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffed", 16)
+
+// x25519PublicKey maps an Ed25519 public key to its X25519 equivalent,
+// u = (1 + y) / (1 - y)
+func x25519PublicKey(pub crypto.PubKey) (*ecdh.PublicKey, error) {
+	if pub.Type() != pb.KeyType_Ed25519 {
+		return nil, fmt.Errorf("unsupported key type %s", pub.Type())
+	}
+
+	raw, err := pub.Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	// y is little endian with the sign of x in the top bit
+	le := make([]byte, len(raw))
+	copy(le, raw)
+	le[31] &= 0x7f
+	y := new(big.Int).SetBytes(reverse(le))
+
+	one := big.NewInt(1)
+	num := new(big.Int).Add(one, y)
+	den := new(big.Int).Sub(one, y)
+	den.Mod(den, fieldPrime)
+	if den.Sign() == 0 {
+		return nil, fmt.Errorf("invalid public key")
+	}
+
+	u := num.Mul(num, den.ModInverse(den, fieldPrime))
+	u.Mod(u, fieldPrime)
+
+	return ecdh.X25519().NewPublicKey(reverse(u.FillBytes(make([]byte, 32))))
+}
+
+// x25519PrivateKey derives the X25519 scalar of an Ed25519 private key, the
+// same way Ed25519 derives its signing scalar
+func x25519PrivateKey(priv crypto.PrivKey) (*ecdh.PrivateKey, error) {
+	if priv.Type() != pb.KeyType_Ed25519 {
+		return nil, fmt.Errorf("unsupported key type %s", priv.Type())
+	}
+
+	raw, err := priv.Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha512.Sum512(raw[:32])
+	return ecdh.X25519().NewPrivateKey(h[:32])
+}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
 func getHash(data string) string {
 	hasher := sha256.New()
 	hasher.Write([]byte(data))
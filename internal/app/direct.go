@@ -0,0 +1,250 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/matt0792/lanchat/internal/logger"
+	"github.com/matt0792/lanchat/internal/p2p"
+)
+
+const (
+	directTimeout    = 10 * time.Second
+	maxDirectPayload = 16 << 10
+)
+
+// directEnvelope is what goes over the wire, only the recipient can open it
+type directEnvelope struct {
+	Ephemeral  []byte `json:"ephemeral"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// directPayload is the plaintext inside a directEnvelope
+type directPayload struct {
+	// ID is assigned by the sender, older peers leave it out
+	ID        string    `json:"id,omitempty"`
+	Nickname  string    `json:"nickname"`
+	Text      string    `json:"text"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type directAck struct {
+	Error string `json:"error,omitempty"`
+}
+
+// directAAD ties a ciphertext to its sender and recipient, so it can't be
+// replayed as coming from someone else
+func directAAD(from, to peer.ID) []byte {
+	return []byte(from.String() + "/" + to.String())
+}
+
+// SendDirect sends a private message to one peer over its own stream,
+// encrypted to the peer's identity key
+func (a *App) SendDirect(peerID, text string) error {
+	to, err := peer.Decode(peerID)
+	if err != nil {
+		return fmt.Errorf("invalid peer id: %w", err)
+	}
+	if to == a.host.ID() {
+		return fmt.Errorf("can't message yourself")
+	}
+
+	text = sanitize(text)
+	if len(text) == 0 {
+		return fmt.Errorf("message is empty")
+	}
+	if len(text) > maxMessageLength {
+		return fmt.Errorf("message too long (max %d characters)", maxMessageLength)
+	}
+	a.MarkActive()
+
+	pub, err := to.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("failed to get peer key: %w", err)
+	}
+
+	plaintext, err := json.Marshal(directPayload{
+		ID:        newMessageID(),
+		Nickname:  a.nickname(),
+		Text:      text,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	ephemeral, sealed, err := sealDirect(plaintext, directAAD(a.host.ID(), to), pub)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt message: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(a.ctx, directTimeout)
+	defer cancel()
+
+	stream, err := a.host.NewStream(ctx, to, p2p.ProtocolDirectMessage)
+	if err != nil {
+		return fmt.Errorf("failed to reach peer: %w", err)
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(directTimeout))
+
+	if err := json.NewEncoder(stream).Encode(directEnvelope{Ephemeral: ephemeral, Ciphertext: sealed}); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if err := stream.CloseWrite(); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+
+	var ack directAck
+	if err := json.NewDecoder(stream).Decode(&ack); err != nil {
+		return fmt.Errorf("no delivery confirmation: %w", err)
+	}
+	if ack.Error != "" {
+		return fmt.Errorf("message rejected: %s", ack.Error)
+	}
+
+	return nil
+}
+
+func (a *App) handleDirectStream(stream network.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(directTimeout))
+
+	from := stream.Conn().RemotePeer()
+
+	msg, err := a.readDirect(stream, from)
+	if err != nil {
+		logger.Debug("Rejected direct message from %s: %v", from.String()[:8], err)
+		json.NewEncoder(stream).Encode(directAck{Error: err.Error()})
+		return
+	}
+
+	if err := json.NewEncoder(stream).Encode(directAck{}); err != nil {
+		logger.Debug("Failed to acknowledge direct message: %v", err)
+	}
+
+	if msg != nil {
+		a.emit(Event{Type: EventDirectMessage, Data: msg})
+	}
+}
+
+// readDirect decrypts a direct message. Messages from muted peers are
+// accepted but dropped, so the sender can't tell.
+func (a *App) readDirect(stream network.Stream, from peer.ID) (*ChatMessage, error) {
+	if !a.rateLimiter.Allow(from) {
+		return nil, fmt.Errorf("rate limited")
+	}
+	a.touchPeer(from)
+
+	data, err := io.ReadAll(io.LimitReader(stream, maxDirectPayload+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message")
+	}
+	if len(data) > maxDirectPayload {
+		return nil, fmt.Errorf("message too large")
+	}
+
+	var env directEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("malformed message")
+	}
+
+	plaintext, err := openDirect(env.Ephemeral, env.Ciphertext, directAAD(from, a.host.ID()), a.identity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt message")
+	}
+
+	var payload directPayload
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return nil, fmt.Errorf("malformed message")
+	}
+
+	if payload.ID == "" {
+		payload.ID = newMessageID()
+	} else if !validMessageID(payload.ID) {
+		return nil, fmt.Errorf("invalid message id")
+	}
+
+	if a.isPeerMuted(from) {
+		logger.Info("Muted direct message from peer: %s", from.String()[:8])
+		return nil, nil
+	}
+
+	text := sanitize(payload.Text)
+	if len(text) == 0 {
+		return nil, fmt.Errorf("message is empty")
+	}
+	if len(text) > maxMessageLength {
+		text = text[:maxMessageLength]
+	}
+
+	// copies of a message we already have, e.g. sent again after our
+	// acknowledgement got lost, are acknowledged but not shown twice
+	if !a.directSeen.add(from.String() + "/" + payload.ID) {
+		return nil, nil
+	}
+
+	nickname := a.peerNickname(from, payload.Nickname)
+
+	return &ChatMessage{
+		ID:        payload.ID,
+		From:      from,
+		Identity:  GetIdentity(from),
+		Nickname:  nickname,
+		Content:   text,
+		Timestamp: payload.Timestamp,
+		Type:      MessageTypeDirect,
+	}, nil
+}
+
+// peerNickname prefers the nickname we know a peer by over the one it
+// claims in a message
+func (a *App) peerNickname(peerID peer.ID, claimed string) string {
+	a.peersMu.RLock()
+	peerInfo := a.peers[peerID]
+	a.peersMu.RUnlock()
+
+	if peerInfo != nil {
+		return a.displayName(peerID, peerInfo.Nickname)
+	}
+
+	nickname := sanitize(claimed)
+	if len(nickname) == 0 {
+		return "Unknown"
+	}
+	if len(nickname) > maxNicknameLength {
+		nickname = nickname[:maxNicknameLength]
+	}
+	return a.displayName(peerID, nickname)
+}
+
+// FindPeer looks up a connected peer by nickname, the nickname shown for
+// peers sharing theirs, @identity or peer ID
+func (a *App) FindPeer(name string) (*PeerInfo, error) {
+	var matches []*PeerInfo
+	for _, p := range a.GetPeers() {
+		switch {
+		case strings.HasPrefix(name, "@"):
+			if GetIdentity(p.ID) == name {
+				matches = append(matches, p)
+			}
+		case p.ID.String() == name || p.Nickname == name || p.Nickname+GetIdentity(p.ID) == name:
+			matches = append(matches, p)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no peer named %s", name)
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("%s is ambiguous, use their @identity", name)
+	}
+}
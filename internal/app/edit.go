@@ -0,0 +1,186 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/matt0792/lanchat/internal/p2p"
+)
+
+// EditMessage replaces the text of one of our messages in a joined room.
+// An empty room name means the active room.
+func (a *App) EditMessage(roomName, id, text string) error {
+	room, err := a.lookupRoom(roomName)
+	if err != nil {
+		return err
+	}
+	if err := a.checkOwnMessage(room, id); err != nil {
+		return err
+	}
+
+	encoded, err := encodeText(room, text)
+	if err != nil {
+		return err
+	}
+	return a.sendChange(room, MessageTypeEdit, id, encoded)
+}
+
+// DeleteMessage removes one of our messages from a joined room, peers keep
+// a tombstone in its place. An empty room name means the active room.
+func (a *App) DeleteMessage(roomName, id string) error {
+	room, err := a.lookupRoom(roomName)
+	if err != nil {
+		return err
+	}
+	if err := a.checkOwnMessage(room, id); err != nil {
+		return err
+	}
+	return a.sendChange(room, MessageTypeDelete, id, "")
+}
+
+func (a *App) sendChange(room *Room, msgType MessageType, target, text string) error {
+	msg := chatPayload{
+		ID:       newMessageID(),
+		Type:     msgType,
+		Target:   target,
+		Text:     text,
+		Nickname: a.nickname(),
+		Clock:    room.tick(),
+	}
+	if err := room.topic.Publish(p2p.MessageTypeChat, msg); err != nil {
+		return fmt.Errorf("failed to send %s: %w", msgType, err)
+	}
+	return nil
+}
+
+// checkOwnMessage makes sure a message can be changed by us, peers would
+// ignore the change anyway
+func (a *App) checkOwnMessage(room *Room, id string) error {
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+
+	for _, msg := range room.Messages {
+		if msg.ID != id || msg.Type != MessageTypeText {
+			continue
+		}
+		if msg.From != a.host.ID() {
+			return fmt.Errorf("can only change your own messages")
+		}
+		if msg.Deleted {
+			return fmt.Errorf("message was deleted")
+		}
+		return nil
+	}
+	return fmt.Errorf("message %s not found", id)
+}
+
+// RecentMessage returns the nth most recent text message of a joined room,
+// 1 being the newest. An empty room name means the active room.
+func (a *App) RecentMessage(roomName string, n int) (*ChatMessage, error) {
+	room, err := a.lookupRoom(roomName)
+	if err != nil {
+		return nil, err
+	}
+
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+
+	if n > 0 {
+		for i := len(room.Messages) - 1; i >= 0; i-- {
+			if room.Messages[i].Type != MessageTypeText {
+				continue
+			}
+			if n--; n == 0 {
+				return room.Messages[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no such message")
+}
+
+// FindMessage returns a recent text message of a joined room by ID, nil
+// when it is not among them. An empty room name means the active room.
+func (a *App) FindMessage(roomName, id string) *ChatMessage {
+	room, err := a.lookupRoom(roomName)
+	if err != nil {
+		return nil
+	}
+
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+
+	for _, msg := range room.Messages {
+		if msg.ID == id && msg.Type == MessageTypeText {
+			return msg
+		}
+	}
+	return nil
+}
+
+// LastOwnMessage returns our newest text message in a joined room that is
+// not deleted. An empty room name means the active room.
+func (a *App) LastOwnMessage(roomName string) (*ChatMessage, error) {
+	room, err := a.lookupRoom(roomName)
+	if err != nil {
+		return nil, err
+	}
+
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+
+	for i := len(room.Messages) - 1; i >= 0; i-- {
+		msg := room.Messages[i]
+		if msg.Type == MessageTypeText && msg.From == a.host.ID() && !msg.Deleted {
+			return msg, nil
+		}
+	}
+	return nil, fmt.Errorf("you have no messages in %s", room.Name)
+}
+
+// lookupRoom returns a joined room, the active one for an empty name
+func (a *App) lookupRoom(roomName string) (*Room, error) {
+	room := a.GetCurrentRoom()
+	if roomName != "" {
+		room = a.GetRoom(roomName)
+	}
+	if room == nil {
+		if roomName == "" {
+			return nil, fmt.Errorf("not in a room")
+		}
+		return nil, fmt.Errorf("not in room %s", roomName)
+	}
+	return room, nil
+}
+
+// applyChange applies a received edit or delete to the message with ID
+// target, if it is in the room and was sent by the same peer. It returns
+// the changed message, nil when there was nothing to change.
+func (r *Room) applyChange(change *ChatMessage, target string) *ChatMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, msg := range r.Messages {
+		if msg.ID != target || msg.From != change.From || msg.Type != MessageTypeText || msg.Deleted {
+			continue
+		}
+		// messages already handed out are left alone
+		changed := *msg
+		applyChange(&changed, change.Type, change.Content)
+		r.Messages[i] = &changed
+		return &changed
+	}
+	return nil
+}
+
+func applyChange(msg *ChatMessage, changeType MessageType, text string) {
+	switch changeType {
+	case MessageTypeEdit:
+		if !msg.Deleted {
+			msg.Content = text
+			msg.Edited = true
+		}
+	case MessageTypeDelete:
+		msg.Content = ""
+		msg.Edited = false
+		msg.Deleted = true
+	}
+}
@@ -0,0 +1,245 @@
+package app
+
+import (
+	"context"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/matt0792/lanchat/internal/logger"
+	"github.com/matt0792/lanchat/internal/p2p"
+)
+
+const (
+	rpcHistoryGet = "history.get"
+
+	// after joining we ask up to historySyncPeers room members for their
+	// last historySyncLimit messages, waiting up to historySyncWait for
+	// pubsub to tell us who the members are
+	historySyncPeers = 3
+	historySyncLimit = maxMessagesPerRoom
+	historySyncWait  = 3 * time.Second
+)
+
+var historyRPCOptions = &p2p.RPCOptions{
+	Timeout:    5 * time.Second,
+	MaxPayload: 4 << 20,
+}
+
+type historyRequest struct {
+	Topic string `json:"topic"`
+	Limit int    `json:"limit"`
+	// Proof shows that the caller holds the key of a password protected
+	// room, see historyProof
+	Proof []byte `json:"proof,omitempty"`
+}
+
+// historyResponse carries messages as the pubsub records their senders
+// signed, text in encrypted rooms stays encrypted
+type historyResponse struct {
+	Messages [][]byte `json:"messages"`
+}
+
+func (a *App) registerHistory() {
+	a.host.RegisterRPC(rpcHistoryGet, a.handleHistoryGet, nil)
+}
+
+func (a *App) handleHistoryGet(_ context.Context, from peer.ID, payload json.RawMessage) (any, error) {
+	var req historyRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, &p2p.RPCError{Code: p2p.RPCCodeBadRequest, Message: "malformed request"}
+	}
+
+	// only peers from our domain are in the peer cache. Topic names travel
+	// in the clear in pubsub subscriptions, so knowing one proves nothing,
+	// members of password protected rooms have to show they hold the key.
+	a.peersMu.RLock()
+	_, known := a.peers[from]
+	a.peersMu.RUnlock()
+
+	room := a.roomForTopic(req.Topic)
+	if !known || room == nil || a.isPeerMuted(from) {
+		return historyResponse{}, nil
+	}
+	if room.EncryptionKey != nil && !validHistoryProof(room, from, a.host.ID(), req.Proof) {
+		logger.Debug("Refused history of %s to %s without proof of the key", room.Name, from.String()[:8])
+		return historyResponse{}, nil
+	}
+
+	limit := req.Limit
+	if limit <= 0 || limit > historySyncLimit {
+		limit = historySyncLimit
+	}
+	return historyResponse{Messages: room.recentSigned(limit)}, nil
+}
+
+// syncHistory fetches recent messages from a few members of a room we just
+// joined. Live messages are held back meanwhile, then everything is
+// delivered oldest first with history ahead of live traffic.
+func (a *App) syncHistory(room *Room) {
+	// copies from several members, and live copies of the same messages,
+	// are dropped by the seen cache
+	messages := a.fetchHistory(room)
+	sortMessages(messages)
+
+	for _, msg := range messages {
+		if err := a.deliverChatMessage(room, msg, true); err != nil {
+			logger.Debug("Failed to deliver history message: %v", err)
+		}
+	}
+
+	for {
+		room.mu.Lock()
+		pending := room.pending
+		room.pending = nil
+		if len(pending) == 0 {
+			room.syncing = false
+			room.mu.Unlock()
+			return
+		}
+		room.mu.Unlock()
+
+		sortMessages(pending)
+		for _, msg := range pending {
+			if err := a.deliverChatMessage(room, msg, false); err != nil {
+				logger.Debug("Failed to deliver message: %v", err)
+			}
+		}
+	}
+}
+
+// fetchHistory asks a few room members for their recent messages and
+// returns the ones whose signatures check out
+func (a *App) fetchHistory(room *Room) []*p2p.Message {
+	members := a.waitForMembers(room)
+	rand.Shuffle(len(members), func(i, j int) {
+		members[i], members[j] = members[j], members[i]
+	})
+	members = members[:min(len(members), historySyncPeers)]
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		messages []*p2p.Message
+	)
+	for _, member := range members {
+		wg.Add(1)
+		go func(p peer.ID) {
+			defer wg.Done()
+
+			req := historyRequest{Topic: room.Topic, Limit: historySyncLimit}
+			if room.EncryptionKey != nil {
+				req.Proof = historyProof(room, a.host.ID(), p)
+			}
+			var resp historyResponse
+			if err := a.host.Call(a.ctx, p, rpcHistoryGet, req, &resp, historyRPCOptions); err != nil {
+				logger.Debug("Failed to get history from %s: %v", p.String()[:8], err)
+				return
+			}
+			if len(resp.Messages) > historySyncLimit {
+				resp.Messages = resp.Messages[len(resp.Messages)-historySyncLimit:]
+			}
+
+			for _, signed := range resp.Messages {
+				msg, err := a.host.OpenSignedMessage(signed, room.Topic)
+				if err == nil {
+					err = checkHistoryMessage(msg)
+				}
+				if err != nil {
+					logger.Warn("Dropped history message from %s: %v", p.String()[:8], err)
+					continue
+				}
+				mu.Lock()
+				messages = append(messages, msg)
+				mu.Unlock()
+			}
+		}(member)
+	}
+	wg.Wait()
+
+	return messages
+}
+
+// historyProof is a MAC over the caller, the callee and the room's topic,
+// keyed with a key derived from the room key. It can't be replayed to
+// another member or by another peer.
+func historyProof(room *Room, from, to peer.ID) []byte {
+	key, err := hkdf.Key(sha256.New, room.EncryptionKey, nil, "lanchat/history", keySize)
+	if err != nil {
+		return nil
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(from.String() + "/" + to.String() + "/" + room.Topic))
+	return mac.Sum(nil)
+}
+
+func validHistoryProof(room *Room, from, to peer.ID, proof []byte) bool {
+	want := historyProof(room, from, to)
+	return want != nil && hmac.Equal(proof, want)
+}
+
+// waitForMembers polls until pubsub knows of other peers in the room
+func (a *App) waitForMembers(room *Room) []peer.ID {
+	deadline := time.Now().Add(historySyncWait)
+	for {
+		members := room.topic.ListPeers()
+		if len(members) > 0 || time.Now().After(deadline) {
+			return members
+		}
+		select {
+		case <-a.ctx.Done():
+			return nil
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// checkHistoryMessage accepts text, changes to it and reactions, there is
+// no reason to pass on joins and leaves
+func checkHistoryMessage(msg *p2p.Message) error {
+	if msg.Type != p2p.MessageTypeChat {
+		return fmt.Errorf("unexpected %s message", msg.Type)
+	}
+	if err := checkChatMessage(msg); err != nil {
+		return err
+	}
+
+	var payload chatPayload
+	if err := json.Unmarshal(msg.Data, &payload); err != nil {
+		return err
+	}
+	switch payload.Type {
+	case MessageTypeText, MessageTypeEdit, MessageTypeDelete, MessageTypeReaction:
+		return nil
+	}
+	return fmt.Errorf("unexpected %s message", payload.Type)
+}
+
+// remember keeps a message's signed record for peers syncing history
+func (r *Room) remember(signed []byte) {
+	if signed == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.history = append(r.history, signed)
+	if len(r.history) > maxMessagesPerRoom {
+		r.history = r.history[len(r.history)-maxMessagesPerRoom:]
+	}
+}
+
+func (r *Room) recentSigned(limit int) [][]byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return slices.Clone(r.history[len(r.history)-min(limit, len(r.history)):])
+}
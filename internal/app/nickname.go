@@ -0,0 +1,130 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/matt0792/lanchat/internal/logger"
+	"github.com/matt0792/lanchat/internal/p2p"
+)
+
+// SetNickname changes our nickname, tells every peer through our metadata
+// and announces it in the rooms we are in
+func (a *App) SetNickname(nickname string) error {
+	nickname = sanitize(nickname)
+	if len(nickname) == 0 {
+		return fmt.Errorf("invalid nickname")
+	}
+	if len(nickname) > maxNicknameLength {
+		return fmt.Errorf("nickname too long (max %d characters)", maxNicknameLength)
+	}
+
+	a.userMu.Lock()
+	previous := a.user.Nickname
+	a.user.Nickname = nickname
+	a.userMu.Unlock()
+	if nickname == previous {
+		return nil
+	}
+
+	a.metadataMu.Lock()
+	md := a.host.GetMetadata()
+	md.Nickname = nickname
+	a.host.SetMetadata(md)
+	a.metadataMu.Unlock()
+
+	for _, room := range a.GetJoinedRooms() {
+		msg := chatPayload{
+			ID:       newMessageID(),
+			Type:     MessageTypeNick,
+			Nickname: nickname,
+			Previous: previous,
+			Clock:    room.tick(),
+		}
+		if err := room.topic.Publish(p2p.MessageTypeChat, msg); err != nil {
+			logger.Debug("Failed to announce nickname in room %s: %v", room.Name, err)
+		}
+	}
+
+	logger.Info("Nickname changed from %s to %s", previous, nickname)
+	return nil
+}
+
+// nickname returns our current nickname
+func (a *App) nickname() string {
+	a.userMu.RLock()
+	defer a.userMu.RUnlock()
+	return a.user.Nickname
+}
+
+// renamePeer updates the nickname of a known peer, emitting
+// EventNicknameChange. It returns the previous nickname, empty when the
+// peer is unknown or already known by the new one.
+func (a *App) renamePeer(peerID peer.ID, nickname string) string {
+	a.peersMu.Lock()
+	previous := a.peers[peerID]
+	if previous == nil || previous.Nickname == nickname {
+		a.peersMu.Unlock()
+		return ""
+	}
+	// peer infos already handed out are left alone
+	peerInfo := *previous
+	peerInfo.Nickname = nickname
+	a.peers[peerID] = &peerInfo
+	a.peersMu.Unlock()
+
+	a.updateRoomPeers(&peerInfo)
+	a.host.AddressBook().SetNickname(peerID, nickname)
+
+	a.emit(Event{Type: EventNicknameChange, Data: &NicknameChange{
+		From:     peerID,
+		Identity: GetIdentity(peerID),
+		Previous: previous.Nickname,
+		Nickname: nickname,
+	}})
+	return previous.Nickname
+}
+
+// updateRoomPeers replaces a peer's info in the rooms that list it
+func (a *App) updateRoomPeers(peerInfo *PeerInfo) {
+	for _, room := range a.GetJoinedRooms() {
+		room.mu.Lock()
+		if _, ok := room.Peers[peerInfo.ID]; ok {
+			room.Peers[peerInfo.ID] = peerInfo
+		}
+		room.mu.Unlock()
+	}
+}
+
+// displayName tells apart peers with the same nickname, including us, by
+// adding their @identity to it, so the name shown can also address them
+func (a *App) displayName(peerID peer.ID, nickname string) string {
+	duplicate := peerID != a.host.ID() && strings.EqualFold(nickname, a.nickname())
+
+	a.peersMu.RLock()
+	for id, p := range a.peers {
+		if duplicate {
+			break
+		}
+		duplicate = id != peerID && strings.EqualFold(p.Nickname, nickname)
+	}
+	a.peersMu.RUnlock()
+
+	if !duplicate {
+		return nickname
+	}
+	return nickname + GetIdentity(peerID)
+}
+
+// cleanNickname sanitizes a nickname received from a peer
+func cleanNickname(nickname string) string {
+	nickname = sanitize(nickname)
+	if len(nickname) == 0 {
+		return "Unknown"
+	}
+	if len(nickname) > maxNicknameLength {
+		nickname = nickname[:maxNicknameLength]
+	}
+	return nickname
+}
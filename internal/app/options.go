@@ -0,0 +1,104 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/matt0792/lanchat/internal/p2p"
+)
+
+const (
+	identityFileName    = "identity.key"
+	addressBookFileName = "peers.json"
+	downloadsDirName    = "downloads"
+	historyDirName      = "history"
+)
+
+// Options holds optional configuration for an App
+type Options struct {
+	// DataDir is where persistent state lives, defaults to DefaultDataDir()
+	DataDir string
+	// IdentityPath overrides the identity key location (<DataDir>/identity.key)
+	IdentityPath string
+	// IdentityPassphrase encrypts the identity key on disk when set
+	IdentityPassphrase string
+
+	// DomainSecret restricts connections to peers with the same domain and
+	// secret using a libp2p private network. Forces the tcp transport.
+	DomainSecret string
+
+	// ListenAddrs are the multiaddrs to listen on
+	ListenAddrs []string
+	// Transports lists the enabled transports (tcp, quic), all when empty
+	Transports []string
+	// AnnounceAddrs replaces the addresses advertised to peers
+	AnnounceAddrs []string
+	// AllowInterfaces restricts networking to the named interfaces
+	AllowInterfaces []string
+	// DenyInterfaces excludes the named interfaces
+	DenyInterfaces []string
+
+	// BootstrapPeers are multiaddrs (including /p2p/<peer-id>) dialed on
+	// startup, for networks where mDNS is blocked
+	BootstrapPeers []string
+	// DisableMDNS turns off multicast discovery
+	DisableMDNS bool
+	// RelayService relays traffic for peers that can't reach each other
+	// directly, e.g. on isolated VLANs
+	RelayService bool
+	// Discovery adds extra discovery backends
+	Discovery []p2p.Discovery
+
+	// DownloadDir is where accepted files are saved (<DataDir>/downloads)
+	DownloadDir string
+
+	// HistoryRetention is how long messages are kept in <DataDir>/history,
+	// 30 days by default
+	HistoryRetention time.Duration
+	// DisableHistory keeps messages in memory only
+	DisableHistory bool
+
+	// DisableReadReceipts stops telling senders which of their messages we
+	// have seen, delivery is still acknowledged
+	DisableReadReceipts bool
+
+	// AwayAfter is how long without activity before our status turns to
+	// away, 10 minutes by default
+	AwayAfter time.Duration
+	// DisableAutoAway keeps our status as it is however long we are idle
+	DisableAutoAway bool
+}
+
+// DefaultDataDir returns the per-user lanchat directory
+func DefaultDataDir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate config directory: %w", err)
+	}
+	return filepath.Join(configDir, "lanchat"), nil
+}
+
+func (o Options) withDefaults() (Options, error) {
+	if o.DataDir == "" {
+		dir, err := DefaultDataDir()
+		if err != nil {
+			return o, err
+		}
+		o.DataDir = dir
+	}
+	if o.IdentityPath == "" {
+		o.IdentityPath = filepath.Join(o.DataDir, identityFileName)
+	}
+	if o.DownloadDir == "" {
+		o.DownloadDir = filepath.Join(o.DataDir, downloadsDirName)
+	}
+	if o.HistoryRetention <= 0 {
+		o.HistoryRetention = defaultHistoryRetention
+	}
+	if o.AwayAfter <= 0 {
+		o.AwayAfter = defaultAwayAfter
+	}
+	return o, nil
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"cmp"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/matt0792/lanchat/internal/logger"
+	"github.com/matt0792/lanchat/internal/p2p"
+)
+
+const (
+	// live messages are held this long so that ones which overtook each
+	// other on the way are delivered in order
+	reorderDelay = 250 * time.Millisecond
+
+	// clocks stay below what JSON numbers carry exactly
+	maxClock = 1 << 53
+	// maxClockJump bounds how far one message moves the room's clock, so a
+	// peer sending a huge clock can't pin everyone's at maxClock
+	maxClockJump = 1 << 20
+)
+
+// Room messages carry a Lamport clock. Sending ticks the room's clock and
+// every message seen pushes it past the message's, so a reply always sorts
+// after what it answers. Ties are broken by timestamp, sender and ID, which
+// orders messages the same way on every peer.
+type orderKey struct {
+	clock     uint64
+	timestamp time.Time
+	from      string
+	id        string
+}
+
+func compareKeys(a, b orderKey) int {
+	if c := cmp.Compare(a.clock, b.clock); c != 0 {
+		return c
+	}
+	if c := a.timestamp.Compare(b.timestamp); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.from, b.from); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.id, b.id)
+}
+
+func chatMessageKey(msg *ChatMessage) orderKey {
+	return orderKey{msg.Clock, msg.Timestamp, msg.From.String(), msg.ID}
+}
+
+func messageKey(msg *p2p.Message) orderKey {
+	var payload chatPayload
+	json.Unmarshal(msg.Data, &payload) // validated on arrival
+	if payload.ID == "" {
+		payload.ID = legacyMessageID(msg.PubsubID)
+	}
+	return orderKey{payload.Clock, msg.Timestamp, msg.From, payload.ID}
+}
+
+// sortMessages puts room messages in delivery order
+func sortMessages(messages []*p2p.Message) {
+	type keyed struct {
+		key orderKey
+		msg *p2p.Message
+	}
+
+	sorted := make([]keyed, len(messages))
+	for i, msg := range messages {
+		sorted[i] = keyed{messageKey(msg), msg}
+	}
+	slices.SortStableFunc(sorted, func(a, b keyed) int {
+		return compareKeys(a.key, b.key)
+	})
+	for i := range sorted {
+		messages[i] = sorted[i].msg
+	}
+}
+
+// tick returns the clock for a message we are about to send
+func (r *Room) tick() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.clock = min(r.clock+1, maxClock)
+	return r.clock
+}
+
+// observe moves the room's clock past a message's, by at most maxClockJump
+func (r *Room) observe(clock uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.clock = max(r.clock, min(clock, r.clock+maxClockJump))
+}
+
+// buffer holds a live message for reorderDelay, together with whatever
+// else arrives meanwhile
+func (a *App) buffer(room *Room, msg *p2p.Message) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	room.buffered = append(room.buffered, msg)
+	if len(room.buffered) == 1 {
+		time.AfterFunc(reorderDelay, func() { a.flush(room) })
+	}
+}
+
+// flush delivers the buffered messages of a room in order
+func (a *App) flush(room *Room) {
+	room.flushMu.Lock()
+	defer room.flushMu.Unlock()
+
+	room.mu.Lock()
+	buffered := room.buffered
+	room.buffered = nil
+	room.mu.Unlock()
+
+	// the room may have been left meanwhile
+	if a.roomForTopic(room.Topic) != room {
+		return
+	}
+
+	sortMessages(buffered)
+	for _, msg := range buffered {
+		if err := a.deliverChatMessage(room, msg, false); err != nil {
+			logger.Debug("Failed to deliver message: %v", err)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package app
+
+import "testing"
+
+func TestObserve(t *testing.T) {
+	room := &Room{}
+
+	room.observe(5)
+	if got := room.tick(); got != 6 {
+		t.Errorf("got clock %d after observing 5, want 6", got)
+	}
+
+	room.observe(3)
+	if got := room.tick(); got != 7 {
+		t.Errorf("got clock %d after observing an older clock, want 7", got)
+	}
+
+	room.observe(maxClock)
+	if got := room.tick(); got != 7+maxClockJump+1 {
+		t.Errorf("got clock %d after observing maxClock, want %d", got, 7+maxClockJump+1)
+	}
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/matt0792/lanchat/internal/p2p"
+)
+
+const (
+	// in bytes, enough for emoji made of several code points
+	maxReactionLength = 32
+
+	// reactions beyond this many different ones are ignored
+	maxReactionsPerMessage = 20
+)
+
+// React adds a reaction to a message of a joined room, or takes ours back
+// when remove is set. An empty room name means the active room.
+func (a *App) React(roomName, id, reaction string, remove bool) error {
+	room, err := a.lookupRoom(roomName)
+	if err != nil {
+		return err
+	}
+
+	reaction = sanitizeReaction(reaction)
+	if reaction == "" {
+		return fmt.Errorf("reaction cannot be empty after sanitization")
+	}
+	if len(reaction) > maxReactionLength {
+		return fmt.Errorf("reaction too long (max %d bytes)", maxReactionLength)
+	}
+	if a.FindMessage(room.Name, id) == nil {
+		return fmt.Errorf("message %s not found", id)
+	}
+
+	sealed, err := sealText(room, reaction)
+	if err != nil {
+		return err
+	}
+	msg := chatPayload{
+		ID:       newMessageID(),
+		Type:     MessageTypeReaction,
+		Target:   id,
+		Reaction: sealed,
+		Remove:   remove,
+		Nickname: a.nickname(),
+		Clock:    room.tick(),
+	}
+	if err := room.topic.Publish(p2p.MessageTypeChat, msg); err != nil {
+		return fmt.Errorf("failed to send reaction: %w", err)
+	}
+	return nil
+}
+
+// HasReacted reports whether we added reaction to msg
+func (a *App) HasReacted(msg *ChatMessage, reaction string) bool {
+	return slices.Contains(msg.Reactions[sanitizeReaction(reaction)], a.host.ID())
+}
+
+// sanitizeReaction is sanitize for reactions, which also keeps emoji along
+// with the modifiers and joiners that combine them
+func sanitizeReaction(reaction string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r <= unicode.MaxASCII:
+			if strings.ContainsRune(letters+digits+"+-_:!?", r) {
+				return r
+			}
+		case unicode.Is(unicode.So, r), unicode.Is(unicode.Sk, r), r == '\u200d', r == '\ufe0f':
+			return r
+		}
+		return -1
+	}, reaction)
+}
+
+// applyReaction applies a received reaction to the message it names. It
+// returns the changed message, nil when there was nothing to change.
+func (r *Room) applyReaction(reaction *Reaction) *ChatMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, msg := range r.Messages {
+		if msg.ID != reaction.MessageID || msg.Type != MessageTypeText || msg.Deleted {
+			continue
+		}
+		changed := *msg
+		if !applyReaction(&changed, reaction.From, reaction.Reaction, reaction.Removed) {
+			return nil
+		}
+		r.Messages[i] = &changed
+		return &changed
+	}
+	return nil
+}
+
+// applyReaction adds or removes from's reaction to msg and reports whether
+// that changed anything. The reactions are copied rather than modified, as
+// msg may be a copy of a message already handed out.
+func applyReaction(msg *ChatMessage, from peer.ID, reaction string, remove bool) bool {
+	peers := msg.Reactions[reaction]
+	if slices.Contains(peers, from) != remove {
+		return false
+	}
+	if peers == nil && len(msg.Reactions) >= maxReactionsPerMessage {
+		return false
+	}
+
+	reactions := maps.Clone(msg.Reactions)
+	if reactions == nil {
+		reactions = make(map[string][]peer.ID)
+	}
+	if remove {
+		peers = slices.DeleteFunc(slices.Clone(peers), func(p peer.ID) bool { return p == from })
+		if len(peers) == 0 {
+			delete(reactions, reaction)
+		} else {
+			reactions[reaction] = peers
+		}
+	} else {
+		reactions[reaction] = append(slices.Clone(peers), from)
+	}
+	msg.Reactions = reactions
+	return true
+}
@@ -0,0 +1,120 @@
+package app
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/matt0792/lanchat/internal/logger"
+	"github.com/matt0792/lanchat/internal/p2p"
+)
+
+const (
+	// receipts are collected for receiptInterval and sent in one message
+	// of at most maxReceiptIDs message IDs
+	receiptInterval = 2 * time.Second
+	maxReceiptIDs   = 50
+)
+
+// acknowledge queues a receipt for someone else's message, read when it
+// was shown to the user rather than only received
+func (a *App) acknowledge(room *Room, id string, read bool) {
+	read = read && !a.disableReadReceipts
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if read {
+		room.delivered = slices.DeleteFunc(room.delivered, func(d string) bool { return d == id })
+		if !slices.Contains(room.read, id) {
+			room.read = append(room.read, id)
+		}
+	} else if !slices.Contains(room.delivered, id) && !slices.Contains(room.read, id) {
+		room.delivered = append(room.delivered, id)
+	}
+
+	if !room.receiptsQueued {
+		room.receiptsQueued = true
+		time.AfterFunc(receiptInterval, func() { a.sendReceipts(room) })
+	}
+}
+
+// sendReceipts publishes the receipts queued in a room
+func (a *App) sendReceipts(room *Room) {
+	room.mu.Lock()
+	delivered, read := room.delivered, room.read
+	room.delivered, room.read = nil, nil
+	room.receiptsQueued = false
+	room.mu.Unlock()
+
+	// the room may have been left meanwhile
+	if a.roomForTopic(room.Topic) != room {
+		return
+	}
+
+	for len(delivered)+len(read) > 0 {
+		msg := chatPayload{
+			ID:       newMessageID(),
+			Type:     MessageTypeReceipt,
+			Nickname: a.nickname(),
+		}
+		n := min(len(read), maxReceiptIDs)
+		msg.Read, read = read[:n], read[n:]
+		n = min(len(delivered), maxReceiptIDs-len(msg.Read))
+		msg.Delivered, delivered = delivered[:n], delivered[n:]
+
+		if err := room.topic.Publish(p2p.MessageTypeChat, msg); err != nil {
+			logger.Debug("Failed to send receipts in room %s: %v", room.Name, err)
+			return
+		}
+	}
+}
+
+// applyReceipt records that from received, or read, the message with ID
+// id. It returns the changed message, nil when there was nothing to change.
+func (r *Room) applyReceipt(from peer.ID, id string, read bool) *ChatMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, msg := range r.Messages {
+		if msg.ID != id || msg.Type != MessageTypeText || msg.From == from {
+			continue
+		}
+
+		delivered := slices.Contains(msg.DeliveredTo, from)
+		seen := slices.Contains(msg.ReadBy, from)
+		if seen || delivered && !read {
+			return nil
+		}
+
+		changed := *msg
+		if !delivered {
+			changed.DeliveredTo = append(slices.Clone(msg.DeliveredTo), from)
+		}
+		if read {
+			changed.ReadBy = append(slices.Clone(msg.ReadBy), from)
+		}
+		r.Messages[i] = &changed
+		return &changed
+	}
+	return nil
+}
+
+// Receipts returns the nicknames of the peers that received a recent
+// message of a joined room and of those that read it. An empty room name
+// means the active room.
+func (a *App) Receipts(roomName, id string) (delivered, read []string, err error) {
+	msg := a.FindMessage(roomName, id)
+	if msg == nil {
+		return nil, nil, fmt.Errorf("message %s not found", id)
+	}
+
+	for _, p := range msg.DeliveredTo {
+		delivered = append(delivered, a.peerNickname(p, ""))
+	}
+	for _, p := range msg.ReadBy {
+		read = append(read, a.peerNickname(p, ""))
+	}
+	return delivered, read, nil
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
+
+const (
+	// message IDs are random and 128 bits long, so they don't collide
+	messageIDSize = 16
+
+	// messages remembered per room for deduplication
+	maxSeenMessages = 1000
+)
+
+// newMessageID returns a fresh ID for a message we send
+func newMessageID() string {
+	id := make([]byte, messageIDSize)
+	rand.Read(id) // never fails
+	return hex.EncodeToString(id)
+}
+
+func validMessageID(id string) bool {
+	raw, err := hex.DecodeString(id)
+	return err == nil && len(raw) == messageIDSize
+}
+
+// legacyMessageID derives an ID for messages from peers that don't assign
+// one, the pubsub ID is the same on every peer
+func legacyMessageID(pubsubID string) string {
+	sum := sha256.Sum256([]byte(pubsubID))
+	return hex.EncodeToString(sum[:messageIDSize])
+}
+
+// seenCache remembers the most recent message keys
+type seenCache struct {
+	mu    sync.Mutex
+	keys  map[string]struct{}
+	order []string
+	next  int
+}
+
+func newSeenCache(size int) *seenCache {
+	return &seenCache{
+		keys:  make(map[string]struct{}, size),
+		order: make([]string, size),
+	}
+}
+
+// add records a key and reports whether it is new, evicting the oldest key
+// once the cache is full
+func (c *seenCache) add(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.keys[key]; exists {
+		return false
+	}
+
+	if old := c.order[c.next]; old != "" {
+		delete(c.keys, old)
+	}
+	c.order[c.next] = key
+	c.next = (c.next + 1) % len(c.order)
+	c.keys[key] = struct{}{}
+	return true
+}
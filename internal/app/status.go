@@ -0,0 +1,200 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/matt0792/lanchat/internal/logger"
+	"github.com/matt0792/lanchat/internal/p2p"
+)
+
+// statuses with a meaning of their own, anything else is custom text
+const (
+	StatusOnline = "online"
+	StatusAway   = "away"
+	StatusBusy   = "busy"
+)
+
+const (
+	maxStatusLength     = 50
+	maxWireStatusLength = 4 * maxStatusLength
+
+	defaultAwayAfter = 10 * time.Minute
+)
+
+// statusPayload is the schema of MessageTypeStatus messages
+type statusPayload struct {
+	Status string `json:"status"`
+}
+
+// SetStatus changes our status to online, away, busy or a short text of
+// our own and tells our peers
+func (a *App) SetStatus(status string) error {
+	status = sanitize(status)
+	if status == "" {
+		return fmt.Errorf("status cannot be empty after sanitization")
+	}
+	if len(status) > maxStatusLength {
+		return fmt.Errorf("status too long (max %d characters)", maxStatusLength)
+	}
+
+	a.userMu.Lock()
+	changed := a.user.Status != status
+	a.user.Status = status
+	a.lastActive = time.Now()
+	a.autoAway = false
+	a.userMu.Unlock()
+
+	if changed {
+		a.publishStatus(status)
+	}
+	return nil
+}
+
+// MarkActive records that the user did something, which brings them back
+// online if they were only away for being idle
+func (a *App) MarkActive() {
+	a.userMu.Lock()
+	a.lastActive = time.Now()
+	back := a.autoAway
+	if back {
+		a.user.Status = StatusOnline
+		a.autoAway = false
+	}
+	a.userMu.Unlock()
+
+	if back {
+		a.publishStatus(StatusOnline)
+		a.emit(Event{Type: EventSystemMessage, Data: "You are back online"})
+	}
+}
+
+// watchActivity sets us away after awayAfter without activity, as long as
+// we are online rather than busy or the like
+func (a *App) watchActivity() {
+	if a.awayAfter == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(min(a.awayAfter/4, time.Minute))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-ticker.C:
+			a.userMu.Lock()
+			idle := a.user.Status == StatusOnline && time.Since(a.lastActive) >= a.awayAfter
+			if idle {
+				a.user.Status = StatusAway
+				a.autoAway = true
+			}
+			a.userMu.Unlock()
+
+			if idle {
+				a.publishStatus(StatusAway)
+				a.emit(Event{Type: EventSystemMessage, Data: "You are now away"})
+			}
+		}
+	}
+}
+
+// publishStatus tells peers in our rooms about a new status right away,
+// our metadata carries it to everyone else
+func (a *App) publishStatus(status string) {
+	a.metadataMu.Lock()
+	md := a.host.GetMetadata()
+	md.Custom["status"] = status
+	a.host.SetMetadata(md)
+	a.metadataMu.Unlock()
+
+	for _, room := range a.GetJoinedRooms() {
+		if err := room.topic.Publish(p2p.MessageTypeStatus, statusPayload{Status: status}); err != nil {
+			logger.Debug("Failed to send status in room %s: %v", room.Name, err)
+		}
+	}
+}
+
+// validateStatusMessage checks status messages and rate limits them on a
+// budget of their own, so changing status never costs chat messages
+func (a *App) validateStatusMessage(msg *p2p.Message) error {
+	var payload statusPayload
+	if err := json.Unmarshal(msg.Data, &payload); err != nil {
+		return fmt.Errorf("malformed status message: %w", err)
+	}
+	if payload.Status == "" || len(payload.Status) > maxWireStatusLength {
+		return fmt.Errorf("invalid status (%d bytes)", len(payload.Status))
+	}
+
+	if msg.From == a.host.ID().String() {
+		return nil
+	}
+	peerID, err := peer.Decode(msg.From)
+	if err != nil {
+		return err
+	}
+	if !a.statusLimiter.Allow(peerID) {
+		return fmt.Errorf("peer %s: %w", peerID.String()[:8], p2p.ErrRateLimited)
+	}
+	return nil
+}
+
+func (a *App) handleStatusMessage(msg *p2p.Message) error {
+	if msg.Local {
+		return nil
+	}
+
+	peerID, err := peer.Decode(msg.From)
+	if err != nil {
+		return err
+	}
+	var payload statusPayload
+	if err := json.Unmarshal(msg.Data, &payload); err != nil {
+		return err
+	}
+	status := cleanStatus(payload.Status)
+
+	// peers we don't know yet are about to send their metadata
+	a.peersMu.Lock()
+	previous := a.peers[peerID]
+	if previous == nil {
+		a.peersMu.Unlock()
+		return nil
+	}
+	peerInfo := *previous
+	peerInfo.Status = status
+	peerInfo.LastSeen = time.Now()
+	a.peers[peerID] = &peerInfo
+	a.peersMu.Unlock()
+
+	if previous.Status != status {
+		logger.Debug("Peer %s is now %s", peerID.String()[:8], status)
+		a.emit(Event{Type: EventStatusChange, Data: &peerInfo})
+	}
+	return nil
+}
+
+// touchPeer records that we just heard from a peer
+func (a *App) touchPeer(peerID peer.ID) {
+	a.peersMu.Lock()
+	defer a.peersMu.Unlock()
+
+	// peer infos already handed out are left alone
+	if previous := a.peers[peerID]; previous != nil {
+		peerInfo := *previous
+		peerInfo.LastSeen = time.Now()
+		a.peers[peerID] = &peerInfo
+	}
+}
+
+// cleanStatus sanitizes a status received from a peer
+func cleanStatus(status string) string {
+	status = sanitize(status)
+	if len(status) > maxStatusLength {
+		status = status[:maxStatusLength]
+	}
+	return status
+}
@@ -0,0 +1,409 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/matt0792/lanchat/internal/logger"
+)
+
+const (
+	defaultHistoryRetention = 30 * 24 * time.Hour
+	maxStoredLineSize       = 64 << 10
+
+	// a room's history keeps its newest messages within retention, and is
+	// pruned again once it grows by a tenth or an hour has passed
+	maxStoredMessages    = 10000
+	historyPruneInterval = time.Hour
+)
+
+// roomLog is a room's message history on disk, an append-only JSON lines
+// file per room topic so rooms of the same name in other domains or with
+// other passwords stay apart. Lines of password protected rooms are
+// encrypted with the room key. The messages are also kept in memory, in
+// order, so reading them doesn't decrypt the file.
+type roomLog struct {
+	path      string
+	key       []byte
+	retention time.Duration
+
+	mu     sync.Mutex
+	file   *os.File
+	stored []*storedMessage
+	seen   map[string]bool
+	pruned time.Time
+	// clock is the highest Lamport clock stored, moved by at most
+	// maxClockJump per message as in observe, the room's clock starts from it
+	clock uint64
+}
+
+type storedMessage struct {
+	// Key identifies the message across peers, for deduplication
+	Key       string      `json:"key"`
+	ID        string      `json:"id"`
+	From      string      `json:"from"`
+	Nickname  string      `json:"nickname"`
+	Content   string      `json:"content"`
+	Timestamp time.Time   `json:"timestamp"`
+	Clock     uint64      `json:"clock,omitempty"`
+	Type      MessageType `json:"type"`
+	// Target is the ID of the message an edit, delete or reaction applies
+	// to, Remove takes a reaction back
+	Target  string `json:"target,omitempty"`
+	Remove  bool   `json:"remove,omitempty"`
+	ReplyTo string `json:"reply_to,omitempty"`
+}
+
+// openRoomLog opens a room's history, dropping messages older than
+// retention
+func openRoomLog(dir string, room *Room, retention time.Duration) (*roomLog, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(room.Topic))
+	l := &roomLog{
+		path:      filepath.Join(dir, hex.EncodeToString(sum[:16])+".jsonl"),
+		key:       room.EncryptionKey,
+		retention: retention,
+	}
+
+	stored, dropped, err := l.read()
+	if err != nil {
+		return nil, err
+	}
+	l.stored = stored
+	if err := l.prune(dropped > 0); err != nil {
+		return nil, err
+	}
+
+	l.seen = make(map[string]bool, len(l.stored))
+	for _, m := range l.stored {
+		l.seen[m.Key] = true
+		l.clock = max(l.clock, min(m.Clock, l.clock+maxClockJump))
+	}
+
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *roomLog) open() error {
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	l.file = file
+	return nil
+}
+
+// prune drops messages older than retention and all but the newest
+// maxStoredMessages, rewriting the file when anything was dropped or force
+// is set. l.mu must be held once the log is open.
+func (l *roomLog) prune(force bool) error {
+	cutoff := time.Now().Add(-l.retention)
+	kept := make([]*storedMessage, 0, min(len(l.stored), maxStoredMessages))
+	for _, m := range l.stored {
+		if m.Timestamp.After(cutoff) {
+			kept = append(kept, m)
+		}
+	}
+	if len(kept) > maxStoredMessages {
+		kept = kept[len(kept)-maxStoredMessages:]
+	}
+	l.pruned = time.Now()
+
+	if !force && len(kept) == len(l.stored) {
+		return nil
+	}
+	if err := l.rewrite(kept); err != nil {
+		return err
+	}
+	l.stored = kept
+
+	if l.file == nil {
+		return nil
+	}
+	// the file we appended to was replaced
+	l.file.Close()
+	l.seen = make(map[string]bool, len(kept))
+	for _, m := range kept {
+		l.seen[m.Key] = true
+	}
+	return l.open()
+}
+
+// read returns the stored messages and how many lines couldn't be decoded
+func (l *roomLog) read() ([]*storedMessage, int, error) {
+	file, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open history: %w", err)
+	}
+	defer file.Close()
+
+	var stored []*storedMessage
+	dropped := 0
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 4096), maxStoredLineSize)
+	for scanner.Scan() {
+		m, err := l.decode(scanner.Bytes())
+		if err != nil {
+			dropped++
+			continue
+		}
+		stored = append(stored, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	slices.SortStableFunc(stored, compareStored)
+	return stored, dropped, nil
+}
+
+func compareStored(a, b *storedMessage) int {
+	return compareKeys(
+		orderKey{a.Clock, a.Timestamp, a.From, a.ID},
+		orderKey{b.Clock, b.Timestamp, b.From, b.ID},
+	)
+}
+
+func (l *roomLog) rewrite(stored []*storedMessage) error {
+	var buf bytes.Buffer
+	for _, m := range stored {
+		line, err := l.encode(m)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+	}
+
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return fmt.Errorf("failed to replace history: %w", err)
+	}
+	return nil
+}
+
+func (l *roomLog) encode(m *storedMessage) ([]byte, error) {
+	line, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode message: %w", err)
+	}
+	if l.key != nil {
+		encrypted, err := Encrypt(string(line), l.key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt message: %w", err)
+		}
+		line = []byte(encrypted)
+	}
+	return append(line, '\n'), nil
+}
+
+func (l *roomLog) decode(line []byte) (*storedMessage, error) {
+	if l.key != nil {
+		decrypted, err := Decrypt(string(line), l.key)
+		if err != nil {
+			return nil, err
+		}
+		line = []byte(decrypted)
+	}
+
+	var m storedMessage
+	if err := json.Unmarshal(line, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// newStoredMessage returns the record of a message, key is its sender and
+// ID
+func newStoredMessage(key string, msg *ChatMessage) *storedMessage {
+	return &storedMessage{
+		Key:       key,
+		ID:        msg.ID,
+		From:      msg.From.String(),
+		Nickname:  msg.Nickname,
+		Content:   msg.Content,
+		Timestamp: msg.Timestamp,
+		Clock:     msg.Clock,
+		Type:      msg.Type,
+		ReplyTo:   msg.ReplyTo,
+	}
+}
+
+// append stores a message unless one with the same key already is
+func (l *roomLog) append(m *storedMessage) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.seen[m.Key] {
+		return nil
+	}
+
+	line, err := l.encode(m)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(line); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	l.seen[m.Key] = true
+
+	// messages mostly arrive in order, so this is usually the end
+	i := sort.Search(len(l.stored), func(i int) bool {
+		return compareStored(l.stored[i], m) > 0
+	})
+	l.stored = slices.Insert(l.stored, i, m)
+
+	if len(l.stored) > maxStoredMessages+maxStoredMessages/10 || time.Since(l.pruned) > historyPruneInterval {
+		return l.prune(false)
+	}
+	return nil
+}
+
+// messages returns the stored messages of a room in order, with edits and
+// deletes applied
+func (l *roomLog) messages(roomName string) ([]*ChatMessage, error) {
+	l.mu.Lock()
+	stored := slices.Clone(l.stored)
+	l.mu.Unlock()
+
+	messages := make([]*ChatMessage, 0, len(stored))
+	bySender := make(map[string]*ChatMessage)
+	byID := make(map[string]*ChatMessage)
+	for _, m := range stored {
+		from, err := peer.Decode(m.From)
+		if err != nil {
+			continue
+		}
+
+		switch m.Type {
+		case MessageTypeEdit, MessageTypeDelete:
+			// keyed by sender, so only they can change their messages
+			if target := bySender[m.From+"/"+m.Target]; target != nil {
+				applyChange(target, m.Type, m.Content)
+			}
+			continue
+		case MessageTypeReaction:
+			if target := byID[m.Target]; target != nil && !target.Deleted {
+				applyReaction(target, from, m.Content, m.Remove)
+			}
+			continue
+		}
+
+		msg := &ChatMessage{
+			ID:        m.ID,
+			Room:      roomName,
+			From:      from,
+			Identity:  GetIdentity(from),
+			Nickname:  m.Nickname,
+			Content:   m.Content,
+			Timestamp: m.Timestamp,
+			Clock:     m.Clock,
+			Type:      m.Type,
+			History:   true,
+			ReplyTo:   m.ReplyTo,
+		}
+		bySender[m.From+"/"+m.ID] = msg
+		if byID[m.ID] == nil {
+			byID[m.ID] = msg
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+func (l *roomLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// storeMessage writes a message, or a change to one, to its room's history
+func (a *App) storeMessage(room *Room, m *storedMessage) {
+	if room.log == nil {
+		return
+	}
+	if err := room.log.append(m); err != nil {
+		logger.Warn("Failed to store message in room %s: %v", room.Name, err)
+	}
+}
+
+// GetHistory returns the last n stored messages of a joined room, all of
+// them when n is not positive. An empty room name means the active room.
+func (a *App) GetHistory(roomName string, n int) ([]*ChatMessage, error) {
+	messages, err := a.storedMessages(roomName)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 && len(messages) > n {
+		messages = messages[len(messages)-n:]
+	}
+	return messages, nil
+}
+
+// SearchHistory returns the stored messages of a joined room whose text or
+// sender contains every one of the terms, ignoring case
+func (a *App) SearchHistory(roomName, terms string) ([]*ChatMessage, error) {
+	words := strings.Fields(strings.ToLower(terms))
+	if len(words) == 0 {
+		return nil, fmt.Errorf("no search terms")
+	}
+
+	messages, err := a.storedMessages(roomName)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]*ChatMessage, 0)
+	for _, msg := range messages {
+		if msg.Deleted {
+			continue
+		}
+		text := strings.ToLower(msg.Nickname + " " + msg.Content)
+		found := true
+		for _, word := range words {
+			if !strings.Contains(text, word) {
+				found = false
+				break
+			}
+		}
+		if found {
+			matches = append(matches, msg)
+		}
+	}
+	return matches, nil
+}
+
+func (a *App) storedMessages(roomName string) ([]*ChatMessage, error) {
+	room, err := a.lookupRoom(roomName)
+	if err != nil {
+		return nil, err
+	}
+	if room.log == nil {
+		return nil, fmt.Errorf("message history is disabled")
+	}
+	return room.log.messages(room.Name)
+}
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRoomLogPrune(t *testing.T) {
+	dir := t.TempDir()
+	room := &Room{Name: "general", Topic: "lanchat/test/general"}
+	from := "12D3KooWDpJ7As7BWAwRMfu1VU2WCqNjvq387JEYKDBj4kx6nXTN"
+
+	l, err := openRoomLog(dir, room, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := func(i int, at time.Time) *storedMessage {
+		return &storedMessage{
+			Key:       fmt.Sprintf("%s/%d", from, i),
+			ID:        fmt.Sprintf("%032x", i),
+			From:      from,
+			Content:   "hi",
+			Timestamp: at,
+			Clock:     uint64(i),
+			Type:      MessageTypeText,
+		}
+	}
+
+	// expired while we were running
+	if err := l.append(message(0, time.Now().Add(-2*time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+
+	total := maxStoredMessages + maxStoredMessages/10 + 1
+	for i := 1; i <= total; i++ {
+		if err := l.append(message(i, time.Now())); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	messages, err := l.messages(room.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) > maxStoredMessages+maxStoredMessages/10 {
+		t.Errorf("kept %d messages in memory", len(messages))
+	}
+	if messages[len(messages)-1].ID != fmt.Sprintf("%032x", total) {
+		t.Errorf("newest message missing, last is %s", messages[len(messages)-1].ID)
+	}
+	if len(l.seen) != len(l.stored) {
+		t.Errorf("remembering %d keys for %d messages", len(l.seen), len(l.stored))
+	}
+
+	// appends after pruning go to the new file
+	if err := l.append(message(total+1, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	l.close()
+
+	reopened, err := openRoomLog(dir, room, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.close()
+
+	stored, _ := reopened.messages(room.Name)
+	if len(stored) != maxStoredMessages {
+		t.Errorf("got %d messages after reopening, want %d", len(stored), maxStoredMessages)
+	}
+	if stored[0].ID == fmt.Sprintf("%032x", 0) {
+		t.Error("expired message survived")
+	}
+	if stored[len(stored)-1].ID != fmt.Sprintf("%032x", total+1) {
+		t.Error("message appended after pruning was lost")
+	}
+}
@@ -0,0 +1,757 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/matt0792/lanchat/internal/logger"
+	"github.com/matt0792/lanchat/internal/p2p"
+)
+
+const (
+	rpcFileOffer   = "file.offer"
+	rpcFileDecline = "file.decline"
+
+	fileChunkSize   = 256 << 10
+	maxFileSize     = 2 << 30
+	maxFileNameSize = 100
+	transferIDSize  = 4
+
+	// a failed download is retried from the last verified chunk, with the
+	// delay growing each attempt
+	maxTransferAttempts = 10
+	transferRetryDelay  = 2 * time.Second
+	transferTimeout     = 30 * time.Second
+)
+
+// FileManifest describes an offered file. Every chunk is hashed so a
+// download can be verified piece by piece and resumed after a failure.
+type FileManifest struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Size      int64    `json:"size"`
+	ChunkSize int64    `json:"chunk_size"`
+	Chunks    []string `json:"chunks"`
+	SHA256    string   `json:"sha256"`
+}
+
+func (m *FileManifest) chunkLen(i int) int64 {
+	if i == len(m.Chunks)-1 {
+		return m.Size - int64(i)*m.ChunkSize
+	}
+	return m.ChunkSize
+}
+
+func (m *FileManifest) validate() error {
+	// the id names the partial download, so it must stay plain hex
+	if id, err := hex.DecodeString(m.ID); err != nil || len(id) != transferIDSize {
+		return fmt.Errorf("invalid transfer id")
+	}
+	if m.Size < 0 || m.Size > maxFileSize || m.ChunkSize <= 0 || m.ChunkSize > 4*fileChunkSize {
+		return fmt.Errorf("invalid manifest")
+	}
+	if want := (m.Size + m.ChunkSize - 1) / m.ChunkSize; int64(len(m.Chunks)) != want {
+		return fmt.Errorf("invalid manifest")
+	}
+	return nil
+}
+
+// transfer is the state behind a FileTransfer
+type transfer struct {
+	mu       sync.Mutex
+	info     FileTransfer
+	manifest FileManifest
+	path     string // file being sent, or the partial download
+	verified int    // chunks received and checked
+	running  bool
+
+	// last progress step we reported, in tenths
+	reported int64
+}
+
+func (t *transfer) snapshot() *FileTransfer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	info := t.info
+	return &info
+}
+
+// fileRequest asks the sender for a file starting at a chunk
+type fileRequest struct {
+	ID        string `json:"id"`
+	FromChunk int    `json:"from_chunk"`
+}
+
+type fileResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// partialDownload is saved next to a partial download, so the download can
+// be resumed after a restart
+type partialDownload struct {
+	Peer     peer.ID      `json:"peer"`
+	Manifest FileManifest `json:"manifest"`
+}
+
+func (a *App) registerFileTransfer() {
+	a.host.RegisterRPC(rpcFileOffer, a.handleFileOffer, nil)
+	a.host.RegisterRPC(rpcFileDecline, a.handleFileDecline, nil)
+	a.host.SetStreamHandler(p2p.ProtocolFileTransfer, a.handleFileStream)
+	a.loadPartialDownloads()
+}
+
+// SendFile offers a file to a peer, which can accept or decline it
+func (a *App) SendFile(peerID, path string) (*FileTransfer, error) {
+	to, err := peer.Decode(peerID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer id: %w", err)
+	}
+
+	manifest, err := buildManifest(path)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &transfer{
+		info: FileTransfer{
+			ID:       manifest.ID,
+			Peer:     to,
+			Nickname: a.peerNickname(to, ""),
+			Name:     manifest.Name,
+			Size:     manifest.Size,
+			Outgoing: true,
+			State:    TransferOffered,
+			Path:     path,
+		},
+		manifest: *manifest,
+		path:     path,
+	}
+
+	a.transfersMu.Lock()
+	a.transfers[manifest.ID] = t
+	a.transfersMu.Unlock()
+
+	if err := a.host.Call(a.ctx, to, rpcFileOffer, manifest, nil, nil); err != nil {
+		a.transfersMu.Lock()
+		delete(a.transfers, manifest.ID)
+		a.transfersMu.Unlock()
+		return nil, fmt.Errorf("failed to offer file: %w", err)
+	}
+
+	logger.Info("Offered %s to %s", manifest.Name, to.String()[:8])
+	return t.snapshot(), nil
+}
+
+// AcceptFile starts downloading an offered file into the download
+// directory. Accepting a failed transfer resumes it.
+func (a *App) AcceptFile(id string) error {
+	t, err := a.getTransfer(id)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	if t.info.Outgoing {
+		t.mu.Unlock()
+		return fmt.Errorf("transfer %s is outgoing", id)
+	}
+	if t.running || t.info.State == TransferComplete || t.info.State == TransferDeclined {
+		t.mu.Unlock()
+		return fmt.Errorf("transfer %s is %s", id, t.info.State)
+	}
+	t.running = true
+	t.info.State = TransferActive
+	t.info.Error = ""
+	t.mu.Unlock()
+
+	go a.download(t)
+	return nil
+}
+
+// DeclineFile rejects an offered file and lets the sender know
+func (a *App) DeclineFile(id string) error {
+	t, err := a.getTransfer(id)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	if t.info.Outgoing || t.info.State != TransferOffered {
+		t.mu.Unlock()
+		return fmt.Errorf("transfer %s can't be declined", id)
+	}
+	t.info.State = TransferDeclined
+	from := t.info.Peer
+	t.mu.Unlock()
+
+	go func() {
+		if err := a.host.Call(a.ctx, from, rpcFileDecline, fileRequest{ID: id}, nil, nil); err != nil {
+			logger.Debug("Failed to decline file %s: %v", id, err)
+		}
+	}()
+
+	a.emit(Event{Type: EventFileFailed, Data: t.snapshot()})
+	return nil
+}
+
+// GetTransfers returns every file transfer of this session
+func (a *App) GetTransfers() []*FileTransfer {
+	a.transfersMu.RLock()
+	defer a.transfersMu.RUnlock()
+
+	transfers := make([]*FileTransfer, 0, len(a.transfers))
+	for _, t := range a.transfers {
+		transfers = append(transfers, t.snapshot())
+	}
+	return transfers
+}
+
+func (a *App) getTransfer(id string) (*transfer, error) {
+	a.transfersMu.RLock()
+	defer a.transfersMu.RUnlock()
+
+	t, exists := a.transfers[id]
+	if !exists {
+		return nil, fmt.Errorf("no transfer with id %s", id)
+	}
+	return t, nil
+}
+
+func (a *App) handleFileOffer(_ context.Context, from peer.ID, payload json.RawMessage) (any, error) {
+	if a.isPeerMuted(from) || !a.rateLimiter.Allow(from) {
+		return nil, &p2p.RPCError{Code: p2p.RPCCodeBadRequest, Message: "offer rejected"}
+	}
+
+	var manifest FileManifest
+	if err := json.Unmarshal(payload, &manifest); err != nil {
+		return nil, &p2p.RPCError{Code: p2p.RPCCodeBadRequest, Message: "malformed manifest"}
+	}
+	if err := manifest.validate(); err != nil {
+		return nil, &p2p.RPCError{Code: p2p.RPCCodeBadRequest, Message: err.Error()}
+	}
+
+	manifest.Name = safeFileName(manifest.Name)
+
+	t := &transfer{
+		info: FileTransfer{
+			ID:       manifest.ID,
+			Peer:     from,
+			Nickname: a.peerNickname(from, ""),
+			Name:     manifest.Name,
+			Size:     manifest.Size,
+			State:    TransferOffered,
+		},
+		manifest: manifest,
+		path:     a.partPath(manifest.ID),
+	}
+
+	a.transfersMu.Lock()
+	if _, exists := a.transfers[manifest.ID]; exists {
+		a.transfersMu.Unlock()
+		return nil, &p2p.RPCError{Code: p2p.RPCCodeBadRequest, Message: "duplicate transfer id"}
+	}
+	a.transfers[manifest.ID] = t
+	a.transfersMu.Unlock()
+
+	logger.Info("File offer %s from %s: %s", manifest.ID, from.String()[:8], manifest.Name)
+	a.emit(Event{Type: EventFileOffer, Data: t.snapshot()})
+	return nil, nil
+}
+
+func (a *App) handleFileDecline(_ context.Context, from peer.ID, payload json.RawMessage) (any, error) {
+	var req fileRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, &p2p.RPCError{Code: p2p.RPCCodeBadRequest, Message: "malformed request"}
+	}
+
+	t, err := a.getTransfer(req.ID)
+	if err != nil {
+		return nil, nil
+	}
+
+	t.mu.Lock()
+	if !t.info.Outgoing || t.info.Peer != from || t.info.State != TransferOffered {
+		t.mu.Unlock()
+		return nil, nil
+	}
+	t.info.State = TransferDeclined
+	t.mu.Unlock()
+
+	a.emit(Event{Type: EventFileFailed, Data: t.snapshot()})
+	return nil, nil
+}
+
+// handleFileStream serves chunks of a file we offered to the peer it was
+// offered to
+func (a *App) handleFileStream(stream network.Stream) {
+	defer stream.Close()
+	stream.SetReadDeadline(time.Now().Add(transferTimeout))
+
+	// the request and the receiver's confirmation are both small
+	dec := json.NewDecoder(io.LimitReader(stream, 2048))
+
+	var req fileRequest
+	if err := dec.Decode(&req); err != nil {
+		return
+	}
+
+	t, err := a.getTransfer(req.ID)
+	if err == nil {
+		t.mu.Lock()
+		if !t.info.Outgoing || t.info.Peer != stream.Conn().RemotePeer() || t.info.State == TransferDeclined {
+			err = fmt.Errorf("no transfer with id %s", req.ID)
+		}
+		t.mu.Unlock()
+	}
+	if err == nil && (req.FromChunk < 0 || req.FromChunk > len(t.manifest.Chunks)) {
+		err = fmt.Errorf("invalid chunk %d", req.FromChunk)
+	}
+	if err != nil {
+		json.NewEncoder(stream).Encode(fileResponse{Error: err.Error()})
+		return
+	}
+
+	if err := json.NewEncoder(stream).Encode(fileResponse{}); err != nil {
+		return
+	}
+
+	t.mu.Lock()
+	t.info.State = TransferActive
+	t.mu.Unlock()
+
+	if err := a.sendChunks(stream, t, req.FromChunk); err != nil {
+		logger.Debug("File transfer %s interrupted: %v", req.ID, err)
+		return
+	}
+
+	// the file is only delivered once the receiver has checked it, if the
+	// confirmation doesn't arrive the receiver asks again
+	stream.SetReadDeadline(time.Now().Add(transferTimeout))
+	var ack fileResponse
+	if err := dec.Decode(&ack); err != nil {
+		logger.Debug("File transfer %s not confirmed: %v", req.ID, err)
+		return
+	}
+	if ack.Error != "" {
+		t.mu.Lock()
+		t.info.State = TransferFailed
+		t.info.Error = ack.Error
+		t.mu.Unlock()
+
+		logger.Warn("File transfer %s rejected by receiver: %s", req.ID, ack.Error)
+		a.emit(Event{Type: EventFileFailed, Data: t.snapshot()})
+		return
+	}
+
+	t.mu.Lock()
+	t.info.State = TransferComplete
+	t.info.Error = ""
+	t.mu.Unlock()
+
+	logger.Info("Sent %s to %s", t.manifest.Name, stream.Conn().RemotePeer().String()[:8])
+	a.emit(Event{Type: EventFileComplete, Data: t.snapshot()})
+}
+
+func (a *App) sendChunks(stream network.Stream, t *transfer, from int) error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(int64(from)*t.manifest.ChunkSize, io.SeekStart); err != nil {
+		return err
+	}
+
+	buf := make([]byte, t.manifest.ChunkSize)
+	for i := from; i < len(t.manifest.Chunks); i++ {
+		chunk := buf[:t.manifest.chunkLen(i)]
+		if _, err := io.ReadFull(f, chunk); err != nil {
+			return fmt.Errorf("failed to read %s: %w", t.path, err)
+		}
+
+		stream.SetWriteDeadline(time.Now().Add(transferTimeout))
+		if _, err := stream.Write(chunk); err != nil {
+			return err
+		}
+
+		a.transferProgress(t, int64(i)*t.manifest.ChunkSize+int64(len(chunk)))
+	}
+	return nil
+}
+
+// errTransferFatal marks failures that retrying won't fix
+var errTransferFatal = errors.New("transfer failed")
+
+// download fetches a file, resuming from the last verified chunk whenever
+// the connection drops
+func (a *App) download(t *transfer) {
+	if err := a.savePartial(t); err != nil {
+		logger.Warn("File transfer %s can't be resumed after a restart: %v", t.manifest.ID, err)
+	}
+
+	var err error
+	for attempt := 0; attempt < maxTransferAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-a.ctx.Done():
+				return
+			case <-time.After(transferRetryDelay * time.Duration(attempt)):
+			}
+		}
+
+		err = a.fetchChunks(t)
+		if err == nil || errors.Is(err, errTransferFatal) {
+			break
+		}
+		logger.Debug("File transfer %s interrupted, resuming: %v", t.manifest.ID, err)
+	}
+
+	if err == nil {
+		err = a.finishDownload(t)
+	}
+
+	t.mu.Lock()
+	t.running = false
+	if err != nil {
+		t.info.State = TransferFailed
+		t.info.Error = err.Error()
+	} else {
+		t.info.State = TransferComplete
+	}
+	t.mu.Unlock()
+
+	if err != nil {
+		logger.Warn("File transfer %s failed: %v", t.manifest.ID, err)
+		a.emit(Event{Type: EventFileFailed, Data: t.snapshot()})
+		return
+	}
+
+	logger.Info("Received %s", t.info.Path)
+	a.emit(Event{Type: EventFileComplete, Data: t.snapshot()})
+}
+
+func (a *App) fetchChunks(t *transfer) error {
+	if err := os.MkdirAll(a.downloadDir, 0700); err != nil {
+		return fmt.Errorf("%w: failed to create download directory: %v", errTransferFatal, err)
+	}
+
+	f, err := os.OpenFile(t.path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errTransferFatal, err)
+	}
+	defer f.Close()
+
+	// chunks already on disk from an earlier attempt or run only count if
+	// they still match the manifest. The last one is checked again on every
+	// resume, its write may have been cut short.
+	if t.verified == 0 || !chunkMatches(f, &t.manifest, t.verified-1) {
+		t.verified = verifiedChunks(f, &t.manifest)
+	}
+
+	ctx, cancel := context.WithTimeout(a.ctx, transferTimeout)
+	stream, err := a.host.NewStream(ctx, t.info.Peer, p2p.ProtocolFileTransfer)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	stream.SetDeadline(time.Now().Add(transferTimeout))
+	if err := json.NewEncoder(stream).Encode(fileRequest{ID: t.manifest.ID, FromChunk: t.verified}); err != nil {
+		return err
+	}
+
+	// the header is a single line, chunks follow straight after it
+	r := bufio.NewReader(stream)
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return err
+	}
+	var resp fileResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("%w: %s", errTransferFatal, resp.Error)
+	}
+
+	buf := make([]byte, t.manifest.ChunkSize)
+	for t.verified < len(t.manifest.Chunks) {
+		i := t.verified
+		chunk := buf[:t.manifest.chunkLen(i)]
+
+		stream.SetReadDeadline(time.Now().Add(transferTimeout))
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(chunk)
+		if hex.EncodeToString(sum[:]) != t.manifest.Chunks[i] {
+			return fmt.Errorf("chunk %d failed verification", i)
+		}
+
+		if _, err := f.WriteAt(chunk, int64(i)*t.manifest.ChunkSize); err != nil {
+			return fmt.Errorf("%w: %v", errTransferFatal, err)
+		}
+
+		t.verified++
+		a.transferProgress(t, int64(i)*t.manifest.ChunkSize+int64(len(chunk)))
+	}
+
+	// the sender waits for us to confirm the whole file before it counts
+	// it as delivered. A file whose chunks all match but whose hash
+	// doesn't has a bad manifest, so it's started over on the next accept.
+	verifyErr := verifyDownload(f, &t.manifest)
+	ack := fileResponse{}
+	if verifyErr != nil {
+		ack.Error = verifyErr.Error()
+	}
+	stream.SetWriteDeadline(time.Now().Add(transferTimeout))
+	if err := json.NewEncoder(stream).Encode(ack); err != nil && verifyErr == nil {
+		return err
+	}
+	if verifyErr != nil {
+		f.Truncate(0)
+		t.verified = 0
+		return fmt.Errorf("%w: %v", errTransferFatal, verifyErr)
+	}
+
+	return nil
+}
+
+// verifyDownload checks the whole partial download against the manifest
+func verifyDownload(f *os.File, m *FileManifest) error {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(f, 0, m.Size)); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hash.Sum(nil)) != m.SHA256 {
+		return fmt.Errorf("file failed verification")
+	}
+	return nil
+}
+
+// finishDownload moves a verified download into place
+func (a *App) finishDownload(t *transfer) error {
+	if err := os.Truncate(t.path, t.manifest.Size); err != nil {
+		return err
+	}
+
+	dest := uniquePath(filepath.Join(a.downloadDir, t.manifest.Name))
+	if err := os.Rename(t.path, dest); err != nil {
+		return err
+	}
+	os.Remove(a.manifestPath(t.manifest.ID))
+
+	t.mu.Lock()
+	t.info.Path = dest
+	t.mu.Unlock()
+	return nil
+}
+
+// transferProgress records progress, emitting an event every 10%
+func (a *App) transferProgress(t *transfer, done int64) {
+	t.mu.Lock()
+	t.info.Transferred = done
+	step := int64(10)
+	if t.manifest.Size > 0 {
+		step = done * 10 / t.manifest.Size
+	}
+	report := step > t.reported && step < 10
+	if report {
+		t.reported = step
+	}
+	t.mu.Unlock()
+
+	if report {
+		a.emit(Event{Type: EventFileProgress, Data: t.snapshot()})
+	}
+}
+
+func buildManifest(path string) (*FileManifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	if !stat.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	if stat.Size() > maxFileSize {
+		return nil, fmt.Errorf("file too large (max %d MiB)", maxFileSize>>20)
+	}
+
+	id := make([]byte, transferIDSize)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	manifest := &FileManifest{
+		ID:        hex.EncodeToString(id),
+		Name:      safeFileName(filepath.Base(path)),
+		Size:      stat.Size(),
+		ChunkSize: fileChunkSize,
+		Chunks:    make([]string, 0),
+	}
+
+	whole := sha256.New()
+	buf := make([]byte, fileChunkSize)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			sum := sha256.Sum256(buf[:n])
+			manifest.Chunks = append(manifest.Chunks, hex.EncodeToString(sum[:]))
+			whole.Write(buf[:n])
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+	}
+	manifest.SHA256 = hex.EncodeToString(whole.Sum(nil))
+
+	return manifest, nil
+}
+
+// verifiedChunks counts the leading chunks of a partial download that
+// match the manifest
+func verifiedChunks(f *os.File, m *FileManifest) int {
+	for i := range m.Chunks {
+		if !chunkMatches(f, m, i) {
+			return i
+		}
+	}
+	return len(m.Chunks)
+}
+
+// chunkMatches reports whether chunk i of a partial download is on disk
+// and matches the manifest
+func chunkMatches(f *os.File, m *FileManifest, i int) bool {
+	chunk := make([]byte, m.chunkLen(i))
+	if _, err := f.ReadAt(chunk, int64(i)*m.ChunkSize); err != nil {
+		return false
+	}
+	sum := sha256.Sum256(chunk)
+	return hex.EncodeToString(sum[:]) == m.Chunks[i]
+}
+
+func (a *App) partPath(id string) string {
+	return filepath.Join(a.downloadDir, "."+id+".part")
+}
+
+func (a *App) manifestPath(id string) string {
+	return filepath.Join(a.downloadDir, "."+id+".manifest")
+}
+
+// savePartial writes the manifest of a download next to its partial file
+func (a *App) savePartial(t *transfer) error {
+	data, err := json.Marshal(partialDownload{Peer: t.info.Peer, Manifest: t.manifest})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(a.downloadDir, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(a.manifestPath(t.manifest.ID), data, 0600)
+}
+
+// loadPartialDownloads brings back the downloads an earlier run didn't
+// finish as failed transfers, accepting one resumes it
+func (a *App) loadPartialDownloads() {
+	paths, _ := filepath.Glob(filepath.Join(a.downloadDir, ".*.manifest"))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		var partial partialDownload
+		if err := json.Unmarshal(data, &partial); err != nil || partial.Manifest.validate() != nil ||
+			path != a.manifestPath(partial.Manifest.ID) {
+			logger.Debug("Ignoring invalid download manifest %s", path)
+			continue
+		}
+		if _, err := os.Stat(a.partPath(partial.Manifest.ID)); err != nil {
+			os.Remove(path)
+			continue
+		}
+
+		manifest := partial.Manifest
+		manifest.Name = safeFileName(manifest.Name)
+		a.transfersMu.Lock()
+		a.transfers[manifest.ID] = &transfer{
+			info: FileTransfer{
+				ID:   manifest.ID,
+				Peer: partial.Peer,
+				// the nickname isn't known before the peer shows up again
+				Nickname: GetIdentity(partial.Peer),
+				Name:     manifest.Name,
+				Size:     manifest.Size,
+				State:    TransferFailed,
+				Error:    "interrupted",
+			},
+			manifest: manifest,
+			path:     a.partPath(manifest.ID),
+		}
+		a.transfersMu.Unlock()
+		logger.Info("Unfinished download %s of %s, accept it to resume", manifest.ID, manifest.Name)
+	}
+}
+
+// safeFileName keeps a received file name from escaping the download
+// directory or hiding itself
+func safeFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(letters+digits+"-_.+ ", r) {
+			return r
+		}
+		return '_'
+	}, name)
+	name = strings.TrimLeft(name, ". ")
+	if len(name) > maxFileNameSize {
+		name = name[len(name)-maxFileNameSize:]
+	}
+	if name == "" {
+		name = "file"
+	}
+	return name
+}
+
+// uniquePath appends a counter to path until it doesn't exist
+func uniquePath(path string) string {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return path
+	}
+
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if _, err := os.Stat(candidate); errors.Is(err, os.ErrNotExist) {
+			return candidate
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/matt0792/lanchat/internal/p2p"
 )
 
 type User struct {
@@ -13,11 +14,26 @@ type User struct {
 }
 
 type PeerInfo struct {
+	ID          peer.ID
+	Nickname    string
+	Status      string
+	CurrentRoom string   // empty when not in a room or in an encrypted one
+	Rooms       []string // every unencrypted room the peer is in
+	LastSeen    time.Time
+	Metadata    map[string]string
+
+	seq uint64 // metadata sequence number, older updates are ignored
+}
+
+// PeerNetInfo describes a connection to a peer and how gossipsub rates it
+type PeerNetInfo struct {
 	ID       peer.ID
 	Nickname string
-	Status   string
-	LastSeen time.Time
-	Metadata map[string]string
+	Identity string
+	Addrs    []string
+	// Score is only known for peers we share a room topic with
+	Score    p2p.PeerScore
+	HasScore bool
 }
 
 type Room struct {
@@ -27,17 +43,111 @@ type Room struct {
 	Messages      []*ChatMessage
 	Password      string
 	EncryptionKey []byte
-	mu            sync.RWMutex
+	// Unread counts messages received while another room was active
+	Unread int
+	mu     sync.RWMutex
+
+	topic *p2p.Topic
+	// log is nil when history is not stored
+	log *roomLog
+	// signed records of recent text messages and changes to them, served
+	// to peers syncing history
+	history [][]byte
+	// seen holds the sender and ID of recent messages, so copies arriving
+	// again live or through history sync are dropped
+	seen *seenCache
+	// while syncing history live messages wait in pending
+	syncing bool
+	pending []*p2p.Message
+	// clock is the room's Lamport clock, buffered holds live messages
+	// waiting to be delivered in order
+	clock    uint64
+	buffered []*p2p.Message
+	flushMu  sync.Mutex
+	// IDs of messages waiting to be acknowledged
+	delivered      []string
+	read           []string
+	receiptsQueued bool
+	// typingSent is when we last told the room we are typing
+	typingSent time.Time
+}
+
+// UnreadCount returns the number of unread messages in the room
+func (r *Room) UnreadCount() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.Unread
+}
+
+// View calls fn with the room locked for reading, so its peers and messages
+// can be copied while new ones arrive. fn must not call into the app.
+func (r *Room) View(fn func(r *Room)) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	fn(r)
 }
 
 type ChatMessage struct {
 	ID        string
+	Room      string // empty for direct messages
 	From      peer.ID
 	Identity  string
 	Nickname  string
 	Content   string
 	Timestamp time.Time
-	Type      MessageType
+	// Clock is the sender's Lamport clock for the room, see orderKey
+	Clock uint64
+	Type  MessageType
+	// History is set for messages fetched from other members after joining
+	// and for messages read from disk
+	History bool
+	// Edited is set once the sender changed the text, Deleted once they
+	// removed it, leaving the message as a tombstone without content
+	Edited  bool
+	Deleted bool
+	// ReplyTo is the ID of the message this one answers, empty for none
+	ReplyTo string
+	// Reactions lists the peers that added each reaction
+	Reactions map[string][]peer.ID
+	// DeliveredTo lists the peers that acknowledged the message, ReadBy
+	// those that also had it on screen
+	DeliveredTo []peer.ID
+	ReadBy      []peer.ID
+}
+
+// NicknameChange is a peer taking a new nickname
+type NicknameChange struct {
+	From     peer.ID
+	Identity string
+	Previous string
+	Nickname string
+}
+
+// Typing is a peer composing a message in a room
+type Typing struct {
+	Room     string
+	From     peer.ID
+	Identity string
+	Nickname string
+	// Expires is when to consider the peer done, unless they send a message
+	// or signal again before
+	Expires time.Time
+}
+
+// Reaction is a peer adding a reaction to a room message, or taking it back
+type Reaction struct {
+	Room      string
+	MessageID string
+	From      peer.ID
+	Identity  string
+	Nickname  string
+	Reaction  string
+	Removed   bool
+	// History is set for reactions fetched from other members after
+	// joining
+	History bool
+	// Message is the message reacted to, with the reaction applied
+	Message *ChatMessage
 }
 
 type MessageType string
@@ -46,6 +156,21 @@ const (
 	MessageTypeText  MessageType = "text"
 	MessageTypeJoin  MessageType = "join"
 	MessageTypeLeave MessageType = "leave"
+	// MessageTypeEdit and MessageTypeDelete change an earlier text message
+	// of the same sender
+	MessageTypeEdit   MessageType = "edit"
+	MessageTypeDelete MessageType = "delete"
+	// MessageTypeReaction adds a reaction to a message or takes it back
+	MessageTypeReaction MessageType = "reaction"
+	// MessageTypeReceipt acknowledges a batch of messages
+	MessageTypeReceipt MessageType = "receipt"
+	// MessageTypeNick announces a new nickname in the rooms of its owner
+	MessageTypeNick MessageType = "nick"
+	// MessageTypeTyping tells a room someone is composing a message, it is
+	// neither stored nor rate limited like the others
+	MessageTypeTyping MessageType = "typing"
+	// MessageTypeDirect is a private message sent to us alone
+	MessageTypeDirect MessageType = "direct"
 )
 
 type Event struct {
@@ -62,4 +187,47 @@ const (
 	EventRoomJoined    EventType = "room_joined"
 	EventStatusChange  EventType = "status_change"
 	EventSystemMessage EventType = "system_message"
+	EventDirectMessage EventType = "direct_message"
+	EventFileOffer     EventType = "file_offer"
+	EventFileProgress  EventType = "file_progress"
+	EventFileComplete  EventType = "file_complete"
+	EventFileFailed    EventType = "file_failed"
+	// the data of EventMessageEdited and EventMessageDeleted is the changed
+	// message
+	EventMessageEdited  EventType = "message_edited"
+	EventMessageDeleted EventType = "message_deleted"
+	// the data of EventReaction is a *Reaction
+	EventReaction EventType = "reaction"
+	// the data of EventReceipt is one of our messages after another peer
+	// received or read it
+	EventReceipt EventType = "receipt"
+	// the data of EventTyping is a *Typing
+	EventTyping EventType = "typing"
+	// the data of EventNicknameChange is a *NicknameChange
+	EventNicknameChange EventType = "nickname_change"
+)
+
+// FileTransfer is a file being sent to or received from a peer
+type FileTransfer struct {
+	ID          string
+	Peer        peer.ID
+	Nickname    string
+	Name        string
+	Size        int64
+	Transferred int64
+	Outgoing    bool
+	State       TransferState
+	// Path is the file being sent, or where a received file was saved
+	Path  string
+	Error string
+}
+
+type TransferState string
+
+const (
+	TransferOffered  TransferState = "offered"
+	TransferActive   TransferState = "active"
+	TransferComplete TransferState = "complete"
+	TransferFailed   TransferState = "failed"
+	TransferDeclined TransferState = "declined"
 )
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/matt0792/lanchat/internal/logger"
+	"github.com/matt0792/lanchat/internal/p2p"
+)
+
+const (
+	// typingInterval is how often the typing signal is repeated while
+	// composing, peers give up on it after typingTimeout
+	typingInterval = 3 * time.Second
+	typingTimeout  = 2 * typingInterval
+)
+
+// Typing tells the peers in a joined room that we are composing a message.
+// It is throttled to one signal per typingInterval, so it can be called on
+// every key press. An empty room name means the active room.
+func (a *App) Typing(roomName string) error {
+	room, err := a.lookupRoom(roomName)
+	if err != nil {
+		return err
+	}
+	a.MarkActive()
+
+	room.mu.RLock()
+	recent := time.Since(room.typingSent) < typingInterval
+	room.mu.RUnlock()
+	if recent {
+		return nil
+	}
+	return a.sendTyping(room)
+}
+
+// KeepTyping shows us as typing in a joined room until stop is called, e.g.
+// while a bot waits for an answer. An empty room name means the active room.
+func (a *App) KeepTyping(roomName string) (stop func(), err error) {
+	room, err := a.lookupRoom(roomName)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.sendTyping(room); err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(typingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-a.ctx.Done():
+				return
+			case <-ticker.C:
+				if err := a.sendTyping(room); err != nil {
+					logger.Debug("Failed to send typing signal in room %s: %v", room.Name, err)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }, nil
+}
+
+func (a *App) sendTyping(room *Room) error {
+	room.mu.Lock()
+	room.typingSent = time.Now()
+	room.mu.Unlock()
+
+	msg := chatPayload{
+		Type:     MessageTypeTyping,
+		Nickname: a.nickname(),
+	}
+	if err := room.topic.Publish(p2p.MessageTypeChat, msg); err != nil {
+		return fmt.Errorf("failed to send typing signal: %w", err)
+	}
+	return nil
+}
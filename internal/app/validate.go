@@ -0,0 +1,173 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/matt0792/lanchat/internal/p2p"
+)
+
+// limits on the wire, looser than what we display since encrypted text is
+// base64 and sanitization happens after decryption
+const (
+	maxWireTextLength     = 4 * maxMessageLength
+	maxWireNicknameLength = 4 * maxNicknameLength
+	maxWireReactionLength = 4 * maxReactionLength
+)
+
+// chatPayload is the schema of MessageTypeChat messages
+type chatPayload struct {
+	// ID is assigned by the sender, older peers leave it out
+	ID       string      `json:"id,omitempty"`
+	Type     MessageType `json:"type"`
+	Nickname string      `json:"nickname,omitempty"`
+	Text     string      `json:"text,omitempty"`
+	// Target is the ID of the message an edit or delete applies to
+	Target string `json:"target,omitempty"`
+	// ReplyTo is the ID of the message a text message answers
+	ReplyTo string `json:"reply_to,omitempty"`
+	// Reaction is added to the Target message, or taken back with Remove
+	Reaction string `json:"reaction,omitempty"`
+	Remove   bool   `json:"remove,omitempty"`
+	// Previous is the nickname a nick message replaces
+	Previous string `json:"previous,omitempty"`
+	// Delivered and Read acknowledge messages by ID
+	Delivered []string `json:"delivered,omitempty"`
+	Read      []string `json:"read,omitempty"`
+	// Clock is the sender's Lamport clock for the room
+	Clock uint64 `json:"clock,omitempty"`
+}
+
+// validateChatMessage drops chat messages from peers over their rate limit
+// before pubsub delivers or forwards them, so floods stop at the first hop
+func (a *App) validateChatMessage(msg *p2p.Message) error {
+	if err := checkChatMessage(msg); err != nil {
+		return err
+	}
+
+	if msg.From == a.host.ID().String() {
+		return nil
+	}
+
+	peerID, err := peer.Decode(msg.From)
+	if err != nil {
+		return err
+	}
+	// typing signals and receipts have budgets of their own, so they never
+	// use up what a peer may send in chat messages
+	limiter := a.rateLimiter
+	var payload chatPayload
+	json.Unmarshal(msg.Data, &payload) // checked above
+	switch payload.Type {
+	case MessageTypeTyping:
+		limiter = a.typingLimiter
+	case MessageTypeReceipt:
+		limiter = a.receiptLimiter
+	}
+	if !limiter.Allow(peerID) {
+		return fmt.Errorf("peer %s: %w", peerID.String()[:8], p2p.ErrRateLimited)
+	}
+	return nil
+}
+
+// checkChatMessage rejects chat messages that don't fit the schema, and
+// ignores ones of a type we don't know
+func checkChatMessage(msg *p2p.Message) error {
+	var payload chatPayload
+	if err := json.Unmarshal(msg.Data, &payload); err != nil {
+		return fmt.Errorf("malformed chat message: %w", err)
+	}
+
+	if payload.ID != "" && !validMessageID(payload.ID) {
+		return fmt.Errorf("invalid message id %q", payload.ID)
+	}
+	if payload.Clock > maxClock {
+		return fmt.Errorf("clock out of range")
+	}
+	if len(payload.Nickname) > maxWireNicknameLength {
+		return fmt.Errorf("nickname too long")
+	}
+
+	switch payload.Type {
+	case MessageTypeText:
+		if payload.Text == "" {
+			return fmt.Errorf("empty text message")
+		}
+		if len(payload.Text) > maxWireTextLength {
+			return fmt.Errorf("text too long (%d bytes)", len(payload.Text))
+		}
+		if payload.ReplyTo != "" && !validMessageID(payload.ReplyTo) {
+			return fmt.Errorf("invalid reply target %q", payload.ReplyTo)
+		}
+	case MessageTypeJoin, MessageTypeLeave:
+		if payload.Text != "" {
+			return fmt.Errorf("unexpected text in %s message", payload.Type)
+		}
+	case MessageTypeEdit:
+		if !validMessageID(payload.Target) {
+			return fmt.Errorf("invalid edit target %q", payload.Target)
+		}
+		if payload.Text == "" {
+			return fmt.Errorf("empty edit")
+		}
+		if len(payload.Text) > maxWireTextLength {
+			return fmt.Errorf("text too long (%d bytes)", len(payload.Text))
+		}
+	case MessageTypeDelete:
+		if !validMessageID(payload.Target) {
+			return fmt.Errorf("invalid delete target %q", payload.Target)
+		}
+		if payload.Text != "" {
+			return fmt.Errorf("unexpected text in %s message", payload.Type)
+		}
+	case MessageTypeReaction:
+		if !validMessageID(payload.Target) {
+			return fmt.Errorf("invalid reaction target %q", payload.Target)
+		}
+		if payload.Reaction == "" {
+			return fmt.Errorf("empty reaction")
+		}
+		if len(payload.Reaction) > maxWireReactionLength {
+			return fmt.Errorf("reaction too long (%d bytes)", len(payload.Reaction))
+		}
+		if payload.Text != "" {
+			return fmt.Errorf("unexpected text in %s message", payload.Type)
+		}
+	case MessageTypeReceipt:
+		ids := len(payload.Delivered) + len(payload.Read)
+		if ids == 0 || ids > maxReceiptIDs {
+			return fmt.Errorf("receipt for %d messages", ids)
+		}
+		for _, id := range append(payload.Delivered, payload.Read...) {
+			if !validMessageID(id) {
+				return fmt.Errorf("invalid receipt for %q", id)
+			}
+		}
+		if payload.Text != "" {
+			return fmt.Errorf("unexpected text in %s message", payload.Type)
+		}
+	case MessageTypeNick:
+		if payload.Nickname == "" {
+			return fmt.Errorf("empty nickname")
+		}
+		if len(payload.Previous) > maxWireNicknameLength {
+			return fmt.Errorf("previous nickname too long")
+		}
+		if payload.Text != "" {
+			return fmt.Errorf("unexpected text in %s message", payload.Type)
+		}
+	case MessageTypeTyping:
+		if payload.Text != "" || payload.Target != "" {
+			return fmt.Errorf("unexpected content in %s message", payload.Type)
+		}
+	case "":
+		return fmt.Errorf("missing chat message type")
+	default:
+		// newer peers may send types we don't know, that's no reason to
+		// hold it against them
+		return fmt.Errorf("chat message type %q: %w", payload.Type, p2p.ErrUnknownType)
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/matt0792/lanchat/internal/p2p"
+)
+
+func TestCheckChatMessage(t *testing.T) {
+	message := func(data string) *p2p.Message {
+		return &p2p.Message{
+			Type:      p2p.MessageTypeChat,
+			Timestamp: time.Now(),
+			Data:      json.RawMessage(data),
+		}
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+		unknown bool
+	}{
+		{name: "text", data: `{"type":"text","text":"hi"}`},
+		{name: "empty text", data: `{"type":"text"}`, wantErr: true},
+		{name: "bad id", data: `{"id":"x","type":"text","text":"hi"}`, wantErr: true},
+		{name: "clock out of range", data: `{"type":"text","text":"hi","clock":9007199254740993}`, wantErr: true},
+		{name: "future type", data: `{"type":"poll","text":"lunch?"}`, wantErr: true, unknown: true},
+		{name: "no type", data: `{"text":"hi"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkChatMessage(message(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got %v, want error %v", err, tt.wantErr)
+			}
+			if errors.Is(err, p2p.ErrUnknownType) != tt.unknown {
+				t.Errorf("got %v, want unknown type %v", err, tt.unknown)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package p2p
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/matt0792/lanchat/internal/logger"
+	"github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// peers not seen for this long are dropped from the address book
+	maxAddressBookAge = 30 * 24 * time.Hour
+	maxAddrsPerPeer   = 10
+
+	// changes are written out together, at most this long after the first
+	addressBookSaveDelay = 2 * time.Second
+)
+
+// AddressBookEntry is what we remember about a peer between runs
+type AddressBookEntry struct {
+	ID peer.ID `json:"id"`
+	// Domain is the lanchat domain the peer told us it is in
+	Domain   string    `json:"domain,omitempty"`
+	Addrs    []string  `json:"addrs"`
+	Nickname string    `json:"nickname,omitempty"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// AddressBook is a persistent record of peers we have been connected to.
+// An address book without a path is kept in memory only.
+type AddressBook struct {
+	path    string
+	mu      sync.Mutex
+	entries map[peer.ID]*AddressBookEntry
+	// a save is scheduled
+	pending bool
+
+	saveMu sync.Mutex
+}
+
+func LoadAddressBook(path string) (*AddressBook, error) {
+	book := &AddressBook{
+		path:    path,
+		entries: make(map[peer.ID]*AddressBookEntry),
+	}
+
+	if path == "" {
+		return book, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return book, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read address book: %w", err)
+	}
+
+	var entries []*AddressBookEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse address book: %w", err)
+	}
+
+	cutoff := time.Now().Add(-maxAddressBookAge)
+	for _, e := range entries {
+		if e.ID == "" || e.LastSeen.Before(cutoff) {
+			continue
+		}
+		book.entries[e.ID] = e
+	}
+
+	return book, nil
+}
+
+// Entries returns a copy of every known peer
+func (b *AddressBook) Entries() []AddressBookEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entries := make([]AddressBookEntry, 0, len(b.entries))
+	for _, e := range b.entries {
+		entries = append(entries, *e)
+	}
+	return entries
+}
+
+func (b *AddressBook) Get(peerID peer.ID) (AddressBookEntry, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	e, exists := b.entries[peerID]
+	if !exists {
+		return AddressBookEntry{}, false
+	}
+	return *e, true
+}
+
+// AddrInfo returns the last known addresses of a peer in dialable form
+func (b *AddressBook) AddrInfo(peerID peer.ID) (peer.AddrInfo, bool) {
+	e, exists := b.Get(peerID)
+	if !exists {
+		return peer.AddrInfo{}, false
+	}
+
+	info := peer.AddrInfo{ID: peerID}
+	for _, s := range e.Addrs {
+		addr, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			continue
+		}
+		info.Addrs = append(info.Addrs, addr)
+	}
+	return info, len(info.Addrs) > 0
+}
+
+// Seen records that a peer of the given domain is reachable at the given
+// addresses
+func (b *AddressBook) Seen(peerID peer.ID, domain string, addrs []multiaddr.Multiaddr) {
+	b.mu.Lock()
+	e, exists := b.entries[peerID]
+	if !exists {
+		e = &AddressBookEntry{ID: peerID}
+		b.entries[peerID] = e
+	}
+	e.Domain = domain
+	e.LastSeen = time.Now()
+
+	if len(addrs) > 0 {
+		// a fresh slice, copies handed out by Get may still be reading
+		// the old one
+		fresh := make([]string, 0, min(len(addrs), maxAddrsPerPeer))
+		for _, addr := range addrs[:min(len(addrs), maxAddrsPerPeer)] {
+			fresh = append(fresh, addr.String())
+		}
+		e.Addrs = fresh
+	}
+	b.scheduleSave()
+	b.mu.Unlock()
+}
+
+func (b *AddressBook) SetNickname(peerID peer.ID, nickname string) {
+	b.mu.Lock()
+	e, exists := b.entries[peerID]
+	if !exists || e.Nickname == nickname {
+		b.mu.Unlock()
+		return
+	}
+	e.Nickname = nickname
+	b.scheduleSave()
+	b.mu.Unlock()
+}
+
+// scheduleSave writes the book out in the background, so callers on the
+// connection path never wait for the disk. b.mu must be held.
+func (b *AddressBook) scheduleSave() {
+	if b.path == "" || b.pending {
+		return
+	}
+	b.pending = true
+	time.AfterFunc(addressBookSaveDelay, b.save)
+}
+
+// Flush writes out any changes that haven't been saved yet
+func (b *AddressBook) Flush() {
+	b.mu.Lock()
+	pending := b.pending
+	b.mu.Unlock()
+
+	if pending {
+		b.save()
+	}
+}
+
+func (b *AddressBook) save() {
+	if b.path == "" {
+		return
+	}
+
+	b.saveMu.Lock()
+	defer b.saveMu.Unlock()
+
+	b.mu.Lock()
+	// changes from here on need another save
+	b.pending = false
+	entries := make([]*AddressBookEntry, 0, len(b.entries))
+	for _, e := range b.entries {
+		entries = append(entries, e)
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	b.mu.Unlock()
+
+	if err != nil {
+		logger.Warn("Failed to encode address book: %v", err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(b.path), 0700); err != nil {
+		logger.Warn("Failed to create address book directory: %v", err)
+		return
+	}
+
+	// write then rename so a crash never leaves a truncated book behind
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		logger.Warn("Failed to write address book: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, b.path); err != nil {
+		logger.Warn("Failed to save address book: %v", err)
+	}
+}
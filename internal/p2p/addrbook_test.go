@@ -0,0 +1,124 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/multiformats/go-multiaddr"
+)
+
+func testAddrs(t *testing.T, n int) []multiaddr.Multiaddr {
+	addrs := make([]multiaddr.Multiaddr, 0, n)
+	for i := range n {
+		addr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/ip4/10.0.0.%d/tcp/4001", i+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+func TestAddressBookPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	id := testPeerID(t)
+	unknown := testPeerID(t)
+
+	book, err := LoadAddressBook(path)
+	if err != nil {
+		t.Fatalf("failed to create address book: %v", err)
+	}
+	book.Seen(id, "team", testAddrs(t, maxAddrsPerPeer+5))
+	book.SetNickname(id, "alice")
+	book.SetNickname(unknown, "nobody")
+
+	// saved in the background, not by the callers
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("address book written synchronously: %v", err)
+	}
+	book.Flush()
+
+	loaded, err := LoadAddressBook(path)
+	if err != nil {
+		t.Fatalf("failed to load address book: %v", err)
+	}
+
+	e, ok := loaded.Get(id)
+	if !ok {
+		t.Fatal("peer missing after reload")
+	}
+	if e.Domain != "team" {
+		t.Errorf("wrong domain: got %q, want %q", e.Domain, "team")
+	}
+	if e.Nickname != "alice" {
+		t.Errorf("wrong nickname: got %q, want %q", e.Nickname, "alice")
+	}
+	if len(e.Addrs) != maxAddrsPerPeer {
+		t.Errorf("got %d addresses, want %d", len(e.Addrs), maxAddrsPerPeer)
+	}
+	if _, ok := loaded.Get(unknown); ok {
+		t.Error("nickname of an unseen peer was recorded")
+	}
+
+	info, ok := loaded.AddrInfo(id)
+	if !ok || len(info.Addrs) != maxAddrsPerPeer {
+		t.Errorf("AddrInfo returned %d addresses", len(info.Addrs))
+	}
+}
+
+func TestAddressBookDropsStalePeers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+
+	fresh, stale := testPeerID(t), testPeerID(t)
+
+	book, _ := LoadAddressBook(path)
+	book.Seen(fresh, "team", testAddrs(t, 1))
+	book.Seen(stale, "team", testAddrs(t, 1))
+	book.entries[stale].LastSeen = time.Now().Add(-maxAddressBookAge - time.Hour)
+	book.save()
+
+	loaded, err := LoadAddressBook(path)
+	if err != nil {
+		t.Fatalf("failed to load address book: %v", err)
+	}
+	if _, ok := loaded.Get(fresh); !ok {
+		t.Error("fresh peer was dropped")
+	}
+	if _, ok := loaded.Get(stale); ok {
+		t.Error("stale peer was kept")
+	}
+}
+
+// TestAddressBookCopies reads entries while they are updated, run with -race
+func TestAddressBookCopies(t *testing.T) {
+	book, _ := LoadAddressBook("")
+	id := testPeerID(t)
+	book.Seen(id, "team", testAddrs(t, 3))
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for range 500 {
+			book.Seen(id, "team", testAddrs(t, 3))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range 500 {
+			e, _ := book.Get(id)
+			for _, addr := range e.Addrs {
+				if addr == "" {
+					t.Error("empty address")
+				}
+			}
+			book.AddrInfo(id)
+		}
+	}()
+	wg.Wait()
+}
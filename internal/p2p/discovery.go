@@ -0,0 +1,212 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+)
+
+// PeerFoundFunc is called by a discovery backend for every peer it finds
+type PeerFoundFunc func(peer.AddrInfo)
+
+// Discovery is a mechanism for finding peers. Several backends can run on
+// the same host at once, they all report into the host's peer channel.
+type Discovery interface {
+	// Start begins discovery, reporting peers through found until Close
+	Start(h host.Host, found PeerFoundFunc) error
+	Close() error
+}
+
+// StartDiscovery starts each backend, stopping those already started if one
+// of them fails
+func (h *Host) StartDiscovery(backends ...Discovery) error {
+	h.discoveryMu.Lock()
+	defer h.discoveryMu.Unlock()
+
+	started := make([]Discovery, 0, len(backends))
+	for _, d := range backends {
+		if err := d.Start(h.Host, h.peerFound); err != nil {
+			for _, s := range started {
+				s.Close()
+			}
+			return err
+		}
+		started = append(started, d)
+	}
+
+	h.discoveries = append(h.discoveries, started...)
+	return nil
+}
+
+func (h *Host) stopDiscovery() {
+	h.discoveryMu.Lock()
+	defer h.discoveryMu.Unlock()
+
+	for _, d := range h.discoveries {
+		d.Close()
+	}
+	h.discoveries = nil
+}
+
+// peerFound hands newly discovered peers to whoever reads GetPeerChan
+func (h *Host) peerFound(pi peer.AddrInfo) {
+	if pi.ID == h.ID() || h.IsConnected(pi.ID) {
+		return
+	}
+
+	select {
+	case h.peerChan <- pi:
+	case <-h.ctx.Done():
+	}
+}
+
+// MDNSDiscovery finds peers on the local network via multicast DNS
+type MDNSDiscovery struct {
+	Rendezvous string
+
+	service mdns.Service
+}
+
+func NewMDNSDiscovery(rendezvous string) *MDNSDiscovery {
+	return &MDNSDiscovery{Rendezvous: rendezvous}
+}
+
+func (d *MDNSDiscovery) Start(h host.Host, found PeerFoundFunc) error {
+	d.service = mdns.NewMdnsService(h, d.Rendezvous, mdnsNotifee(found))
+	if err := d.service.Start(); err != nil {
+		return fmt.Errorf("failed to start mDNS: %w", err)
+	}
+	return nil
+}
+
+func (d *MDNSDiscovery) Close() error {
+	if d.service == nil {
+		return nil
+	}
+	return d.service.Close()
+}
+
+type mdnsNotifee PeerFoundFunc
+
+func (n mdnsNotifee) HandlePeerFound(pi peer.AddrInfo) {
+	n(pi)
+}
+
+// StaticDiscovery reports a fixed list of peers, retrying the ones we are
+// not connected to every Interval
+type StaticDiscovery struct {
+	Peers    []peer.AddrInfo
+	Interval time.Duration
+
+	cancel context.CancelFunc
+}
+
+const defaultStaticInterval = time.Minute
+
+func NewStaticDiscovery(peers []peer.AddrInfo) *StaticDiscovery {
+	return &StaticDiscovery{
+		Peers:    peers,
+		Interval: defaultStaticInterval,
+	}
+}
+
+func (d *StaticDiscovery) Start(h host.Host, found PeerFoundFunc) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+
+	interval := d.Interval
+	if interval <= 0 {
+		interval = defaultStaticInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			for _, pi := range d.Peers {
+				if h.Network().Connectedness(pi.ID) != network.Connected {
+					found(pi)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (d *StaticDiscovery) Close() error {
+	if d.cancel != nil {
+		d.cancel()
+	}
+	return nil
+}
+
+// MemoryNetwork is an in-process rendezvous point, hosts that start
+// discovery on the same MemoryNetwork find each other without any network
+// traffic. Intended for tests.
+type MemoryNetwork struct {
+	mu      sync.Mutex
+	members map[peer.ID]*memoryDiscovery
+}
+
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{
+		members: make(map[peer.ID]*memoryDiscovery),
+	}
+}
+
+// Discovery returns a backend attached to this network
+func (n *MemoryNetwork) Discovery() Discovery {
+	return &memoryDiscovery{net: n}
+}
+
+type memoryDiscovery struct {
+	net   *MemoryNetwork
+	host  host.Host
+	found PeerFoundFunc
+}
+
+func (d *memoryDiscovery) Start(h host.Host, found PeerFoundFunc) error {
+	d.host = h
+	d.found = found
+
+	d.net.mu.Lock()
+	others := make([]*memoryDiscovery, 0, len(d.net.members))
+	for _, m := range d.net.members {
+		others = append(others, m)
+	}
+	d.net.members[h.ID()] = d
+	d.net.mu.Unlock()
+
+	self := peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}
+	for _, m := range others {
+		go m.found(self)
+		go found(peer.AddrInfo{ID: m.host.ID(), Addrs: m.host.Addrs()})
+	}
+
+	return nil
+}
+
+func (d *memoryDiscovery) Close() error {
+	if d.host == nil {
+		return nil
+	}
+
+	d.net.mu.Lock()
+	delete(d.net.members, d.host.ID())
+	d.net.mu.Unlock()
+	return nil
+}
@@ -11,7 +11,6 @@ import (
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
-	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
 	"github.com/multiformats/go-multiaddr"
 )
 
@@ -19,6 +18,7 @@ import (
 type Host struct {
 	host.Host
 	pubsub   *pubsub.PubSub
+	scorer   *peerScorer
 	ctx      context.Context
 	cancel   context.CancelFunc
 	peerChan chan peer.AddrInfo
@@ -28,23 +28,62 @@ type Host struct {
 	peerEventChan chan PeerEvent
 
 	msgHandlers   map[MessageType]MessageHandler
+	msgValidators map[MessageType]MessageValidator
 	msgHandlersMu sync.RWMutex
 
-	metadata   MetadataResponse
-	metadataMu sync.RWMutex
+	metadata     MetadataResponse
+	metadataMu   sync.RWMutex
+	metadataSeq  uint64
+	metadataChan chan PeerMetadata
+
+	addrBook     *AddressBook
+	reconnecting map[peer.ID]bool
+	reconnectMu  sync.Mutex
+
+	discoveries []Discovery
+	discoveryMu sync.Mutex
+
+	relays *relayManager
+
+	rpcMethods map[string]rpcMethod
+	rpcMu      sync.RWMutex
 }
 
-func NewHost(ctx context.Context) (*Host, error) {
+func NewHost(ctx context.Context, opts HostOptions) (*Host, error) {
 	hostCtx, cancel := context.WithCancel(ctx)
 
-	h, err := libp2p.New()
+	relays := newRelayManager()
+
+	libp2pOpts, err := opts.libp2pOptions(relays.addrs)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	addrBook, err := LoadAddressBook(opts.AddressBookPath)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	h, err := libp2p.New(libp2pOpts...)
 	if err != nil {
 		cancel()
 		return nil, fmt.Errorf("failed to create libp2p host: %w", err)
 	}
 
 	// create pubsub (gossipsub)
-	ps, err := pubsub.NewGossipSub(hostCtx, h)
+	scorer := newPeerScorer()
+
+	// messages are signed by default, which is what lets validators trust
+	// the origin of a message. Our own messages are flooded to every room
+	// member above the publish threshold, rooms are small and otherwise
+	// whatever is sent before the mesh forms only arrives by gossip.
+	psOpts := append(scorer.options(),
+		pubsub.WithMaxMessageSize(MaxMessageSize),
+		pubsub.WithFloodPublish(true),
+	)
+	ps, err := pubsub.NewGossipSub(hostCtx, h, psOpts...)
 	if err != nil {
 		h.Close()
 		cancel()
@@ -54,36 +93,45 @@ func NewHost(ctx context.Context) (*Host, error) {
 	p2pHost := &Host{
 		Host:          h,
 		pubsub:        ps,
+		scorer:        scorer,
 		ctx:           hostCtx,
 		cancel:        cancel,
 		peerChan:      make(chan peer.AddrInfo, 10),
 		peerEventChan: make(chan PeerEvent, 10),
 		peers:         make(map[peer.ID]peer.AddrInfo),
 		msgHandlers:   make(map[MessageType]MessageHandler),
+		msgValidators: make(map[MessageType]MessageValidator),
 		metadata: MetadataResponse{
+			Domain:  opts.Domain,
 			Version: "1.0.0",
 			Custom:  make(map[string]string),
 		},
+		// seeded from the clock so a restarted peer doesn't look stale
+		metadataSeq:  uint64(time.Now().UnixNano()),
+		metadataChan: make(chan PeerMetadata, 10),
+		addrBook:     addrBook,
+		reconnecting: make(map[peer.ID]bool),
+		relays:       relays,
+		rpcMethods:   make(map[string]rpcMethod),
 	}
 
-	h.SetStreamHandler(ProtocolMetadata, p2pHost.handleMetadataStream)
+	h.SetStreamHandler(ProtocolRPC, p2pHost.handleRPCStream)
+	p2pHost.registerMetadataRPC()
 
 	p2pHost.setupNetworkNotifications()
 
+	if err := relays.start(p2pHost, opts.RelayService); err != nil {
+		h.Close()
+		cancel()
+		return nil, fmt.Errorf("failed to start relay: %w", err)
+	}
+
 	go p2pHost.cleanupStalePeers()
+	go p2pHost.reconnectKnownPeers()
 
 	return p2pHost, nil
 }
 
-func (h *Host) StartDiscovery(rendezvous string) error {
-	mdnsService := mdns.NewMdnsService(h, rendezvous, &discoveryNotifee{h: h})
-	if err := mdnsService.Start(); err != nil {
-		return fmt.Errorf("failed to start mDNS: %w", err)
-	}
-
-	return nil
-}
-
 // GetPeerChan returns a channel that recieves newly discovered peers
 func (h *Host) GetPeerChan() <-chan peer.AddrInfo {
 	return h.peerChan
@@ -93,6 +141,35 @@ func (h *Host) GetPeerEventChan() <-chan PeerEvent {
 	return h.peerEventChan
 }
 
+// DialableAddrs returns our listen addresses with the /p2p/<peer-id> suffix,
+// ready to be shared with peers that can't discover us over mDNS
+func (h *Host) DialableAddrs() []string {
+	addrs, err := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()})
+	if err != nil {
+		return nil
+	}
+
+	dialable := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		dialable = append(dialable, addr.String())
+	}
+	return dialable
+}
+
+// ParseAddrInfo parses a multiaddr that includes the /p2p/<peer-id> component
+func ParseAddrInfo(addr string) (*peer.AddrInfo, error) {
+	maddr, err := multiaddr.NewMultiaddr(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid multiaddr: %w", err)
+	}
+
+	peerInfo, err := peer.AddrInfoFromP2pAddr(maddr)
+	if err != nil {
+		return nil, fmt.Errorf("address must include /p2p/<peer-id>: %w", err)
+	}
+	return peerInfo, nil
+}
+
 func (h *Host) IsConnected(peerId peer.ID) bool {
 	return h.Network().Connectedness(peerId) == network.Connected
 }
@@ -146,6 +223,12 @@ func (h *Host) setupNetworkNotifications() {
 			delete(h.peers, peerId)
 			h.mu.Unlock()
 
+			// identify has filled in the peer's listen addrs by now
+			if domain, ok := h.knownInDomain(peerId); ok {
+				h.addrBook.Seen(peerId, domain, h.Peerstore().Addrs(peerId))
+				h.scheduleReconnect(peerId, reconnectBaseDelay)
+			}
+
 			select {
 			case h.peerEventChan <- PeerEvent{PeerId: peerId, Type: PeerEventDisconnected}:
 			case <-h.ctx.Done():
@@ -169,6 +252,9 @@ func (h *Host) cleanupStalePeers() {
 			for peerId := range h.peers {
 				if h.Network().Connectedness(peerId) != network.Connected {
 					delete(h.peers, peerId)
+					if _, ok := h.knownInDomain(peerId); ok {
+						h.scheduleReconnect(peerId, reconnectBaseDelay)
+					}
 
 					select {
 					case h.peerEventChan <- PeerEvent{PeerId: peerId, Type: PeerEventDisconnected}:
@@ -182,6 +268,9 @@ func (h *Host) cleanupStalePeers() {
 }
 
 func (h *Host) Close() error {
+	h.stopDiscovery()
+	h.relays.close()
 	h.cancel()
+	h.addrBook.Flush()
 	return h.Host.Close()
 }
@@ -0,0 +1,57 @@
+package p2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// newTestHost starts a host on loopback that is closed when the test ends
+func newTestHost(t *testing.T, opts HostOptions) *Host {
+	t.Helper()
+	if len(opts.ListenAddrs) == 0 {
+		opts.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+	}
+
+	h, err := NewHost(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("failed to create host: %v", err)
+	}
+	t.Cleanup(func() { h.Close() })
+
+	// nothing reads peer events in these tests, don't let them fill up
+	go func() {
+		for {
+			select {
+			case <-h.ctx.Done():
+				return
+			case <-h.peerEventChan:
+			}
+		}
+	}()
+	return h
+}
+
+func connectHosts(t *testing.T, a, b *Host) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := a.Connect(ctx, peer.AddrInfo{ID: b.ID(), Addrs: b.Addrs()}); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+}
+
+// waitFor polls cond until it holds or the timeout runs out
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return cond()
+}
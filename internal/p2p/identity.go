@@ -0,0 +1,148 @@
+package p2p
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/matt0792/lanchat/internal/logger"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	identityVersion    = 1
+	identitySaltSize   = 16
+	identityKeySize    = 32
+	identityIterations = 100000
+)
+
+// identityFile is the on-disk representation of a host identity
+type identityFile struct {
+	Version   int    `json:"version"`
+	Encrypted bool   `json:"encrypted"`
+	Salt      []byte `json:"salt,omitempty"`
+	Key       []byte `json:"key"`
+}
+
+// LoadOrCreateIdentity loads the private key stored at path, generating and
+// saving a new Ed25519 key on first use. A non-empty passphrase encrypts newly
+// created keys and is required to load encrypted ones.
+func LoadOrCreateIdentity(path, passphrase string) (crypto.PrivKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return decodeIdentity(data, passphrase)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read identity: %w", err)
+	}
+
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate identity: %w", err)
+	}
+
+	data, err = encodeIdentity(priv, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create identity directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write identity: %w", err)
+	}
+
+	logger.Info("Created new identity at %s", path)
+	return priv, nil
+}
+
+func encodeIdentity(priv crypto.PrivKey, passphrase string) ([]byte, error) {
+	keyBytes, err := crypto.MarshalPrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal identity: %w", err)
+	}
+
+	file := identityFile{
+		Version: identityVersion,
+		Key:     keyBytes,
+	}
+
+	if passphrase != "" {
+		salt := make([]byte, identitySaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+
+		gcm, err := identityCipher(passphrase, salt)
+		if err != nil {
+			return nil, err
+		}
+
+		nonce := make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+
+		file.Encrypted = true
+		file.Salt = salt
+		file.Key = gcm.Seal(nonce, nonce, keyBytes, nil)
+	}
+
+	return json.MarshalIndent(file, "", "  ")
+}
+
+func decodeIdentity(data []byte, passphrase string) (crypto.PrivKey, error) {
+	var file identityFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse identity: %w", err)
+	}
+	if file.Version != identityVersion {
+		return nil, fmt.Errorf("unsupported identity version: %d", file.Version)
+	}
+
+	keyBytes := file.Key
+	if file.Encrypted {
+		if passphrase == "" {
+			return nil, fmt.Errorf("identity is passphrase protected")
+		}
+
+		gcm, err := identityCipher(passphrase, file.Salt)
+		if err != nil {
+			return nil, err
+		}
+
+		nonceSize := gcm.NonceSize()
+		if len(keyBytes) < nonceSize {
+			return nil, fmt.Errorf("identity ciphertext too short")
+		}
+
+		keyBytes, err = gcm.Open(nil, keyBytes[:nonceSize], keyBytes[nonceSize:], nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt identity (wrong passphrase?)")
+		}
+	}
+
+	priv, err := crypto.UnmarshalPrivateKey(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal identity: %w", err)
+	}
+	return priv, nil
+}
+
+func identityCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key := pbkdf2.Key([]byte(passphrase), salt, identityIterations, identityKeySize, sha256.New)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,48 @@
+package p2p
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestIdentityPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.key")
+
+	first, err := LoadOrCreateIdentity(path, "")
+	if err != nil {
+		t.Fatalf("failed to create identity: %v", err)
+	}
+
+	second, err := LoadOrCreateIdentity(path, "")
+	if err != nil {
+		t.Fatalf("failed to load identity: %v", err)
+	}
+
+	id1, _ := peer.IDFromPrivateKey(first)
+	id2, _ := peer.IDFromPrivateKey(second)
+	if id1 != id2 {
+		t.Errorf("peer ID changed across loads: %s != %s", id1, id2)
+	}
+}
+
+func TestIdentityPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.key")
+
+	if _, err := LoadOrCreateIdentity(path, "secret"); err != nil {
+		t.Fatalf("failed to create identity: %v", err)
+	}
+
+	if _, err := LoadOrCreateIdentity(path, ""); err == nil {
+		t.Error("expected error loading encrypted identity without passphrase")
+	}
+
+	if _, err := LoadOrCreateIdentity(path, "wrong"); err == nil {
+		t.Error("expected error loading encrypted identity with wrong passphrase")
+	}
+
+	if _, err := LoadOrCreateIdentity(path, "secret"); err != nil {
+		t.Errorf("failed to load identity with passphrase: %v", err)
+	}
+}
@@ -1,43 +1,117 @@
 package p2p
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"slices"
+	"time"
 
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/matt0792/lanchat/internal/logger"
 )
 
+const (
+	RPCMetadataGet  = "metadata.get"
+	RPCMetadataPush = "metadata.push"
+
+	metadataTimeout    = 5 * time.Second
+	metadataMaxPayload = 64 << 10
+)
+
+var metadataRPCOptions = &RPCOptions{Timeout: metadataTimeout, MaxPayload: metadataMaxPayload}
+
+func (h *Host) registerMetadataRPC() {
+	h.RegisterRPC(RPCMetadataGet, h.handleMetadataGet, metadataRPCOptions)
+	h.RegisterRPC(RPCMetadataPush, h.handleMetadataPush, metadataRPCOptions)
+
+	// peers that predate the rpc layer still ask over the v1 protocol
+	h.Host.SetStreamHandler(ProtocolMetadata, h.handleMetadataStream)
+}
+
+// SetMetadata replaces our metadata and pushes it to every connected peer.
+// Peers that predate the rpc layer fail the push, they fetch our metadata
+// themselves whenever they list rooms or peers.
 func (h *Host) SetMetadata(md MetadataResponse) {
+	md.Custom = maps.Clone(md.Custom)
+	md.Rooms = slices.Clone(md.Rooms)
+	if md.Custom == nil {
+		md.Custom = make(map[string]string)
+	}
+
 	h.metadataMu.Lock()
-	defer h.metadataMu.Unlock()
+	h.metadataSeq++
+	md.Seq = h.metadataSeq
 	h.metadata = md
-	if h.metadata.Custom == nil {
-		h.metadata.Custom = make(map[string]string)
+	h.metadataMu.Unlock()
+
+	// identify may not have told us which peers speak rpc yet, so try them all
+	for _, peerID := range h.Network().Peers() {
+		go func(p peer.ID) {
+			if err := h.Call(h.ctx, p, RPCMetadataPush, md, nil, metadataRPCOptions); err != nil {
+				logger.Debug("Failed to push metadata to %s: %v", p.String()[:8], err)
+			}
+		}(peerID)
 	}
 }
 
+// GetMetadata returns a copy of our metadata
 func (h *Host) GetMetadata() MetadataResponse {
 	h.metadataMu.RLock()
 	defer h.metadataMu.RUnlock()
-	return h.metadata
+
+	md := h.metadata
+	md.Custom = maps.Clone(md.Custom)
+	md.Rooms = slices.Clone(md.Rooms)
+	return md
+}
+
+// GetMetadataChan returns a channel that receives metadata pushed by peers
+func (h *Host) GetMetadataChan() <-chan PeerMetadata {
+	return h.metadataChan
 }
 
+// RequestPeerMetadata fetches a peer's metadata, over rpc when the peer
+// supports it and the v1 protocol otherwise. Peers of our domain are
+// remembered in the address book.
 func (h *Host) RequestPeerMetadata(peerID peer.ID) (*MetadataResponse, error) {
-	stream, err := h.NewStream(h.ctx, peerID, ProtocolMetadata)
+	md, err := h.requestPeerMetadata(peerID)
+	if err != nil {
+		return nil, err
+	}
+	h.rememberPeer(peerID, md.Domain)
+	return md, nil
+}
+
+func (h *Host) requestPeerMetadata(peerID peer.ID) (*MetadataResponse, error) {
+	ctx, cancel := context.WithTimeout(h.ctx, metadataTimeout)
+	defer cancel()
+
+	// identify may not have finished yet, so let the peer pick the protocol
+	stream, err := h.NewStream(ctx, peerID, ProtocolRPC, ProtocolMetadata)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open stream %w", err)
 	}
 	defer stream.Close()
 
-	req := MetadataRequest{Type: "get"}
+	req := MetadataRequest{Type: "get", Domain: h.GetMetadata().Domain}
+
+	var resp MetadataResponse
+	if stream.Protocol() == ProtocolRPC {
+		if err := h.callOnStream(ctx, stream, RPCMetadataGet, req, &resp, metadataRPCOptions); err != nil {
+			return nil, err
+		}
+		return &resp, nil
+	}
+
+	stream.SetDeadline(time.Now().Add(metadataTimeout))
 	if err := json.NewEncoder(stream).Encode(req); err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 
 	// read response
-	var resp MetadataResponse
 	if err := json.NewDecoder(stream).Decode(&resp); err != nil {
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
@@ -45,8 +119,44 @@ func (h *Host) RequestPeerMetadata(peerID peer.ID) (*MetadataResponse, error) {
 	return &resp, nil
 }
 
+// metadataFor is what we tell a peer in the given domain, peers from other
+// domains only learn which domain we are in
+func (h *Host) metadataFor(domain string) MetadataResponse {
+	own := h.GetMetadata()
+	if domain != own.Domain {
+		return MetadataResponse{Domain: own.Domain}
+	}
+	return own
+}
+
+func (h *Host) handleMetadataGet(_ context.Context, _ peer.ID, payload json.RawMessage) (any, error) {
+	var req MetadataRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, &RPCError{Code: RPCCodeBadRequest, Message: "malformed metadata request"}
+	}
+	return h.metadataFor(req.Domain), nil
+}
+
+func (h *Host) handleMetadataPush(ctx context.Context, from peer.ID, payload json.RawMessage) (any, error) {
+	var md MetadataResponse
+	if err := json.Unmarshal(payload, &md); err != nil {
+		return nil, &RPCError{Code: RPCCodeBadRequest, Message: "malformed metadata"}
+	}
+	if md.Domain != h.GetMetadata().Domain {
+		return nil, nil
+	}
+	h.rememberPeer(from, md.Domain)
+
+	select {
+	case h.metadataChan <- PeerMetadata{PeerID: from, Metadata: md}:
+	case <-ctx.Done():
+	}
+	return nil, nil
+}
+
 func (h *Host) handleMetadataStream(stream network.Stream) {
 	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(metadataTimeout))
 
 	var req MetadataRequest
 	if err := json.NewDecoder(stream).Decode(&req); err != nil {
@@ -54,11 +164,7 @@ func (h *Host) handleMetadataStream(stream network.Stream) {
 		return
 	}
 
-	h.metadataMu.RLock()
-	resp := h.metadata
-	h.metadataMu.RUnlock()
-
-	if err := json.NewEncoder(stream).Encode(resp); err != nil {
+	if err := json.NewEncoder(stream).Encode(h.metadataFor(req.Domain)); err != nil {
 		logger.Warn("Failed to encode metadata response: %v", err)
 	}
 }
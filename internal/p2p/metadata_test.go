@@ -0,0 +1,93 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+)
+
+// nextPush waits for metadata from peer p to be pushed to h
+func nextPush(t *testing.T, h, p *Host) MetadataResponse {
+	t.Helper()
+	deadline := time.After(3 * time.Second)
+	for {
+		select {
+		case update := <-h.GetMetadataChan():
+			if update.PeerID == p.ID() {
+				return update.Metadata
+			}
+		case <-deadline:
+			t.Fatal("timeout waiting for metadata push")
+		}
+	}
+}
+
+func TestMetadataPush(t *testing.T) {
+	a := newTestHost(t, HostOptions{})
+	b := newTestHost(t, HostOptions{})
+	a.SetMetadata(MetadataResponse{Domain: "team", Nickname: "alice"})
+	b.SetMetadata(MetadataResponse{Domain: "team", Nickname: "bob"})
+	connectHosts(t, a, b)
+
+	a.SetMetadata(MetadataResponse{Domain: "team", Nickname: "alice2"})
+	first := nextPush(t, b, a)
+	if first.Nickname != "alice2" {
+		t.Errorf("wrong nickname pushed: got %q, want %q", first.Nickname, "alice2")
+	}
+
+	a.SetMetadata(MetadataResponse{Domain: "team", Nickname: "alice3"})
+	second := nextPush(t, b, a)
+	if second.Seq <= first.Seq {
+		t.Errorf("sequence didn't increase: %d after %d", second.Seq, first.Seq)
+	}
+
+	// a restarted peer must not look older than before
+	restarted := newTestHost(t, HostOptions{})
+	restarted.SetMetadata(MetadataResponse{Domain: "team", Nickname: "alice"})
+	if seq := restarted.GetMetadata().Seq; seq <= second.Seq {
+		t.Errorf("restarted peer starts at %d, before %d", seq, second.Seq)
+	}
+}
+
+func TestMetadataOtherDomain(t *testing.T) {
+	a := newTestHost(t, HostOptions{})
+	b := newTestHost(t, HostOptions{})
+	a.SetMetadata(MetadataResponse{Domain: "team", Nickname: "alice"})
+	b.SetMetadata(MetadataResponse{Domain: "other", Nickname: "bob"})
+	connectHosts(t, a, b)
+
+	md, err := a.RequestPeerMetadata(b.ID())
+	if err != nil {
+		t.Fatalf("failed to get metadata: %v", err)
+	}
+	if md.Domain != "other" || md.Nickname != "" {
+		t.Errorf("peer from another domain told us %+v", md)
+	}
+
+	a.SetMetadata(MetadataResponse{Domain: "team", Nickname: "alice2"})
+	select {
+	case update := <-b.GetMetadataChan():
+		if update.PeerID == a.ID() {
+			t.Errorf("push accepted from another domain: %+v", update.Metadata)
+		}
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestMetadataV1Fallback(t *testing.T) {
+	a := newTestHost(t, HostOptions{})
+	old := newTestHost(t, HostOptions{})
+	a.SetMetadata(MetadataResponse{Domain: "team", Nickname: "alice"})
+	old.SetMetadata(MetadataResponse{Domain: "team", Nickname: "old"})
+
+	// a peer from before the rpc layer only serves the v1 protocol
+	old.RemoveStreamHandler(ProtocolRPC)
+	connectHosts(t, a, old)
+
+	md, err := a.RequestPeerMetadata(old.ID())
+	if err != nil {
+		t.Fatalf("failed to get metadata: %v", err)
+	}
+	if md.Nickname != "old" {
+		t.Errorf("wrong nickname: got %q, want %q", md.Nickname, "old")
+	}
+}
@@ -0,0 +1,274 @@
+package p2p
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/pnet"
+	libp2pquic "github.com/libp2p/go-libp2p/p2p/transport/quic"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const (
+	TransportTCP  = "tcp"
+	TransportQUIC = "quic"
+)
+
+// HostOptions configures the underlying libp2p host
+type HostOptions struct {
+	// Identity is the private key the peer ID is derived from. A random
+	// key is generated when nil.
+	Identity crypto.PrivKey
+
+	// ListenAddrs are the multiaddrs to listen on. Defaults to any port on
+	// all addresses for every enabled transport.
+	ListenAddrs []string
+	// Transports lists the enabled transports (tcp, quic), all when empty
+	Transports []string
+	// AnnounceAddrs replaces the addresses advertised to other peers
+	AnnounceAddrs []string
+
+	// AllowInterfaces restricts listening and advertising to the named
+	// network interfaces
+	AllowInterfaces []string
+	// DenyInterfaces excludes the named network interfaces
+	DenyInterfaces []string
+
+	// PrivateNetworkKey turns the host into a libp2p private network member
+	// that only connects to peers holding the same 32 byte key. QUIC does
+	// not support private networks, so only TCP is used when it is set.
+	PrivateNetworkKey []byte
+
+	// RelayService runs a circuit relay for peers that can't dial each
+	// other directly. Every host automatically reserves slots on the relays
+	// it connects to and advertises the relayed addresses.
+	RelayService bool
+
+	// AddressBookPath is where peers we have seen are remembered between
+	// runs. The address book is kept in memory only when empty.
+	AddressBookPath string
+	// Domain is the lanchat domain we start in. Only peers that tell us
+	// they are in our domain are remembered and redialed.
+	Domain string
+}
+
+var defaultListenAddrs = map[string][]string{
+	TransportTCP: {
+		"/ip4/0.0.0.0/tcp/0",
+		"/ip6/::/tcp/0",
+	},
+	TransportQUIC: {
+		"/ip4/0.0.0.0/udp/0/quic-v1",
+		"/ip6/::/udp/0/quic-v1",
+	},
+}
+
+// libp2pOptions translates the host options into libp2p constructor options.
+// extraAddrs is advertised on top of the regular addresses.
+func (o HostOptions) libp2pOptions(extraAddrs func() []multiaddr.Multiaddr) ([]libp2p.Option, error) {
+	var opts []libp2p.Option
+
+	if o.Identity != nil {
+		opts = append(opts, libp2p.Identity(o.Identity))
+	}
+
+	transports, err := o.transports()
+	if err != nil {
+		return nil, err
+	}
+
+	if o.PrivateNetworkKey != nil {
+		if len(o.PrivateNetworkKey) != 32 {
+			return nil, fmt.Errorf("private network key must be 32 bytes")
+		}
+		opts = append(opts, libp2p.PrivateNetwork(pnet.PSK(o.PrivateNetworkKey)))
+	}
+	for _, t := range transports {
+		switch t {
+		case TransportTCP:
+			opts = append(opts, libp2p.Transport(tcp.NewTCPTransport))
+		case TransportQUIC:
+			opts = append(opts, libp2p.Transport(libp2pquic.NewTransport))
+		}
+	}
+
+	listenAddrs, err := parseAddrs(o.ListenAddrs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address: %w", err)
+	}
+	if len(listenAddrs) == 0 {
+		for _, t := range transports {
+			for _, s := range defaultListenAddrs[t] {
+				listenAddrs = append(listenAddrs, multiaddr.StringCast(s))
+			}
+		}
+	}
+
+	announceAddrs, err := parseAddrs(o.AnnounceAddrs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid announce address: %w", err)
+	}
+
+	var allowedIPs map[string]bool
+	if len(o.AllowInterfaces) > 0 || len(o.DenyInterfaces) > 0 {
+		allowedIPs, err = interfaceIPs(o.AllowInterfaces, o.DenyInterfaces)
+		if err != nil {
+			return nil, err
+		}
+		listenAddrs = bindToIPs(listenAddrs, allowedIPs)
+		if len(listenAddrs) == 0 {
+			return nil, fmt.Errorf("no listen addresses left after interface filtering")
+		}
+	}
+
+	opts = append(opts, libp2p.ListenAddrs(listenAddrs...))
+
+	opts = append(opts, libp2p.AddrsFactory(func(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+		if len(announceAddrs) > 0 {
+			addrs = slices.Clone(announceAddrs)
+		} else if allowedIPs != nil {
+			addrs = filterByIPs(addrs, allowedIPs)
+		}
+		return append(addrs, extraAddrs()...)
+	}))
+
+	return opts, nil
+}
+
+func (o HostOptions) transports() ([]string, error) {
+	if len(o.Transports) == 0 {
+		if o.PrivateNetworkKey != nil {
+			return []string{TransportTCP}, nil
+		}
+		return []string{TransportTCP, TransportQUIC}, nil
+	}
+
+	seen := make(map[string]bool)
+	transports := make([]string, 0, len(o.Transports))
+	for _, t := range o.Transports {
+		t = strings.ToLower(strings.TrimSpace(t))
+		switch t {
+		case TransportTCP:
+		case TransportQUIC:
+			if o.PrivateNetworkKey != nil {
+				return nil, fmt.Errorf("quic does not support private networks, use tcp")
+			}
+		default:
+			return nil, fmt.Errorf("unknown transport: %q", t)
+		}
+		if !seen[t] {
+			seen[t] = true
+			transports = append(transports, t)
+		}
+	}
+	return transports, nil
+}
+
+func parseAddrs(addrs []string) ([]multiaddr.Multiaddr, error) {
+	parsed := make([]multiaddr.Multiaddr, 0, len(addrs))
+	for _, s := range addrs {
+		addr, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s, err)
+		}
+		parsed = append(parsed, addr)
+	}
+	return parsed, nil
+}
+
+// interfaceIPs collects the addresses of every interface that passes the
+// allow and deny lists
+func interfaceIPs(allow, deny []string) (map[string]bool, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interfaces: %w", err)
+	}
+
+	allowed := make(map[string]bool)
+	for _, name := range allow {
+		allowed[name] = true
+	}
+	denied := make(map[string]bool)
+	for _, name := range deny {
+		denied[name] = true
+	}
+
+	ips := make(map[string]bool)
+	for _, iface := range ifaces {
+		if len(allowed) > 0 && !allowed[iface.Name] {
+			continue
+		}
+		if denied[iface.Name] {
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			ips[ipNet.IP.String()] = true
+		}
+	}
+
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no usable addresses on the selected interfaces")
+	}
+	return ips, nil
+}
+
+// bindToIPs expands unspecified listen addresses (0.0.0.0, ::) into one
+// address per allowed IP of the same family, and drops explicit ones on
+// IPs that aren't allowed
+func bindToIPs(addrs []multiaddr.Multiaddr, ips map[string]bool) []multiaddr.Multiaddr {
+	var bound []multiaddr.Multiaddr
+	for _, addr := range addrs {
+		ip, err := manet.ToIP(addr)
+		if err != nil {
+			bound = append(bound, addr)
+			continue
+		}
+		if !ip.IsUnspecified() {
+			if ips[ip.String()] {
+				bound = append(bound, addr)
+			}
+			continue
+		}
+
+		_, rest := multiaddr.SplitFirst(addr)
+		for s := range ips {
+			candidate := net.ParseIP(s)
+			if (candidate.To4() != nil) != (ip.To4() != nil) {
+				continue
+			}
+
+			ipAddr, err := manet.FromIP(candidate)
+			if err != nil {
+				continue
+			}
+			bound = append(bound, ipAddr.Encapsulate(rest))
+		}
+	}
+	return bound
+}
+
+func filterByIPs(addrs []multiaddr.Multiaddr, ips map[string]bool) []multiaddr.Multiaddr {
+	filtered := make([]multiaddr.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		ip, err := manet.ToIP(addr)
+		if err != nil || ips[ip.String()] {
+			filtered = append(filtered, addr)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,104 @@
+package p2p
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestTransports(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    HostOptions
+		want    []string
+		wantErr bool
+	}{
+		{name: "default", want: []string{TransportTCP, TransportQUIC}},
+		{name: "default private", opts: HostOptions{PrivateNetworkKey: make([]byte, 32)}, want: []string{TransportTCP}},
+		{name: "explicit", opts: HostOptions{Transports: []string{"tcp", " QUIC ", "tcp"}}, want: []string{TransportTCP, TransportQUIC}},
+		{name: "tcp only", opts: HostOptions{Transports: []string{"tcp"}}, want: []string{TransportTCP}},
+		{name: "quic private", opts: HostOptions{Transports: []string{"quic"}, PrivateNetworkKey: make([]byte, 32)}, wantErr: true},
+		{name: "unknown", opts: HostOptions{Transports: []string{"udp"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.opts.transports()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQUIC(t *testing.T) {
+	opts := HostOptions{
+		Transports:  []string{TransportQUIC},
+		ListenAddrs: []string{"/ip4/127.0.0.1/udp/0/quic-v1"},
+	}
+	a := newTestHost(t, opts)
+	b := newTestHost(t, opts)
+
+	connectHosts(t, a, b)
+	for _, conn := range a.Host.Network().ConnsToPeer(b.ID()) {
+		if _, err := conn.RemoteMultiaddr().ValueForProtocol(multiaddr.P_QUIC_V1); err != nil {
+			t.Errorf("connected over %s, want quic", conn.RemoteMultiaddr())
+		}
+	}
+}
+
+func TestBindToIPs(t *testing.T) {
+	ips := map[string]bool{"192.168.1.20": true, "fd00::20": true}
+
+	tests := []struct {
+		name  string
+		addrs []string
+		want  []string
+	}{
+		{
+			name:  "unspecified",
+			addrs: []string{"/ip4/0.0.0.0/tcp/4001", "/ip6/::/udp/0/quic-v1"},
+			want:  []string{"/ip4/192.168.1.20/tcp/4001", "/ip6/fd00::20/udp/0/quic-v1"},
+		},
+		{
+			name:  "allowed ip",
+			addrs: []string{"/ip4/192.168.1.20/tcp/4001"},
+			want:  []string{"/ip4/192.168.1.20/tcp/4001"},
+		},
+		{
+			name:  "denied ip",
+			addrs: []string{"/ip4/10.8.0.2/tcp/4001", "/ip4/192.168.1.20/tcp/4002"},
+			want:  []string{"/ip4/192.168.1.20/tcp/4002"},
+		},
+		{
+			name:  "nothing allowed",
+			addrs: []string{"/ip4/10.8.0.2/tcp/4001"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addrs, err := parseAddrs(tt.addrs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, addr := range bindToIPs(addrs, ips) {
+				got = append(got, addr.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
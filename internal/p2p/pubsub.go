@@ -7,28 +7,43 @@ import (
 	"time"
 
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/matt0792/lanchat/internal/logger"
 )
 
 type Topic struct {
+	name  string
 	topic *pubsub.Topic
 	sub   *pubsub.Subscription
 	host  *Host
 }
 
 func (h *Host) JoinTopic(topicName string) (*Topic, error) {
+	if err := h.pubsub.RegisterTopicValidator(topicName, h.validateMessage); err != nil {
+		return nil, fmt.Errorf("failed to register validator: %w", err)
+	}
+
 	topic, err := h.pubsub.Join(topicName)
 	if err != nil {
+		h.pubsub.UnregisterTopicValidator(topicName)
 		return nil, fmt.Errorf("failed to join topic: %w", err)
 	}
 
+	if err := topic.SetScoreParams(topicScoreParams()); err != nil {
+		topic.Close()
+		h.pubsub.UnregisterTopicValidator(topicName)
+		return nil, fmt.Errorf("failed to set score parameters: %w", err)
+	}
+
 	sub, err := topic.Subscribe()
 	if err != nil {
 		topic.Close()
+		h.pubsub.UnregisterTopicValidator(topicName)
 		return nil, fmt.Errorf("failed to subscribe to topic: %w", err)
 	}
 
 	t := &Topic{
+		name:  topicName,
 		topic: topic,
 		sub:   sub,
 		host:  h,
@@ -64,11 +79,18 @@ func (t *Topic) Publish(msgType MessageType, data interface{}) error {
 	return nil
 }
 
+// ReadMessages delivers messages on the topic to the channel and the
+// registered handlers. Our own messages are included with Local set.
+// Handlers run one at a time in the order messages arrived.
 func (t *Topic) ReadMessages(ctx context.Context) <-chan *Message {
 	msgChan := make(chan *Message, 10)
+	handlerChan := make(chan *Message, 32)
+
+	go t.runHandlers(handlerChan)
 
 	go func() {
 		defer close(msgChan)
+		defer close(handlerChan)
 		for {
 			msg, err := t.sub.Next(ctx)
 			if err != nil {
@@ -78,39 +100,59 @@ func (t *Topic) ReadMessages(ctx context.Context) <-chan *Message {
 				return
 			}
 
-			if msg.ReceivedFrom == t.host.ID() {
-				continue
-			}
-
-			var parsedMsg Message
-			if err := json.Unmarshal(msg.Data, &parsedMsg); err != nil {
-				logger.Warn("Failed to unmarshal message: %v", err)
+			// validateMessage has already parsed and checked the envelope
+			parsedMsg, ok := msg.ValidatorData.(*Message)
+			if !ok {
 				continue
 			}
+			parsedMsg.Topic = t.name
+			parsedMsg.PubsubID = pubsub.DefaultMsgIdFn(msg.Message)
+			parsedMsg.Signed = signedRecord(msg)
+			parsedMsg.Local = msg.ReceivedFrom == t.host.ID()
 
 			select {
-			case msgChan <- &parsedMsg:
+			case msgChan <- parsedMsg:
 			case <-ctx.Done():
 				return
 			}
 
-			// call handlers
-			t.host.msgHandlersMu.RLock()
-			if handler, exists := t.host.msgHandlers[parsedMsg.Type]; exists {
-				go func(m Message) {
-					if err := handler(&m); err != nil {
-						logger.Error("Error in message handler: %v", err)
-					}
-				}(parsedMsg)
+			handled := *parsedMsg
+			select {
+			case handlerChan <- &handled:
+			case <-ctx.Done():
+				return
 			}
-			t.host.msgHandlersMu.RUnlock()
 		}
 	}()
 
 	return msgChan
 }
 
+// runHandlers calls the handler of each message in turn, so a change can't
+// overtake the message it refers to
+func (t *Topic) runHandlers(messages <-chan *Message) {
+	for msg := range messages {
+		t.host.msgHandlersMu.RLock()
+		handler, exists := t.host.msgHandlers[msg.Type]
+		t.host.msgHandlersMu.RUnlock()
+
+		if !exists {
+			continue
+		}
+		if err := handler(msg); err != nil {
+			logger.Error("Error in message handler: %v", err)
+		}
+	}
+}
+
+// ListPeers returns the peers we know to be subscribed to the topic
+func (t *Topic) ListPeers() []peer.ID {
+	return t.topic.ListPeers()
+}
+
 func (t *Topic) Close() error {
 	t.sub.Cancel()
-	return t.topic.Close()
+	err := t.topic.Close()
+	t.host.pubsub.UnregisterTopicValidator(t.name)
+	return err
 }
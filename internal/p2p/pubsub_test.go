@@ -0,0 +1,86 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHandlerOrder(t *testing.T) {
+	const msgType MessageType = "test"
+	const count = 100
+
+	sender := newTestHost(t, HostOptions{})
+	receiver := newTestHost(t, HostOptions{})
+	connectHosts(t, sender, receiver)
+
+	var (
+		mu       sync.Mutex
+		received = make(map[bool][]int)
+	)
+	for _, h := range []*Host{sender, receiver} {
+		h.RegisterMessageHandler(msgType, func(msg *Message) error {
+			var payload struct{ N int }
+			if err := json.Unmarshal(msg.Data, &payload); err != nil {
+				return err
+			}
+			mu.Lock()
+			received[msg.Local] = append(received[msg.Local], payload.N)
+			mu.Unlock()
+			return nil
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var topics []*Topic
+	for _, h := range []*Host{sender, receiver} {
+		topic, err := h.JoinTopic("order")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			for range topic.ReadMessages(ctx) {
+			}
+		}()
+		topics = append(topics, topic)
+	}
+
+	if !waitFor(t, 5*time.Second, func() bool {
+		return slices.Contains(topics[0].ListPeers(), receiver.ID()) &&
+			slices.Contains(topics[1].ListPeers(), sender.ID())
+	}) {
+		t.Fatal("hosts never saw each other in the topic")
+	}
+
+	want := make([]int, count)
+	for i := range want {
+		want[i] = i
+		if err := topics[0].Publish(msgType, map[string]int{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+		// stay within the peer's outbound queue
+		if i%20 == 19 {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	done := waitFor(t, 5*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received[true]) == count && len(received[false]) == count
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	if !done {
+		t.Fatalf("handled %d local and %d remote messages, want %d", len(received[true]), len(received[false]), count)
+	}
+	// pubsub may reorder messages from others on the way, the app buffers
+	// those, but our own must be handled in the order we published them
+	if !slices.Equal(received[true], want) {
+		t.Errorf("local messages handled out of order: %v", received[true])
+	}
+}
@@ -0,0 +1,119 @@
+package p2p
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/matt0792/lanchat/internal/logger"
+)
+
+const (
+	reconnectBaseDelay   = time.Second
+	reconnectMaxDelay    = 5 * time.Minute
+	reconnectMaxAttempts = 12
+	reconnectDialTimeout = 10 * time.Second
+)
+
+func (h *Host) AddressBook() *AddressBook {
+	return h.addrBook
+}
+
+// reconnectKnownPeers redials everyone of our domain in the address book,
+// used on startup
+func (h *Host) reconnectKnownPeers() {
+	domain := h.GetMetadata().Domain
+	for _, e := range h.addrBook.Entries() {
+		if e.Domain == domain {
+			h.scheduleReconnect(e.ID, 0)
+		}
+	}
+}
+
+// rememberPeer records a connected peer in the address book once it has
+// told us it is in our domain
+func (h *Host) rememberPeer(peerID peer.ID, domain string) {
+	if domain != h.GetMetadata().Domain || !h.IsConnected(peerID) {
+		return
+	}
+	h.addrBook.Seen(peerID, domain, h.Peerstore().Addrs(peerID))
+}
+
+// knownInDomain reports whether the address book has a peer as a member of
+// our domain, and returns the domain
+func (h *Host) knownInDomain(peerID peer.ID) (string, bool) {
+	domain := h.GetMetadata().Domain
+	e, ok := h.addrBook.Get(peerID)
+	return domain, ok && e.Domain == domain
+}
+
+// scheduleReconnect redials a peer from its address book entry with
+// exponential backoff until it connects or we run out of attempts
+func (h *Host) scheduleReconnect(peerID peer.ID, initialDelay time.Duration) {
+	h.reconnectMu.Lock()
+	if h.reconnecting[peerID] {
+		h.reconnectMu.Unlock()
+		return
+	}
+	h.reconnecting[peerID] = true
+	h.reconnectMu.Unlock()
+
+	go func() {
+		defer func() {
+			h.reconnectMu.Lock()
+			delete(h.reconnecting, peerID)
+			h.reconnectMu.Unlock()
+		}()
+
+		delay := initialDelay
+		for attempt := 1; attempt <= reconnectMaxAttempts; attempt++ {
+			select {
+			case <-h.ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+
+			if h.IsConnected(peerID) {
+				return
+			}
+
+			info, ok := h.addrBook.AddrInfo(peerID)
+			if !ok {
+				return
+			}
+
+			err := h.redial(info)
+			if err == nil {
+				logger.Info("Reconnected to peer %s", peerID.String()[:8])
+				return
+			}
+			logger.Debug("Reconnect attempt %d to %s failed: %v", attempt, peerID.String()[:8], err)
+
+			delay = nextReconnectDelay(delay)
+		}
+
+		logger.Debug("Giving up reconnecting to %s", peerID.String()[:8])
+	}()
+}
+
+// nextReconnectDelay doubles the wait between attempts, up to
+// reconnectMaxDelay
+func nextReconnectDelay(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return reconnectBaseDelay
+	}
+	return min(delay*2, reconnectMaxDelay)
+}
+
+func (h *Host) redial(info peer.AddrInfo) error {
+	// our own backoff replaces the swarm's, which would otherwise reject
+	// dials to an address that failed recently
+	if sw, ok := h.Network().(*swarm.Swarm); ok {
+		sw.Backoff().Clear(info.ID)
+	}
+
+	ctx, cancel := context.WithTimeout(h.ctx, reconnectDialTimeout)
+	defer cancel()
+	return h.Connect(ctx, info)
+}
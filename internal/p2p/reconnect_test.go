@@ -0,0 +1,96 @@
+package p2p
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReconnectBackoff(t *testing.T) {
+	var delays []time.Duration
+	delay := time.Duration(0)
+	for range reconnectMaxAttempts {
+		delay = nextReconnectDelay(delay)
+		delays = append(delays, delay)
+	}
+
+	if delays[0] != reconnectBaseDelay {
+		t.Errorf("first retry after %v, want %v", delays[0], reconnectBaseDelay)
+	}
+	for i := 1; i < len(delays); i++ {
+		want := min(delays[i-1]*2, reconnectMaxDelay)
+		if delays[i] != want {
+			t.Errorf("retry %d after %v, want %v", i+1, delays[i], want)
+		}
+	}
+	if last := delays[len(delays)-1]; last != reconnectMaxDelay {
+		t.Errorf("backoff ends at %v, want %v", last, reconnectMaxDelay)
+	}
+}
+
+func TestReconnectAfterDisconnect(t *testing.T) {
+	a := newTestHost(t, HostOptions{Domain: "team"})
+	b := newTestHost(t, HostOptions{Domain: "team"})
+	connectHosts(t, a, b)
+	if _, err := a.RequestPeerMetadata(b.ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := a.AddressBook().AddrInfo(b.ID()); !ok {
+		t.Fatal("peer not in address book after connecting")
+	}
+
+	a.Network().ClosePeer(b.ID())
+	if !waitFor(t, time.Second, func() bool { return !a.IsConnected(b.ID()) }) {
+		t.Fatal("peer still connected")
+	}
+
+	if !waitFor(t, reconnectBaseDelay+5*time.Second, func() bool { return a.IsConnected(b.ID()) }) {
+		t.Fatal("peer was not redialed")
+	}
+}
+
+func TestReconnectOnStartup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	b := newTestHost(t, HostOptions{Domain: "team"})
+
+	first := newTestHost(t, HostOptions{AddressBookPath: path, Domain: "team"})
+	connectHosts(t, first, b)
+	if _, err := first.RequestPeerMetadata(b.ID()); err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+
+	// peers of the domain we were in before aren't ours anymore
+	other := newTestHost(t, HostOptions{AddressBookPath: path, Domain: "other"})
+	if waitFor(t, time.Second, func() bool { return other.IsConnected(b.ID()) }) {
+		t.Error("peer of another domain was dialed on startup")
+	}
+	other.Close()
+
+	second := newTestHost(t, HostOptions{AddressBookPath: path, Domain: "team"})
+	if !waitFor(t, 5*time.Second, func() bool { return second.IsConnected(b.ID()) }) {
+		t.Fatal("known peer was not dialed on startup")
+	}
+}
+
+func TestNoReconnectOtherDomain(t *testing.T) {
+	a := newTestHost(t, HostOptions{Domain: "team"})
+	b := newTestHost(t, HostOptions{Domain: "other"})
+	connectHosts(t, a, b)
+	if _, err := a.RequestPeerMetadata(b.ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := a.AddressBook().Get(b.ID()); ok {
+		t.Fatal("peer of another domain in address book")
+	}
+
+	a.Network().ClosePeer(b.ID())
+	if !waitFor(t, time.Second, func() bool { return !a.IsConnected(b.ID()) }) {
+		t.Fatal("peer still connected")
+	}
+	if waitFor(t, reconnectBaseDelay+time.Second, func() bool { return a.IsConnected(b.ID()) }) {
+		t.Error("peer of another domain was redialed")
+	}
+}
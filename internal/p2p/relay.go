@@ -0,0 +1,275 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/proto"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	"github.com/matt0792/lanchat/internal/logger"
+	"github.com/multiformats/go-multiaddr"
+)
+
+const (
+	ProtocolRelayPeers protocol.ID = "/lanchat/relay/peers/1.0.0"
+
+	// most relays we hold a reservation with at once
+	maxRelays = 2
+
+	relayRefreshMargin  = 2 * time.Minute
+	relayRetryDelay     = 30 * time.Second
+	relayPeersInterval  = time.Minute
+	relayRequestTimeout = 10 * time.Second
+)
+
+// relayManager reserves slots on peers running the relay service and keeps
+// track of the relayed addresses they give us
+type relayManager struct {
+	h *Host
+
+	service *relayv2.Relay
+
+	mu     sync.RWMutex
+	relays map[peer.ID]multiaddr.Multiaddr // relay -> our circuit addr through it, nil while reserving
+}
+
+func newRelayManager() *relayManager {
+	return &relayManager{
+		relays: make(map[peer.ID]multiaddr.Multiaddr),
+	}
+}
+
+// start runs the relay service if enabled and watches for relays to use
+func (rm *relayManager) start(h *Host, service bool) error {
+	rm.h = h
+
+	if service {
+		// limits are for untrusted public relays, a lanchat relay carries
+		// whole conversations so connections are never cut short
+		relay, err := relayv2.New(h.Host, relayv2.WithInfiniteLimits())
+		if err != nil {
+			return err
+		}
+		rm.service = relay
+		h.Host.SetStreamHandler(ProtocolRelayPeers, rm.handleRelayPeers)
+		logger.Info("Relay service enabled")
+	}
+
+	sub, err := h.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-h.ctx.Done():
+				return
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				evt := e.(event.EvtPeerIdentificationCompleted)
+				if slices.Contains(evt.Protocols, proto.ProtoIDv2Hop) {
+					rm.useRelay(evt.Peer)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (rm *relayManager) close() {
+	if rm.service != nil {
+		rm.service.Close()
+	}
+}
+
+// addrs returns our circuit addresses through every relay we hold a slot on
+func (rm *relayManager) addrs() []multiaddr.Multiaddr {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	addrs := make([]multiaddr.Multiaddr, 0, len(rm.relays))
+	for _, addr := range rm.relays {
+		if addr != nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+func (rm *relayManager) useRelay(relayID peer.ID) {
+	rm.mu.Lock()
+	if _, exists := rm.relays[relayID]; exists || len(rm.relays) >= maxRelays {
+		rm.mu.Unlock()
+		return
+	}
+	rm.relays[relayID] = nil
+	rm.mu.Unlock()
+
+	go rm.keepReservation(relayID)
+}
+
+// keepReservation holds a slot on a relay for as long as we are connected
+// to it, refreshing it before it expires
+func (rm *relayManager) keepReservation(relayID peer.ID) {
+	defer func() {
+		rm.mu.Lock()
+		delete(rm.relays, relayID)
+		rm.mu.Unlock()
+	}()
+
+	for rm.h.IsConnected(relayID) {
+		relayAddr := rm.relayAddr(relayID)
+		if relayAddr == nil {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(rm.h.ctx, relayRequestTimeout)
+		rsvp, err := client.Reserve(ctx, rm.h.Host, peer.AddrInfo{ID: relayID, Addrs: []multiaddr.Multiaddr{relayAddr}})
+		cancel()
+
+		wait := relayRetryDelay
+		if err != nil {
+			logger.Debug("Failed to reserve relay slot on %s: %v", relayID.String()[:8], err)
+		} else {
+			circuit := circuitAddr(relayAddr, relayID)
+
+			rm.mu.Lock()
+			rm.relays[relayID] = circuit
+			rm.mu.Unlock()
+
+			logger.Info("Reserved relay slot on %s", relayID.String()[:8])
+
+			if until := time.Until(rsvp.Expiration) - relayRefreshMargin; until > wait {
+				wait = until
+			}
+			rm.findRelayedPeers(relayID, circuit, wait)
+			continue
+		}
+
+		select {
+		case <-rm.h.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// findRelayedPeers asks the relay who else uses it until the reservation
+// needs refreshing, reporting those peers through the discovery path
+func (rm *relayManager) findRelayedPeers(relayID peer.ID, circuit multiaddr.Multiaddr, until time.Duration) {
+	deadline := time.After(until)
+	ticker := time.NewTicker(relayPeersInterval)
+	defer ticker.Stop()
+
+	for {
+		peers, err := rm.requestRelayPeers(relayID)
+		if err != nil {
+			logger.Debug("Failed to get peers from relay %s: %v", relayID.String()[:8], err)
+		}
+
+		for _, id := range peers {
+			if id == rm.h.ID() || id == relayID {
+				continue
+			}
+			rm.h.peerFound(peer.AddrInfo{ID: id, Addrs: []multiaddr.Multiaddr{circuit}})
+		}
+
+		select {
+		case <-rm.h.ctx.Done():
+			return
+		case <-deadline:
+			return
+		case <-ticker.C:
+			if !rm.h.IsConnected(relayID) {
+				return
+			}
+		}
+	}
+}
+
+// relayAddr picks a direct address of the relay, preferring the one we
+// dialed it on
+func (rm *relayManager) relayAddr(relayID peer.ID) multiaddr.Multiaddr {
+	for _, conn := range rm.h.Network().ConnsToPeer(relayID) {
+		if conn.Stat().Direction == network.DirOutbound && !isCircuitAddr(conn.RemoteMultiaddr()) {
+			return conn.RemoteMultiaddr()
+		}
+	}
+
+	// inbound connections come from an ephemeral port, use what the relay
+	// told us it listens on instead
+	for _, addr := range rm.h.Peerstore().Addrs(relayID) {
+		if !isCircuitAddr(addr) {
+			return addr
+		}
+	}
+	return nil
+}
+
+func circuitAddr(relayAddr multiaddr.Multiaddr, relayID peer.ID) multiaddr.Multiaddr {
+	return relayAddr.
+		Encapsulate(multiaddr.StringCast("/p2p/" + relayID.String())).
+		Encapsulate(multiaddr.StringCast("/p2p-circuit"))
+}
+
+func isCircuitAddr(addr multiaddr.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT)
+	return err == nil
+}
+
+func (rm *relayManager) requestRelayPeers(relayID peer.ID) ([]peer.ID, error) {
+	ctx, cancel := context.WithTimeout(rm.h.ctx, relayRequestTimeout)
+	defer cancel()
+
+	stream, err := rm.h.NewStream(ctx, relayID, ProtocolRelayPeers)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	var ids []string
+	if err := json.NewDecoder(stream).Decode(&ids); err != nil {
+		return nil, err
+	}
+
+	peers := make([]peer.ID, 0, len(ids))
+	for _, s := range ids {
+		id, err := peer.Decode(s)
+		if err != nil {
+			continue
+		}
+		peers = append(peers, id)
+	}
+	return peers, nil
+}
+
+// handleRelayPeers lists the peers connected to this relay so that peers
+// in separate network segments can find each other
+func (rm *relayManager) handleRelayPeers(stream network.Stream) {
+	defer stream.Close()
+
+	connected := rm.h.Network().Peers()
+	ids := make([]string, 0, len(connected))
+	for _, id := range connected {
+		if id != stream.Conn().RemotePeer() {
+			ids = append(ids, id.String())
+		}
+	}
+
+	if err := json.NewEncoder(stream).Encode(ids); err != nil {
+		logger.Warn("Failed to send relay peers: %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package p2p
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestRelay(t *testing.T) {
+	relay := newTestHost(t, HostOptions{RelayService: true})
+	a := newTestHost(t, HostOptions{})
+	b := newTestHost(t, HostOptions{})
+	connectHosts(t, a, relay)
+	connectHosts(t, b, relay)
+
+	// a and b reserve slots and advertise the circuit through the relay
+	for _, h := range []*Host{a, b} {
+		if !waitFor(t, 5*time.Second, func() bool { return len(h.relays.addrs()) > 0 }) {
+			t.Fatal("no relay slot reserved")
+		}
+		if !slices.ContainsFunc(h.Addrs(), isCircuitAddr) {
+			t.Errorf("circuit address not advertised: %v", h.Addrs())
+		}
+	}
+
+	peers, err := a.relays.requestRelayPeers(relay.ID())
+	if err != nil {
+		t.Fatalf("failed to list relay peers: %v", err)
+	}
+	if !slices.Contains(peers, b.ID()) || slices.Contains(peers, a.ID()) {
+		t.Errorf("relay listed %v, want %s and not the caller", peers, b.ID())
+	}
+
+	// the relay's peer list feeds discovery with circuit addresses. Whoever
+	// reserved last finds the other straight away, the other only on its
+	// next poll.
+	deadline := time.After(5 * time.Second)
+	for a.Network().Connectedness(b.ID()) != network.Connected {
+		var (
+			from *Host
+			pi   peer.AddrInfo
+		)
+		select {
+		case pi = <-a.GetPeerChan():
+			from = a
+		case pi = <-b.GetPeerChan():
+			from = b
+		case <-deadline:
+			t.Fatal("timeout waiting for relayed peer")
+		}
+		if pi.ID != a.ID() && pi.ID != b.ID() {
+			continue
+		}
+		if len(pi.Addrs) != 1 || !isCircuitAddr(pi.Addrs[0]) {
+			t.Fatalf("peer found without a circuit address: %v", pi.Addrs)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := from.Connect(ctx, pi)
+		cancel()
+		if err != nil {
+			t.Fatalf("failed to connect through relay: %v", err)
+		}
+	}
+
+	conns := a.Network().ConnsToPeer(b.ID())
+	if len(conns) == 0 || !isCircuitAddr(conns[0].RemoteMultiaddr()) {
+		t.Errorf("not connected through the relay: %v", conns)
+	}
+}
+
+func TestNoRelayWithoutService(t *testing.T) {
+	a := newTestHost(t, HostOptions{})
+	b := newTestHost(t, HostOptions{})
+	connectHosts(t, a, b)
+
+	time.Sleep(500 * time.Millisecond)
+	if addrs := a.relays.addrs(); len(addrs) > 0 {
+		t.Errorf("reserved a slot on a peer without the relay service: %v", addrs)
+	}
+	if slices.ContainsFunc(a.Addrs(), isCircuitAddr) {
+		t.Errorf("advertised a circuit address: %v", a.Addrs())
+	}
+}
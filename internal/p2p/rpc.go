@@ -0,0 +1,281 @@
+package p2p
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/matt0792/lanchat/internal/logger"
+)
+
+const (
+	ProtocolRPC protocol.ID = "/lanchat/rpc/1.0.0"
+
+	DefaultRPCTimeout    = 10 * time.Second
+	DefaultRPCMaxPayload = 1 << 20
+)
+
+// RPC error codes, modelled on their HTTP counterparts
+const (
+	RPCCodeBadRequest    = 400
+	RPCCodeUnknownMethod = 404
+	RPCCodeTooLarge      = 413
+	RPCCodeInternal      = 500
+	RPCCodeTimeout       = 504
+)
+
+// RPCRequest is the envelope for one call, there is exactly one request and
+// one response per stream
+type RPCRequest struct {
+	ID      string          `json:"id"`
+	Method  string          `json:"method"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type RPCResponse struct {
+	ID     string          `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *RPCError       `json:"error,omitempty"`
+}
+
+// RPCError is returned to the caller when a call fails on the remote side.
+// Handlers can return one to choose the code, any other error is reported
+// as RPCCodeInternal.
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// RPCHandler serves one method. The returned value is encoded as the result.
+type RPCHandler func(ctx context.Context, from peer.ID, payload json.RawMessage) (any, error)
+
+// RPCOptions tunes a method when registering it, or a single call. Zero
+// values use the defaults.
+type RPCOptions struct {
+	// Timeout bounds the handler when registering, and the whole call when
+	// calling
+	Timeout time.Duration
+	// MaxPayload caps the request size when registering, and the response
+	// size when calling
+	MaxPayload int64
+}
+
+func (o *RPCOptions) timeout() time.Duration {
+	if o == nil || o.Timeout <= 0 {
+		return DefaultRPCTimeout
+	}
+	return o.Timeout
+}
+
+func (o *RPCOptions) maxPayload() int64 {
+	if o == nil || o.MaxPayload <= 0 {
+		return DefaultRPCMaxPayload
+	}
+	return o.MaxPayload
+}
+
+type rpcMethod struct {
+	handler RPCHandler
+	opts    *RPCOptions
+}
+
+// RegisterRPC serves method to peers, replacing any previous handler. A nil
+// opts uses the defaults.
+func (h *Host) RegisterRPC(method string, handler RPCHandler, opts *RPCOptions) {
+	h.rpcMu.Lock()
+	defer h.rpcMu.Unlock()
+	h.rpcMethods[method] = rpcMethod{handler: handler, opts: opts}
+}
+
+func (h *Host) UnregisterRPC(method string) {
+	h.rpcMu.Lock()
+	defer h.rpcMu.Unlock()
+	delete(h.rpcMethods, method)
+}
+
+// Call invokes method on a peer, encoding req as the payload and decoding
+// the result into resp, which may be nil. Remote failures are returned as
+// *RPCError.
+func (h *Host) Call(ctx context.Context, peerID peer.ID, method string, req, resp any, opts *RPCOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout())
+	defer cancel()
+
+	stream, err := h.NewStream(ctx, peerID, ProtocolRPC)
+	if err != nil {
+		return fmt.Errorf("failed to open stream: %w", err)
+	}
+	defer stream.Close()
+
+	return h.callOnStream(ctx, stream, method, req, resp, opts)
+}
+
+func (h *Host) callOnStream(ctx context.Context, stream network.Stream, method string, req, resp any, opts *RPCOptions) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	id, err := newRPCID()
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(stream).Encode(RPCRequest{ID: id, Method: method, Payload: payload}); err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	if err := stream.CloseWrite(); err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+
+	data, err := readLimited(stream, opts.maxPayload())
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var rpcResp RPCResponse
+	if err := json.Unmarshal(data, &rpcResp); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	// requests rejected before they could be parsed get an error without
+	// an id
+	if rpcResp.Error != nil && rpcResp.ID == "" {
+		return rpcResp.Error
+	}
+	if rpcResp.ID != id {
+		return fmt.Errorf("response id %q does not match request %q", rpcResp.ID, id)
+	}
+	if rpcResp.Error != nil {
+		return rpcResp.Error
+	}
+
+	if resp != nil && len(rpcResp.Result) > 0 {
+		if err := json.Unmarshal(rpcResp.Result, resp); err != nil {
+			return fmt.Errorf("failed to decode result: %w", err)
+		}
+	}
+	return nil
+}
+
+func (h *Host) handleRPCStream(stream network.Stream) {
+	defer stream.Close()
+	stream.SetReadDeadline(time.Now().Add(DefaultRPCTimeout))
+
+	from := stream.Conn().RemotePeer()
+
+	// the request is read before we know its method, so it is held to the
+	// largest limit of any registered method
+	data, err := readLimited(stream, h.maxRPCPayload())
+	if err != nil {
+		logger.Debug("Failed to read rpc request from %s: %v", from.String()[:8], err)
+		h.writeRPCResponse(stream, RPCResponse{Error: rpcErrorFrom(err)})
+		return
+	}
+
+	var req RPCRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		h.writeRPCResponse(stream, RPCResponse{Error: &RPCError{Code: RPCCodeBadRequest, Message: "malformed request"}})
+		return
+	}
+
+	h.rpcMu.RLock()
+	method, exists := h.rpcMethods[req.Method]
+	h.rpcMu.RUnlock()
+
+	if !exists {
+		h.writeRPCResponse(stream, RPCResponse{ID: req.ID, Error: &RPCError{Code: RPCCodeUnknownMethod, Message: "unknown method " + req.Method}})
+		return
+	}
+	if int64(len(data)) > method.opts.maxPayload() {
+		h.writeRPCResponse(stream, RPCResponse{ID: req.ID, Error: &RPCError{Code: RPCCodeTooLarge, Message: "request too large"}})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(h.ctx, method.opts.timeout())
+	defer cancel()
+
+	result, err := method.handler(ctx, from, req.Payload)
+	// the handler may have used up its whole timeout, the response still
+	// gets one of its own
+	stream.SetWriteDeadline(time.Now().Add(method.opts.timeout()))
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = &RPCError{Code: RPCCodeTimeout, Message: "handler timed out"}
+		}
+		h.writeRPCResponse(stream, RPCResponse{ID: req.ID, Error: rpcErrorFrom(err)})
+		return
+	}
+
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		logger.Warn("Failed to encode rpc result for %s: %v", req.Method, err)
+		h.writeRPCResponse(stream, RPCResponse{ID: req.ID, Error: &RPCError{Code: RPCCodeInternal, Message: "failed to encode result"}})
+		return
+	}
+
+	h.writeRPCResponse(stream, RPCResponse{ID: req.ID, Result: encoded})
+}
+
+func (h *Host) writeRPCResponse(stream network.Stream, resp RPCResponse) {
+	if err := json.NewEncoder(stream).Encode(resp); err != nil {
+		logger.Debug("Failed to send rpc response: %v", err)
+	}
+}
+
+func (h *Host) maxRPCPayload() int64 {
+	h.rpcMu.RLock()
+	defer h.rpcMu.RUnlock()
+
+	max := int64(DefaultRPCMaxPayload)
+	for _, m := range h.rpcMethods {
+		if size := m.opts.maxPayload(); size > max {
+			max = size
+		}
+	}
+	return max
+}
+
+var errPayloadTooLarge = &RPCError{Code: RPCCodeTooLarge, Message: "payload too large"}
+
+// readLimited reads the rest of the stream, failing once it exceeds max
+func readLimited(r io.Reader, max int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, errPayloadTooLarge
+	}
+	return data, nil
+}
+
+func rpcErrorFrom(err error) *RPCError {
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+	return &RPCError{Code: RPCCodeInternal, Message: err.Error()}
+}
+
+func newRPCID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate request id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
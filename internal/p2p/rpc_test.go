@@ -0,0 +1,119 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestRPC(t *testing.T) {
+	server := newTestHost(t, HostOptions{})
+	client := newTestHost(t, HostOptions{})
+	connectHosts(t, client, server)
+
+	server.RegisterRPC("echo", func(_ context.Context, _ peer.ID, payload json.RawMessage) (any, error) {
+		var text string
+		if err := json.Unmarshal(payload, &text); err != nil {
+			return nil, &RPCError{Code: RPCCodeBadRequest, Message: "want a string"}
+		}
+		return text, nil
+	}, &RPCOptions{MaxPayload: 1 << 10})
+	server.RegisterRPC("slow", func(ctx context.Context, _ peer.ID, _ json.RawMessage) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}, &RPCOptions{Timeout: 100 * time.Millisecond})
+	server.RegisterRPC("fail", func(context.Context, peer.ID, json.RawMessage) (any, error) {
+		return nil, errors.New("broken")
+	}, nil)
+
+	ctx := context.Background()
+
+	var reply string
+	if err := client.Call(ctx, server.ID(), "echo", "hi", &reply, nil); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if reply != "hi" {
+		t.Errorf("wrong reply: got %q, want %q", reply, "hi")
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		req    any
+		opts   *RPCOptions
+		code   int
+	}{
+		{name: "handler error code", method: "echo", req: 42, code: RPCCodeBadRequest},
+		{name: "unknown method", method: "missing", code: RPCCodeUnknownMethod},
+		{name: "over method limit", method: "echo", req: strings.Repeat("a", 2<<10), code: RPCCodeTooLarge},
+		{name: "over every limit", method: "echo", req: strings.Repeat("a", DefaultRPCMaxPayload+1), code: RPCCodeTooLarge},
+		{name: "handler timeout", method: "slow", code: RPCCodeTimeout},
+		{name: "internal error", method: "fail", code: RPCCodeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := client.Call(ctx, server.ID(), tt.method, tt.req, nil, tt.opts)
+			var rpcErr *RPCError
+			if !errors.As(err, &rpcErr) {
+				t.Fatalf("expected rpc error, got %v", err)
+			}
+			if rpcErr.Code != tt.code {
+				t.Errorf("wrong code: got %d (%s), want %d", rpcErr.Code, rpcErr.Message, tt.code)
+			}
+		})
+	}
+
+	t.Run("call deadline", func(t *testing.T) {
+		server.RegisterRPC("stuck", func(context.Context, peer.ID, json.RawMessage) (any, error) {
+			time.Sleep(time.Second)
+			return nil, nil
+		}, nil)
+
+		start := time.Now()
+		err := client.Call(ctx, server.ID(), "stuck", nil, nil, &RPCOptions{Timeout: 200 * time.Millisecond})
+		if err == nil {
+			t.Fatal("expected the call to time out")
+		}
+		if elapsed := time.Since(start); elapsed > 800*time.Millisecond {
+			t.Errorf("call took %v despite its deadline", elapsed)
+		}
+	})
+
+	t.Run("response limit", func(t *testing.T) {
+		err := client.Call(ctx, server.ID(), "echo", strings.Repeat("a", 512), nil, &RPCOptions{MaxPayload: 100})
+		if err == nil {
+			t.Fatal("expected an oversize response to fail")
+		}
+	})
+}
+
+func TestRPCMalformedRequest(t *testing.T) {
+	server := newTestHost(t, HostOptions{})
+	client := newTestHost(t, HostOptions{})
+	connectHosts(t, client, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stream, err := client.NewStream(ctx, server.ID(), ProtocolRPC)
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	defer stream.Close()
+
+	stream.Write([]byte("not json"))
+	stream.CloseWrite()
+
+	var resp RPCResponse
+	if err := json.NewDecoder(stream).Decode(&resp); err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if resp.Error == nil || resp.Error.Code != RPCCodeBadRequest {
+		t.Errorf("got %+v, want a bad request error", resp.Error)
+	}
+}
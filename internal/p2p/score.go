@@ -0,0 +1,193 @@
+package p2p
+
+import (
+	"errors"
+	"maps"
+	"math"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ErrRateLimited is returned (or wrapped) by a MessageValidator when the
+// sender is over its rate limit. The message is dropped without being
+// forwarded, and the sender's score takes the hit instead of the peer that
+// happened to relay it.
+var ErrRateLimited = errors.New("rate limited")
+
+// Score thresholds. Below GossipThreshold a peer gets no gossip from us,
+// below PublishThreshold we stop sending it our own messages and below
+// GraylistThreshold everything it sends is ignored.
+const (
+	GossipThreshold   = -10
+	PublishThreshold  = -50
+	GraylistThreshold = -80
+
+	// penalty per message over the rate limit, halved every penaltyHalfLife
+	rateLimitPenalty = 2
+	penaltyHalfLife  = time.Minute
+
+	scoreInspectInterval = 5 * time.Second
+)
+
+// PeerScore is a peer's gossipsub score and what it is made of
+type PeerScore struct {
+	Score float64
+	// AppSpecific is our own penalty for rate limit violations
+	AppSpecific      float64
+	BehaviourPenalty float64
+	// InvalidMessages and FirstDeliveries are summed over all topics
+	InvalidMessages float64
+	FirstDeliveries float64
+}
+
+// Graylisted reports whether we are ignoring the peer
+func (s PeerScore) Graylisted() bool {
+	return s.Score < GraylistThreshold
+}
+
+// peerScorer tracks our rate limit penalties and the latest scores
+// reported by gossipsub
+type peerScorer struct {
+	mu        sync.Mutex
+	penalties map[peer.ID]float64
+	decayed   time.Time
+
+	scoresMu sync.RWMutex
+	scores   map[peer.ID]PeerScore
+}
+
+func newPeerScorer() *peerScorer {
+	return &peerScorer{
+		penalties: make(map[peer.ID]float64),
+		decayed:   time.Now(),
+		scores:    make(map[peer.ID]PeerScore),
+	}
+}
+
+func (s *peerScorer) options() []pubsub.Option {
+	params := &pubsub.PeerScoreParams{
+		// parameters left at zero are disabled
+		SkipAtomicValidation: true,
+
+		Topics:            make(map[string]*pubsub.TopicScoreParams),
+		AppSpecificScore:  s.appScore,
+		AppSpecificWeight: 1,
+
+		// peers on a LAN often share an address behind NAT or in tests, so
+		// colocation isn't held against them
+		IPColocationFactorWeight: 0,
+
+		BehaviourPenaltyWeight:    -10,
+		BehaviourPenaltyThreshold: 6,
+		BehaviourPenaltyDecay:     pubsub.ScoreParameterDecay(10 * time.Minute),
+
+		DecayInterval: time.Second,
+		DecayToZero:   0.01,
+		RetainScore:   10 * time.Minute,
+	}
+
+	thresholds := &pubsub.PeerScoreThresholds{
+		SkipAtomicValidation:        true,
+		GossipThreshold:             GossipThreshold,
+		PublishThreshold:            PublishThreshold,
+		GraylistThreshold:           GraylistThreshold,
+		AcceptPXThreshold:           10,
+		OpportunisticGraftThreshold: 5,
+	}
+
+	return []pubsub.Option{
+		pubsub.WithPeerScore(params, thresholds),
+		pubsub.WithPeerScoreInspect(s.inspect, scoreInspectInterval),
+	}
+}
+
+// topicScoreParams are applied to every room topic. Rooms are bursty, so
+// peers aren't expected to deliver a steady rate of messages; they earn
+// score by being first to deliver messages and lose it quickly for
+// invalid ones.
+func topicScoreParams() *pubsub.TopicScoreParams {
+	return &pubsub.TopicScoreParams{
+		SkipAtomicValidation: true,
+
+		TopicWeight: 1,
+
+		TimeInMeshWeight:  0.01,
+		TimeInMeshQuantum: time.Second,
+		TimeInMeshCap:     3600,
+
+		FirstMessageDeliveriesWeight: 1,
+		FirstMessageDeliveriesDecay:  pubsub.ScoreParameterDecay(10 * time.Minute),
+		FirstMessageDeliveriesCap:    50,
+
+		// squared, so three invalid messages are enough to graylist a peer
+		InvalidMessageDeliveriesWeight: -10,
+		InvalidMessageDeliveriesDecay:  pubsub.ScoreParameterDecay(time.Hour),
+	}
+}
+
+// penalize records a rate limit violation by a peer
+func (s *peerScorer) penalize(p peer.ID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.decay()
+	s.penalties[p] += rateLimitPenalty
+}
+
+func (s *peerScorer) appScore(p peer.ID) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.decay()
+	return -s.penalties[p]
+}
+
+// decay halves penalties every penaltyHalfLife, called with mu held
+func (s *peerScorer) decay() {
+	elapsed := time.Since(s.decayed)
+	if elapsed < time.Second {
+		return
+	}
+	s.decayed = time.Now()
+
+	factor := math.Pow(0.5, elapsed.Seconds()/penaltyHalfLife.Seconds())
+	for p, penalty := range s.penalties {
+		penalty *= factor
+		if penalty < 0.1 {
+			delete(s.penalties, p)
+			continue
+		}
+		s.penalties[p] = penalty
+	}
+}
+
+func (s *peerScorer) inspect(snapshots map[peer.ID]*pubsub.PeerScoreSnapshot) {
+	scores := make(map[peer.ID]PeerScore, len(snapshots))
+	for p, snap := range snapshots {
+		score := PeerScore{
+			Score:            snap.Score,
+			AppSpecific:      snap.AppSpecificScore,
+			BehaviourPenalty: snap.BehaviourPenalty,
+		}
+		for _, topic := range snap.Topics {
+			score.InvalidMessages += topic.InvalidMessageDeliveries
+			score.FirstDeliveries += topic.FirstMessageDeliveries
+		}
+		scores[p] = score
+	}
+
+	s.scoresMu.Lock()
+	s.scores = scores
+	s.scoresMu.Unlock()
+}
+
+// PeerScores returns the latest gossipsub score of every peer we share a
+// topic with, refreshed every few seconds
+func (h *Host) PeerScores() map[peer.ID]PeerScore {
+	h.scorer.scoresMu.RLock()
+	defer h.scorer.scoresMu.RUnlock()
+	return maps.Clone(h.scorer.scores)
+}
@@ -0,0 +1,123 @@
+package p2p
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPenaltyDecay(t *testing.T) {
+	s := newPeerScorer()
+	p := testPeerID(t)
+	other := testPeerID(t)
+
+	for range 3 {
+		s.penalize(p)
+	}
+	if score := s.appScore(p); score != -3*rateLimitPenalty {
+		t.Errorf("got score %v after three violations, want %v", score, -3*rateLimitPenalty)
+	}
+	if score := s.appScore(other); score != 0 {
+		t.Errorf("got score %v for a peer without violations", score)
+	}
+
+	s.mu.Lock()
+	s.decayed = time.Now().Add(-penaltyHalfLife)
+	s.mu.Unlock()
+	if score := s.appScore(p); score < -3.01 || score > -2.99 {
+		t.Errorf("got score %v after one half-life, want -3", score)
+	}
+
+	s.mu.Lock()
+	s.decayed = time.Now().Add(-10 * penaltyHalfLife)
+	s.mu.Unlock()
+	if score := s.appScore(p); score != 0 {
+		t.Errorf("got score %v after ten half-lives, want 0", score)
+	}
+	s.mu.Lock()
+	remaining := len(s.penalties)
+	s.mu.Unlock()
+	if remaining != 0 {
+		t.Errorf("%d penalties left after decaying to nothing", remaining)
+	}
+}
+
+func TestGraylisting(t *testing.T) {
+	const msgType MessageType = "test"
+
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{name: "invalid messages", err: errors.New("bad schema")},
+		{name: "rate limited", err: ErrRateLimited},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := newTestHost(t, HostOptions{})
+			receiver := newTestHost(t, HostOptions{})
+
+			sender.RegisterMessageHandler(msgType, func(*Message) error { return nil })
+			receiver.RegisterMessageValidator(msgType, func(msg *Message) error {
+				if string(msg.Data) == `{"bad":true}` {
+					return tt.err
+				}
+				return nil
+			})
+
+			connectHosts(t, sender, receiver)
+
+			sendTopic, err := sender.JoinTopic("scores")
+			if err != nil {
+				t.Fatal(err)
+			}
+			recvTopic, err := receiver.JoinTopic("scores")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			messages := recvTopic.ReadMessages(ctx)
+
+			if !waitFor(t, 5*time.Second, func() bool {
+				return slices.Contains(sendTopic.ListPeers(), receiver.ID()) &&
+					slices.Contains(recvTopic.ListPeers(), sender.ID())
+			}) {
+				t.Fatal("hosts never saw each other in the topic")
+			}
+
+			if err := sendTopic.Publish(msgType, map[string]bool{"bad": false}); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case <-messages:
+			case <-time.After(5 * time.Second):
+				t.Fatal("valid message not delivered")
+			}
+
+			// keep flooding until the receiver stops listening, scores are
+			// only refreshed every scoreInspectInterval
+			graylisted := waitFor(t, 3*scoreInspectInterval, func() bool {
+				if err := sendTopic.Publish(msgType, map[string]bool{"bad": true}); err != nil {
+					t.Fatal(err)
+				}
+				return receiver.PeerScores()[sender.ID()].Graylisted()
+			})
+			if !graylisted {
+				t.Fatalf("sender not graylisted, score %+v", receiver.PeerScores()[sender.ID()])
+			}
+
+			if err := sendTopic.Publish(msgType, map[string]bool{"bad": false}); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case msg := <-messages:
+				t.Errorf("message from a graylisted peer delivered: %s", msg.Data)
+			case <-time.After(time.Second):
+			}
+		})
+	}
+}
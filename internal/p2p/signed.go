@@ -0,0 +1,106 @@
+package p2p
+
+import (
+	"fmt"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// maxSignedOverhead is room for the origin, sequence number, topic, key and
+// signature around an envelope
+const maxSignedOverhead = 1 << 10
+
+// signedRecord encodes a received pubsub message so it can be passed on
+// with its signature intact
+func signedRecord(msg *pubsub.Message) []byte {
+	record, err := msg.Message.Marshal()
+	if err != nil {
+		return nil
+	}
+	return record
+}
+
+// OpenSignedMessage checks a pubsub record that was relayed to us outside
+// of pubsub, e.g. as room history, and returns the envelope it carries. The
+// origin's signature and the topic are verified, so peers passing it on
+// can't alter or misattribute it. Type specific validators are not run.
+func (h *Host) OpenSignedMessage(signed []byte, topic string) (*Message, error) {
+	parsed, err := openSignedMessage(signed, topic)
+	if err != nil {
+		return nil, err
+	}
+	parsed.Local = parsed.From == h.ID().String()
+	return parsed, nil
+}
+
+func openSignedMessage(signed []byte, topic string) (*Message, error) {
+	if len(signed) > MaxMessageSize+maxSignedOverhead {
+		return nil, fmt.Errorf("record too large (%d bytes)", len(signed))
+	}
+
+	var record pb.Message
+	if err := record.Unmarshal(signed); err != nil {
+		return nil, fmt.Errorf("malformed record: %w", err)
+	}
+	if record.GetTopic() != topic {
+		return nil, fmt.Errorf("record is for another topic")
+	}
+
+	origin, err := peer.IDFromBytes(record.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid origin: %w", err)
+	}
+	if err := verifyRecord(&record, origin); err != nil {
+		return nil, err
+	}
+
+	parsed, err := parseEnvelope(origin, record.Data)
+	if err != nil {
+		return nil, err
+	}
+	parsed.Topic = topic
+	parsed.PubsubID = pubsub.DefaultMsgIdFn(&record)
+	parsed.Signed = signed
+	return parsed, nil
+}
+
+// verifyRecord checks a record's signature the way pubsub does on receipt
+func verifyRecord(record *pb.Message, origin peer.ID) error {
+	if len(record.Signature) == 0 {
+		return fmt.Errorf("record is not signed")
+	}
+
+	var pubKey crypto.PubKey
+	var err error
+	if record.Key != nil {
+		pubKey, err = crypto.UnmarshalPublicKey(record.Key)
+		if err == nil && !origin.MatchesPublicKey(pubKey) {
+			err = fmt.Errorf("key does not match origin")
+		}
+	} else {
+		pubKey, err = origin.ExtractPublicKey()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get signing key: %w", err)
+	}
+
+	unsigned := pb.Message{
+		From:  record.From,
+		Data:  record.Data,
+		Seqno: record.Seqno,
+		Topic: record.Topic,
+	}
+	data, err := unsigned.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to encode record: %w", err)
+	}
+
+	ok, err := pubKey.Verify(append([]byte(pubsub.SignPrefix), data...), record.Signature)
+	if err != nil || !ok {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
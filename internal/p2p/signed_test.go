@@ -0,0 +1,79 @@
+package p2p
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestOpenSignedMessage(t *testing.T) {
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	origin, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topic := "lanchat/test/rooms/general"
+	data, _ := json.Marshal(Message{
+		Type:      MessageTypeChat,
+		From:      origin.String(),
+		Timestamp: time.Now(),
+		Data:      json.RawMessage(`{"type":"text","text":"hi"}`),
+	})
+
+	// signed the way pubsub signs outgoing messages
+	sign := func(record *pb.Message) []byte {
+		unsigned, err := record.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		record.Signature, err = priv.Sign(append([]byte(pubsub.SignPrefix), unsigned...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed, err := record.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	signed := sign(&pb.Message{From: []byte(origin), Data: data, Seqno: []byte{1}, Topic: &topic})
+
+	msg, err := openSignedMessage(signed, topic)
+	if err != nil {
+		t.Fatalf("valid record rejected: %v", err)
+	}
+	if msg.From != origin.String() || msg.PubsubID != string(origin)+"\x01" {
+		t.Errorf("wrong message: from %s, id %q", msg.From, msg.PubsubID)
+	}
+
+	if _, err := openSignedMessage(signed, "lanchat/test/rooms/other"); err == nil {
+		t.Error("record accepted on another topic")
+	}
+
+	var tampered pb.Message
+	if err := tampered.Unmarshal(signed); err != nil {
+		t.Fatal(err)
+	}
+	tampered.Data = []byte(string(data[:len(data)-3]) + `!"}`)
+	raw, _ := tampered.Marshal()
+	if _, err := openSignedMessage(raw, topic); err == nil {
+		t.Error("tampered record accepted")
+	}
+
+	tampered.Data = data
+	tampered.Signature = nil
+	raw, _ = tampered.Marshal()
+	if _, err := openSignedMessage(raw, topic); err == nil {
+		t.Error("unsigned record accepted")
+	}
+}
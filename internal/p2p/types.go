@@ -6,11 +6,12 @@ import (
 
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
-	"github.com/matt0792/lanchat/internal/logger"
 )
 
 const (
-	ProtocolMetadata protocol.ID = "/chat/metadata/1.0.0"
+	ProtocolMetadata      protocol.ID = "/chat/metadata/1.0.0"
+	ProtocolDirectMessage protocol.ID = "/lanchat/dm/1.0.0"
+	ProtocolFileTransfer  protocol.ID = "/lanchat/file/1.0.0"
 )
 
 type MessageType string
@@ -27,6 +28,15 @@ type Message struct {
 	From      string          `json:"from"`
 	Timestamp time.Time       `json:"timestamp"`
 	Data      json.RawMessage `json:"data"`
+
+	// set locally: the topic the message arrived on, its pubsub message ID
+	// and the pubsub record as the origin signed it, which other peers can
+	// check with OpenSignedMessage
+	Topic    string `json:"-"`
+	PubsubID string `json:"-"`
+	Signed   []byte `json:"-"`
+	// Local is set for messages we published ourselves
+	Local bool `json:"-"`
 }
 
 // MessageHandler is a callback for handling messages
@@ -34,41 +44,26 @@ type MessageHandler func(msg *Message) error
 
 // MetadataRequest for direct peer communication
 type MetadataRequest struct {
-	Type string `json:"type"`
+	Type   string `json:"type"`
+	Domain string `json:"domain,omitempty"`
 }
 
 type MetadataResponse struct {
-	Nickname    string            `json:"nickname,omitempty"`
-	Version     string            `json:"version,omitempty"`
-	CurrentRoom string            `json:"current_room,omitempty"`
-	Custom      map[string]string `json:"custom,omitempty"`
-}
-
-type discoveryNotifee struct {
-	h *Host
+	Domain      string `json:"domain,omitempty"`
+	Nickname    string `json:"nickname,omitempty"`
+	Version     string `json:"version,omitempty"`
+	CurrentRoom string `json:"current_room,omitempty"`
+	// Rooms lists every unencrypted room the peer is in
+	Rooms  []string          `json:"rooms,omitempty"`
+	Custom map[string]string `json:"custom,omitempty"`
+	// Seq increases with every change so stale copies can be told apart
+	Seq uint64 `json:"seq,omitempty"`
 }
 
-func (n *discoveryNotifee) HandlePeerFound(pi peer.AddrInfo) {
-	if pi.ID == n.h.ID() {
-		return
-	}
-
-	n.h.mu.Lock()
-	_, exists := n.h.peers[pi.ID]
-	n.h.mu.Unlock()
-
-	if !exists {
-		logger.Debug("mDNS discovered peer: %s", pi.ID.String()[:8])
-		if err := n.h.Connect(n.h.ctx, pi); err != nil {
-			logger.Warn("Failed to connect to mDNS peer %s: %v", pi.ID.String()[:8], err)
-		} else {
-			logger.Info("Connected to mDNS peer: %s", pi.ID.String()[:8])
-			n.h.mu.Lock()
-			n.h.peers[pi.ID] = pi
-			n.h.mu.Unlock()
-			n.h.peerChan <- pi
-		}
-	}
+// PeerMetadata is metadata pushed to us by a peer
+type PeerMetadata struct {
+	PeerID   peer.ID
+	Metadata MetadataResponse
 }
 
 type PeerEvent struct {
@@ -0,0 +1,110 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/matt0792/lanchat/internal/logger"
+)
+
+// MaxMessageSize bounds a whole pubsub envelope
+const MaxMessageSize = 64 << 10
+
+// ErrUnknownType is returned (or wrapped) by a MessageValidator for payload
+// types it doesn't know, e.g. ones added by newer versions. The message is
+// dropped without being forwarded, but doesn't count against anyone.
+var ErrUnknownType = errors.New("unknown message type")
+
+// MessageValidator checks the payload of one message type before it is
+// delivered or forwarded. Returning an error rejects the message.
+type MessageValidator func(msg *Message) error
+
+// RegisterMessageValidator adds schema checks for a message type, they run
+// on every topic after the envelope itself has been validated
+func (h *Host) RegisterMessageValidator(msgType MessageType, validator MessageValidator) {
+	h.msgHandlersMu.Lock()
+	defer h.msgHandlersMu.Unlock()
+	h.msgValidators[msgType] = validator
+}
+
+// validateMessage runs for every message on our topics, including our own,
+// before pubsub delivers or forwards it. Rejected messages count against
+// the peer that sent them to us, rate limited ones against their origin.
+func (h *Host) validateMessage(_ context.Context, from peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+	parsed, err := h.checkMessage(msg)
+	if errors.Is(err, ErrRateLimited) {
+		logger.Debug("Dropped message from %s: %v", msg.GetFrom().String()[:8], err)
+		h.scorer.penalize(msg.GetFrom())
+		return pubsub.ValidationIgnore
+	}
+	if errors.Is(err, ErrUnknownType) {
+		logger.Debug("Ignored message from %s: %v", msg.GetFrom().String()[:8], err)
+		return pubsub.ValidationIgnore
+	}
+	if err != nil {
+		logger.Warn("Rejected message from %s (via %s): %v", msg.GetFrom().String()[:8], from.String()[:8], err)
+		return pubsub.ValidationReject
+	}
+	if parsed == nil {
+		return pubsub.ValidationIgnore
+	}
+
+	msg.ValidatorData = parsed
+	return pubsub.ValidationAccept
+}
+
+// checkMessage validates the envelope and returns it parsed, or nil for
+// message types we don't know and shouldn't pass on
+func (h *Host) checkMessage(msg *pubsub.Message) (*Message, error) {
+	parsed, err := parseEnvelope(msg.GetFrom(), msg.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	h.msgHandlersMu.RLock()
+	validator, hasValidator := h.msgValidators[parsed.Type]
+	_, hasHandler := h.msgHandlers[parsed.Type]
+	h.msgHandlersMu.RUnlock()
+
+	if !hasValidator && !hasHandler {
+		return nil, nil
+	}
+
+	if hasValidator {
+		if err := validator(parsed); err != nil {
+			return nil, err
+		}
+	}
+
+	return parsed, nil
+}
+
+// parseEnvelope checks the parts of an envelope that don't depend on its
+// type, origin is the signed pubsub sender
+func parseEnvelope(origin peer.ID, data []byte) (*Message, error) {
+	if len(data) > MaxMessageSize {
+		return nil, fmt.Errorf("message too large (%d bytes)", len(data))
+	}
+
+	var parsed Message
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("malformed envelope: %w", err)
+	}
+
+	// From is self-declared, the pubsub origin is signed
+	if parsed.From != origin.String() {
+		return nil, fmt.Errorf("sender %q does not match origin", parsed.From)
+	}
+	if parsed.Timestamp.IsZero() {
+		return nil, fmt.Errorf("missing timestamp")
+	}
+	if len(parsed.Data) == 0 || parsed.Data[0] != '{' {
+		return nil, fmt.Errorf("payload is not an object")
+	}
+
+	return &parsed, nil
+}
@@ -0,0 +1,91 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func testPeerID(t *testing.T) peer.ID {
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestCheckMessage(t *testing.T) {
+	h := &Host{
+		msgHandlers:   map[MessageType]MessageHandler{MessageTypeChat: func(*Message) error { return nil }},
+		msgValidators: make(map[MessageType]MessageValidator),
+	}
+
+	origin := testPeerID(t)
+	other := testPeerID(t)
+
+	envelope := func(from peer.ID, msgType MessageType, data string) *pubsub.Message {
+		raw, _ := json.Marshal(Message{
+			Type:      msgType,
+			From:      from.String(),
+			Timestamp: time.Now(),
+			Data:      json.RawMessage(data),
+		})
+		return &pubsub.Message{Message: &pb.Message{From: []byte(origin), Data: raw}}
+	}
+
+	if parsed, err := h.checkMessage(envelope(origin, MessageTypeChat, `{"type":"text"}`)); err != nil || parsed == nil {
+		t.Errorf("valid message rejected: %v", err)
+	}
+
+	if _, err := h.checkMessage(envelope(other, MessageTypeChat, `{"type":"text"}`)); err == nil {
+		t.Error("spoofed sender accepted")
+	}
+
+	if _, err := h.checkMessage(envelope(origin, MessageTypeChat, `"text"`)); err == nil {
+		t.Error("non-object payload accepted")
+	}
+
+	big := `{"text":"` + strings.Repeat("a", MaxMessageSize) + `"}`
+	if _, err := h.checkMessage(envelope(origin, MessageTypeChat, big)); err == nil {
+		t.Error("oversized message accepted")
+	}
+
+	junk := &pubsub.Message{Message: &pb.Message{From: []byte(origin), Data: []byte("junk")}}
+	if _, err := h.checkMessage(junk); err == nil {
+		t.Error("malformed envelope accepted")
+	}
+
+	if parsed, err := h.checkMessage(envelope(origin, "unknown", `{}`)); err != nil || parsed != nil {
+		t.Errorf("unknown type should be ignored, got %v, %v", parsed, err)
+	}
+
+	h.RegisterMessageValidator(MessageTypeChat, func(msg *Message) error {
+		return errors.New("bad schema")
+	})
+	if _, err := h.checkMessage(envelope(origin, MessageTypeChat, `{"type":"text"}`)); err == nil {
+		t.Error("message failing the type validator accepted")
+	}
+	if result := h.validateMessage(context.Background(), origin, envelope(origin, MessageTypeChat, `{"type":"text"}`)); result != pubsub.ValidationReject {
+		t.Errorf("message failing the type validator got %v, want reject", result)
+	}
+
+	h.RegisterMessageValidator(MessageTypeChat, func(msg *Message) error {
+		return fmt.Errorf("chat message type %q: %w", "poll", ErrUnknownType)
+	})
+	if result := h.validateMessage(context.Background(), origin, envelope(origin, MessageTypeChat, `{"type":"poll"}`)); result != pubsub.ValidationIgnore {
+		t.Errorf("unknown payload type got %v, want ignore", result)
+	}
+}
@@ -6,21 +6,32 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/matt0792/lanchat/internal/ui"
 )
 
 const (
-	colorReset = "\033[0m"
-	colorGray  = "\033[90m"
+	colorReset   = "\033[0m"
+	colorGray    = "\033[90m"
+	colorMagenta = "\033[35m"
 )
 
 type CLI struct {
-	ctx        context.Context
-	cancel     context.CancelFunc
-	reader     *bufio.Reader
-	cmdHandler ui.CommandHandler
+	ctx           context.Context
+	cancel        context.CancelFunc
+	reader        *bufio.Reader
+	cmdHandler    ui.CommandHandler
+	typingHandler func()
+
+	roomMu sync.RWMutex
+	room   string
+	typing string
+
+	// line is the input so far, when the terminal passes on single keys
+	lineMu sync.Mutex
+	line   []rune
 }
 
 func New(ctx context.Context) *CLI {
@@ -36,9 +47,23 @@ func clearLine() {
 	fmt.Print("\r\033[K")
 }
 
-func (c *CLI) ShowMessage(nickname, identity, message string) {
+func (c *CLI) ShowMessage(nickname, identity, message, quote string, timestamp time.Time) {
 	clearLine()
-	fmt.Printf("\n%s %s%s\t%s%s\n", nickname, colorGray, identity, time.Now().Format("15:04"), colorReset)
+	fmt.Printf("\n%s %s%s\t%s%s\n", nickname, colorGray, identity, timestamp.Format("15:04"), colorReset)
+	if quote != "" {
+		fmt.Printf("%s> %s%s\n", colorGray, quote, colorReset)
+	}
+	fmt.Printf("%s\n", message)
+	c.ShowPrompt()
+}
+
+func (c *CLI) ShowDirectMessage(nickname, identity, message string, outgoing bool) {
+	clearLine()
+	direction := "from"
+	if outgoing {
+		direction = "to"
+	}
+	fmt.Printf("\n%s[direct %s] %s %s%s\t%s%s\n", colorMagenta, direction, nickname, colorGray, identity, time.Now().Format("15:04"), colorReset)
 	fmt.Printf("%s\n", message)
 	c.ShowPrompt()
 }
@@ -94,22 +119,66 @@ func (c *CLI) ShowError(err error) {
 }
 
 func (c *CLI) ShowPrompt() {
+	c.roomMu.RLock()
+	room, typing := c.room, c.typing
+	c.roomMu.RUnlock()
+
+	c.lineMu.Lock()
+	defer c.lineMu.Unlock()
+
 	clearLine()
-	fmt.Print("> ")
+	if typing != "" {
+		fmt.Printf("%s%s%s ", colorGray, typing, colorReset)
+	}
+	fmt.Printf("%s> %s", room, string(c.line))
+}
+
+func (c *CLI) ShowTyping(status string) {
+	c.roomMu.Lock()
+	changed := c.typing != status
+	c.typing = status
+	c.roomMu.Unlock()
+
+	if changed {
+		c.ShowPrompt()
+	}
+}
+
+func (c *CLI) SetActiveRoom(room string) {
+	c.roomMu.Lock()
+	c.room = room
+	c.roomMu.Unlock()
 }
 
 func (c *CLI) OnCommand(handler ui.CommandHandler) {
 	c.cmdHandler = handler
 }
 
+func (c *CLI) OnTyping(handler func()) {
+	c.typingHandler = handler
+}
+
 func (c *CLI) Start() error {
+	readLine := func() (string, error) {
+		return c.reader.ReadString('\n')
+	}
+	if restore, err := enableKeyInput(int(os.Stdin.Fd())); err == nil {
+		restore = sync.OnceFunc(restore)
+		defer restore()
+		go func() {
+			<-c.ctx.Done()
+			restore()
+		}()
+		readLine = c.readKeys
+	}
+
 	for {
 		select {
 		case <-c.ctx.Done():
 			return nil
 		default:
 			c.ShowPrompt()
-			input, err := c.reader.ReadString('\n')
+			input, err := readLine()
 			if err != nil {
 				return err
 			}
@@ -146,7 +215,7 @@ func (c *CLI) parseInput(input string) ui.Command {
 	}
 
 	return ui.Command{
-		Type: "send",
+		Type: "message",
 		Args: []string{input},
 	}
 }
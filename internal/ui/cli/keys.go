@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// control keys handled while reading keys
+const (
+	keyCtrlD     = 0x04
+	keyBackspace = 0x08
+	keyCtrlU     = 0x15
+	keyCtrlW     = 0x17
+	keyEscape    = 0x1b
+	keyDelete    = 0x7f
+)
+
+// readKeys reads a line key by key, doing the editing the terminal does for
+// whole lines: backspace, Ctrl-U to clear the line, Ctrl-W to drop a word
+// and Ctrl-D to quit on an empty line. The typing handler is called for key
+// presses that add to a message, commands don't count.
+func (c *CLI) readKeys() (string, error) {
+	for {
+		r, _, err := c.reader.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		c.lineMu.Lock()
+		switch r {
+		case '\r', '\n':
+			line := string(c.line)
+			c.line = nil
+			c.lineMu.Unlock()
+			fmt.Print("\n")
+			return line, nil
+
+		case keyCtrlD:
+			empty := len(c.line) == 0
+			c.lineMu.Unlock()
+			if empty {
+				fmt.Print("\n")
+				return "", io.EOF
+			}
+			continue
+
+		case keyBackspace, keyDelete:
+			if len(c.line) > 0 {
+				c.line = c.line[:len(c.line)-1]
+			}
+
+		case keyCtrlU:
+			c.line = nil
+
+		case keyCtrlW:
+			line := strings.TrimRightFunc(string(c.line), unicode.IsSpace)
+			line = line[:strings.LastIndexFunc(line, unicode.IsSpace)+1]
+			c.line = []rune(line)
+
+		case keyEscape:
+			// arrow keys and the like are not supported
+			c.lineMu.Unlock()
+			if err := c.skipEscapeSequence(); err != nil {
+				return "", err
+			}
+			continue
+
+		default:
+			if !unicode.IsPrint(r) {
+				c.lineMu.Unlock()
+				continue
+			}
+			c.line = append(c.line, r)
+			fmt.Print(string(r))
+			composing := c.line[0] != '/'
+			c.lineMu.Unlock()
+
+			if composing && c.typingHandler != nil {
+				c.typingHandler()
+			}
+			continue
+		}
+		c.lineMu.Unlock()
+		c.ShowPrompt()
+	}
+}
+
+// skipEscapeSequence reads the rest of a CSI or SS3 sequence, as sent for
+// arrow and function keys
+func (c *CLI) skipEscapeSequence() error {
+	r, _, err := c.reader.ReadRune()
+	if err != nil || r != '[' && r != 'O' {
+		return err
+	}
+	for {
+		r, _, err := c.reader.ReadRune()
+		if err != nil {
+			return err
+		}
+		if r >= 0x40 && r <= 0x7e {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package cli
+
+import "errors"
+
+// enableKeyInput is only supported on unix terminals, elsewhere input is
+// read a line at a time
+func enableKeyInput(fd int) (restore func(), err error) {
+	return nil, errors.New("key input not supported")
+}
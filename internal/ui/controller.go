@@ -3,15 +3,27 @@ package ui
 import (
 	"context"
 	"fmt"
+	"maps"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/matt0792/lanchat/internal/app"
 )
 
+// quoteLength is how much of a message is quoted above replies to it
+const quoteLength = 40
+
 type Controller struct {
 	app *app.App
 	ui  UI
 	ctx context.Context
+
+	// typing holds who is composing a message, by room and peer
+	typingMu sync.Mutex
+	typing   map[string]*app.Typing
 }
 
 func NewController(ctx context.Context, app *app.App, ui UI) *Controller {
@@ -22,6 +34,7 @@ func NewController(ctx context.Context, app *app.App, ui UI) *Controller {
 	}
 
 	ui.OnCommand(c.handleCommand)
+	ui.OnTyping(c.handleTyping)
 
 	go c.handleAppEvents()
 
@@ -29,6 +42,11 @@ func NewController(ctx context.Context, app *app.App, ui UI) *Controller {
 }
 
 func (c *Controller) handleCommand(cmd Command) error {
+	// setting a status by hand replaces automatic away anyway
+	if cmd.Type != "status" {
+		c.app.MarkActive()
+	}
+
 	switch cmd.Type {
 	case "join":
 		if len(cmd.Args) < 1 {
@@ -42,13 +60,183 @@ func (c *Controller) handleCommand(cmd Command) error {
 		if err := c.app.JoinRoom(roomName, password); err != nil {
 			return err
 		}
+		c.refreshActiveRoom()
 		c.ui.ShowSystemMessage(fmt.Sprintf("Joined room: %s", cmd.Args[0]))
 
 	case "leave":
-		if err := c.app.LeaveRoom(); err != nil {
+		var err error
+		if len(cmd.Args) > 0 {
+			err = c.app.LeaveRoomByName(cmd.Args[0])
+		} else {
+			err = c.app.LeaveRoom()
+		}
+		if err != nil {
+			return err
+		}
+		room := c.refreshActiveRoom()
+		if room != "" {
+			c.ui.ShowSystemMessage(fmt.Sprintf("Left room, now in %s", room))
+		} else {
+			c.ui.ShowSystemMessage("Left room")
+		}
+
+	case "switch":
+		if len(cmd.Args) < 1 {
+			return fmt.Errorf("usage: /switch <room>")
+		}
+		missed, err := c.app.SwitchRoom(cmd.Args[0])
+		if err != nil {
+			return err
+		}
+		room := c.refreshActiveRoom()
+		c.ui.ShowSystemMessage(fmt.Sprintf("Switched to %s (%d unread)", room, len(missed)))
+		for _, msg := range missed {
+			c.showChatMessage(msg)
+		}
+
+	case "history":
+		n := 20
+		if len(cmd.Args) > 0 {
+			var err error
+			if n, err = strconv.Atoi(cmd.Args[0]); err != nil || n <= 0 {
+				return fmt.Errorf("usage: /history [n]")
+			}
+		}
+		messages, err := c.app.GetHistory("", n)
+		if err != nil {
+			return err
+		}
+		c.showStoredMessages(fmt.Sprintf("Last %d messages:", len(messages)), messages)
+
+	case "search":
+		if len(cmd.Args) < 1 {
+			return fmt.Errorf("usage: /search <terms>")
+		}
+		messages, err := c.app.SearchHistory("", strings.Join(cmd.Args, " "))
+		if err != nil {
+			return err
+		}
+		c.showStoredMessages(fmt.Sprintf("%d matching messages:", len(messages)), messages)
+
+	case "react":
+		if len(cmd.Args) < 2 {
+			return fmt.Errorf("usage: /react <n> <reaction>")
+		}
+		n, err := strconv.Atoi(cmd.Args[0])
+		if err != nil {
+			return fmt.Errorf("usage: /react <n> <reaction>")
+		}
+		msg, err := c.app.RecentMessage("", n)
+		if err != nil {
+			return err
+		}
+		// reacting the same way again takes the reaction back
+		reaction := cmd.Args[1]
+		remove := c.app.HasReacted(msg, reaction)
+		if err := c.app.React("", msg.ID, reaction, remove); err != nil {
+			return err
+		}
+		if remove {
+			c.ui.ShowSystemMessage(fmt.Sprintf("Removed %s from %s", reaction, snippet(msg)))
+		} else {
+			c.ui.ShowSystemMessage(fmt.Sprintf("Reacted %s to %s", reaction, snippet(msg)))
+		}
+
+	case "seen":
+		var msg *app.ChatMessage
+		var err error
+		if len(cmd.Args) > 0 {
+			n, convErr := strconv.Atoi(cmd.Args[0])
+			if convErr != nil {
+				return fmt.Errorf("usage: /seen [n]")
+			}
+			msg, err = c.app.RecentMessage("", n)
+		} else {
+			msg, err = c.app.LastOwnMessage("")
+		}
+		if err != nil {
+			return err
+		}
+		delivered, read, err := c.app.Receipts("", msg.ID)
+		if err != nil {
+			return err
+		}
+		c.ui.ShowSystemMessage(fmt.Sprintf("%s\n  delivered to %s\n  seen by %s", snippet(msg), formatNames(delivered), formatNames(read)))
+
+	case "edit":
+		if len(cmd.Args) < 1 {
+			return fmt.Errorf("usage: /edit [n] <text>")
+		}
+		// a leading number picks the message when text follows it
+		args := cmd.Args
+		var msg *app.ChatMessage
+		var err error
+		if n, convErr := strconv.Atoi(args[0]); convErr == nil && len(args) > 1 {
+			msg, err = c.app.RecentMessage("", n)
+			args = args[1:]
+		} else {
+			msg, err = c.app.LastOwnMessage("")
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.app.EditMessage("", msg.ID, strings.Join(args, " ")); err != nil {
+			return err
+		}
+		c.ui.ShowSystemMessage("Message edited")
+
+	case "delete":
+		var msg *app.ChatMessage
+		var err error
+		if len(cmd.Args) > 0 {
+			n, convErr := strconv.Atoi(cmd.Args[0])
+			if convErr != nil {
+				return fmt.Errorf("usage: /delete [n]")
+			}
+			msg, err = c.app.RecentMessage("", n)
+		} else {
+			msg, err = c.app.LastOwnMessage("")
+		}
+		if err != nil {
 			return err
 		}
-		c.ui.ShowSystemMessage("Left room")
+		if err := c.app.DeleteMessage("", msg.ID); err != nil {
+			return err
+		}
+		c.ui.ShowSystemMessage("Message deleted")
+
+	case "reply":
+		if len(cmd.Args) < 2 {
+			return fmt.Errorf("usage: /reply <n> <text>")
+		}
+		n, err := strconv.Atoi(cmd.Args[0])
+		if err != nil {
+			return fmt.Errorf("usage: /reply <n> <text>")
+		}
+		parent, err := c.app.RecentMessage("", n)
+		if err != nil {
+			return err
+		}
+		return c.app.SendReply("", parent.ID, strings.Join(cmd.Args[1:], " "))
+
+	case "nick":
+		if len(cmd.Args) < 1 {
+			return fmt.Errorf("usage: /nick <name>")
+		}
+		if err := c.app.SetNickname(strings.Join(cmd.Args, " ")); err != nil {
+			return err
+		}
+		c.ui.ShowSystemMessage(fmt.Sprintf("You are now %s", c.app.GetUser().Nickname))
+
+	case "status":
+		if len(cmd.Args) < 1 {
+			return fmt.Errorf("usage: /status <online|away|busy|text>")
+		}
+		status := strings.Join(cmd.Args, " ")
+		if err := c.app.SetStatus(status); err != nil {
+			return err
+		}
+		c.ui.ShowSystemMessage(fmt.Sprintf("Status set to %s", c.app.GetUser().Status))
 
 	case "peers":
 		peers := c.app.GetPeerList()
@@ -58,18 +246,140 @@ func (c *Controller) handleCommand(cmd Command) error {
 		rooms := c.app.GetRoomList()
 		c.ui.ShowRoomList(rooms)
 
+	case "connect":
+		if len(cmd.Args) < 1 {
+			return fmt.Errorf("usage: /connect <multiaddr>")
+		}
+		if err := c.app.ConnectPeer(cmd.Args[0]); err != nil {
+			return err
+		}
+		c.ui.ShowSystemMessage(fmt.Sprintf("Connected to %s", cmd.Args[0]))
+
+	case "msg":
+		if len(cmd.Args) < 2 {
+			return fmt.Errorf("usage: /msg <nickname|@identity> <message>")
+		}
+		peer, err := c.app.FindPeer(cmd.Args[0])
+		if err != nil {
+			return err
+		}
+		text := strings.Join(cmd.Args[1:], " ")
+		if err := c.app.SendDirect(peer.ID.String(), text); err != nil {
+			return err
+		}
+		c.ui.ShowDirectMessage(peer.Nickname, app.GetIdentity(peer.ID), text, true)
+
+	case "send":
+		if len(cmd.Args) < 2 {
+			return fmt.Errorf("usage: /send <nickname|@identity> <path>")
+		}
+		peer, err := c.app.FindPeer(cmd.Args[0])
+		if err != nil {
+			return err
+		}
+		t, err := c.app.SendFile(peer.ID.String(), strings.Join(cmd.Args[1:], " "))
+		if err != nil {
+			return err
+		}
+		c.ui.ShowSystemMessage(fmt.Sprintf("Offered %s (%s) to %s [%s]", t.Name, formatSize(t.Size), t.Nickname, t.ID))
+
+	case "accept":
+		if len(cmd.Args) < 1 {
+			return fmt.Errorf("usage: /accept <id>")
+		}
+		if err := c.app.AcceptFile(cmd.Args[0]); err != nil {
+			return err
+		}
+		c.ui.ShowSystemMessage(fmt.Sprintf("Accepted transfer %s", cmd.Args[0]))
+
+	case "decline":
+		if len(cmd.Args) < 1 {
+			return fmt.Errorf("usage: /decline <id>")
+		}
+		if err := c.app.DeclineFile(cmd.Args[0]); err != nil {
+			return err
+		}
+
+	case "transfers":
+		transfers := c.app.GetTransfers()
+		if len(transfers) == 0 {
+			c.ui.ShowSystemMessage("No file transfers")
+			break
+		}
+		lines := []string{"File transfers:"}
+		for _, t := range transfers {
+			direction := "from"
+			if t.Outgoing {
+				direction = "to"
+			}
+			lines = append(lines, fmt.Sprintf("  [%s] %s %s %s - %s %s/%s", t.ID, t.Name, direction, t.Nickname, t.State, formatSize(t.Transferred), formatSize(t.Size)))
+		}
+		c.ui.ShowSystemMessage(strings.Join(lines, "\n"))
+
+	case "whoami":
+		user := c.app.GetUser()
+		lines := []string{
+			fmt.Sprintf("%s %s", user.Nickname, c.app.GetIdentity()),
+			fmt.Sprintf("Peer ID: %s", c.app.GetPeerID()),
+			"Addresses:",
+		}
+		for _, addr := range c.app.GetAddrs() {
+			lines = append(lines, "  "+addr)
+		}
+		c.ui.ShowSystemMessage(strings.Join(lines, "\n"))
+
+	case "netinfo":
+		peers := c.app.GetNetInfo()
+		lines := []string{
+			fmt.Sprintf("Peer ID: %s", c.app.GetPeerID()),
+			fmt.Sprintf("Connected peers (%d):", len(peers)),
+		}
+		for _, p := range peers {
+			line := fmt.Sprintf("  %s %s", p.Nickname, p.Identity)
+			if p.HasScore {
+				line += fmt.Sprintf("  score %.1f (rate limit %.1f, invalid msgs %.0f, behaviour %.1f)",
+					p.Score.Score, p.Score.AppSpecific, p.Score.InvalidMessages, p.Score.BehaviourPenalty)
+				if p.Score.Graylisted() {
+					line += " [graylisted]"
+				}
+			}
+			lines = append(lines, line)
+			for _, addr := range p.Addrs {
+				lines = append(lines, "    "+addr)
+			}
+		}
+		c.ui.ShowSystemMessage(strings.Join(lines, "\n"))
+
 	case "help":
 		helpText := `
 Available Commands:
-  /join <room> [password]  		- Join a room
-  /leave        			- Leave the current room
+  /join <room> [password]  		- Join a room, keeping the others
+  /leave [room] 			- Leave the active room, or the named one
+  /switch <room>			- Make a joined room the active one
+  /history [n]  			- Show the last n stored messages (20)
+  /search <terms>			- Search stored messages
+  /reply <n> <text>			- Reply to the nth newest message
+  /react <n> <reaction>			- React to the nth newest message, again to undo
+  /seen [n]     			- Show who got your last message, or the nth newest
+  /edit [n] <text>			- Edit your last message, or the nth newest
+  /delete [n]   			- Delete your last message, or the nth newest
+  /nick <name>  			- Change your nickname
+  /status <online|away|busy|text>	- Set your status
   /peers        			- List all connected peers
-  /rooms        			- List all available rooms
+  /rooms        			- List rooms with unread counts
+  /msg <nickname|@identity> <text>	- Send a private message
+  /send <nickname|@identity> <path>	- Offer a file to a peer
+  /accept <id>  			- Accept a file offer
+  /decline <id> 			- Decline a file offer
+  /transfers    			- List file transfers
+  /connect <multiaddr>   		- Connect to a peer by address
+  /whoami       			- Show your identity and addresses
+  /netinfo      			- Show connections and peer scores
   /help         			- Show this help message
   /quit         			- Exit the application`
 		c.ui.ShowSystemMessage(helpText)
 
-	case "send":
+	case "message":
 		room := c.app.GetCurrentRoom()
 		if room == nil {
 			return fmt.Errorf("not in a room (use /join <room>)")
@@ -93,13 +403,64 @@ func (c *Controller) handleAppEvents() {
 		switch event.Type {
 		case app.EventMessageRecv:
 			msg := event.Data.(*app.ChatMessage)
-			switch msg.Type {
-			case app.MessageTypeText:
-				c.ui.ShowMessage(msg.Nickname, msg.Identity, msg.Content)
-			case app.MessageTypeJoin:
-				c.ui.ShowPeerJoined(msg.Nickname, msg.Identity)
-			case app.MessageTypeLeave:
-				c.ui.ShowPeerLeft(msg.Nickname, msg.Identity)
+			if active := c.app.GetCurrentRoom(); active == nil || active.Name != msg.Room {
+				// kept as unread until the user switches to the room
+				if room := c.app.GetRoom(msg.Room); room != nil && room.UnreadCount() == 1 {
+					c.ui.ShowSystemMessage(fmt.Sprintf("New messages in %s, /switch %s to read them", msg.Room, msg.Room))
+				}
+				continue
+			}
+			c.stopTyping(msg)
+			c.showChatMessage(msg)
+		case app.EventTyping:
+			c.startTyping(event.Data.(*app.Typing))
+		case app.EventMessageEdited, app.EventMessageDeleted:
+			msg := event.Data.(*app.ChatMessage)
+			if active := c.app.GetCurrentRoom(); active != nil && active.Name == msg.Room {
+				c.ui.ShowMessage(msg.Nickname, msg.Identity, displayText(msg), c.quote(msg), time.Now())
+			}
+		case app.EventReaction:
+			r := event.Data.(*app.Reaction)
+			if active := c.app.GetCurrentRoom(); active == nil || active.Name != r.Room {
+				continue
+			}
+			action := "reacted"
+			if r.Removed {
+				action = "took back"
+			}
+			line := fmt.Sprintf("%s %s %s to %s", r.Nickname, action, r.Reaction, snippet(r.Message))
+			if len(r.Message.Reactions) > 0 {
+				line += " " + formatReactions(r.Message)
+			}
+			c.ui.ShowSystemMessage(line)
+		case app.EventStatusChange:
+			p := event.Data.(*app.PeerInfo)
+			c.ui.ShowSystemMessage(formatStatusChange(p))
+		case app.EventDirectMessage:
+			msg := event.Data.(*app.ChatMessage)
+			c.ui.ShowDirectMessage(msg.Nickname, msg.Identity, msg.Content, false)
+		case app.EventFileOffer:
+			t := event.Data.(*app.FileTransfer)
+			c.ui.ShowSystemMessage(fmt.Sprintf("%s wants to send you %s (%s), /accept %s or /decline %s", t.Nickname, t.Name, formatSize(t.Size), t.ID, t.ID))
+		case app.EventFileProgress:
+			t := event.Data.(*app.FileTransfer)
+			c.ui.ShowSystemMessage(fmt.Sprintf("%s: %d%%", t.Name, t.Transferred*100/max(t.Size, 1)))
+		case app.EventFileComplete:
+			t := event.Data.(*app.FileTransfer)
+			if t.Outgoing {
+				c.ui.ShowSystemMessage(fmt.Sprintf("Sent %s to %s", t.Name, t.Nickname))
+			} else {
+				c.ui.ShowSystemMessage(fmt.Sprintf("Received %s, saved to %s", t.Name, t.Path))
+			}
+		case app.EventFileFailed:
+			t := event.Data.(*app.FileTransfer)
+			switch {
+			case t.State == app.TransferDeclined && t.Outgoing:
+				c.ui.ShowSystemMessage(fmt.Sprintf("%s declined %s", t.Nickname, t.Name))
+			case t.State == app.TransferDeclined:
+				c.ui.ShowSystemMessage(fmt.Sprintf("Declined %s", t.Name))
+			default:
+				c.ui.ShowSystemMessage(fmt.Sprintf("Transfer of %s failed: %s (/accept %s to resume)", t.Name, t.Error, t.ID))
 			}
 		case app.EventSystemMessage:
 			msg := event.Data.(string)
@@ -109,6 +470,120 @@ func (c *Controller) handleAppEvents() {
 	}
 }
 
+func (c *Controller) showChatMessage(msg *app.ChatMessage) {
+	switch msg.Type {
+	case app.MessageTypeText:
+		// live messages are shown as they arrive, history at the sender's time
+		timestamp := time.Now()
+		if msg.History {
+			timestamp = msg.Timestamp
+		}
+		text := displayText(msg)
+		if len(msg.Reactions) > 0 {
+			text += " " + formatReactions(msg)
+		}
+		c.ui.ShowMessage(msg.Nickname, msg.Identity, text, c.quote(msg), timestamp)
+	case app.MessageTypeJoin:
+		c.ui.ShowPeerJoined(msg.Nickname, msg.Identity)
+	case app.MessageTypeLeave:
+		c.ui.ShowPeerLeft(msg.Nickname, msg.Identity)
+	case app.MessageTypeNick:
+		c.ui.ShowSystemMessage(msg.Content)
+	}
+}
+
+func (c *Controller) showStoredMessages(title string, messages []*app.ChatMessage) {
+	lines := []string{title}
+	for _, msg := range messages {
+		line := fmt.Sprintf("  %s %s: %s", msg.Timestamp.Format("2006-01-02 15:04"), msg.Nickname, displayText(msg))
+		if len(msg.Reactions) > 0 {
+			line += " " + formatReactions(msg)
+		}
+		lines = append(lines, line)
+	}
+	c.ui.ShowSystemMessage(strings.Join(lines, "\n"))
+}
+
+// quote returns a snippet of the message msg replies to
+func (c *Controller) quote(msg *app.ChatMessage) string {
+	if msg.ReplyTo == "" {
+		return ""
+	}
+	parent := c.app.FindMessage(msg.Room, msg.ReplyTo)
+	if parent == nil {
+		return "(earlier message)"
+	}
+	return snippet(parent)
+}
+
+// snippet is the sender and start of a message
+func snippet(msg *app.ChatMessage) string {
+	text := []rune(displayText(msg))
+	if len(text) > quoteLength {
+		text = append(text[:quoteLength], '.', '.', '.')
+	}
+	return fmt.Sprintf("%s: %s", msg.Nickname, string(text))
+}
+
+func formatStatusChange(p *app.PeerInfo) string {
+	switch p.Status {
+	case app.StatusOnline, app.StatusAway, app.StatusBusy:
+		return fmt.Sprintf("%s is now %s", p.Nickname, p.Status)
+	}
+	return fmt.Sprintf("%s set their status: %s", p.Nickname, p.Status)
+}
+
+func formatNames(names []string) string {
+	if len(names) == 0 {
+		return "nobody yet"
+	}
+	return fmt.Sprintf("%d (%s)", len(names), strings.Join(names, ", "))
+}
+
+// formatReactions lists a message's reactions with their counts
+func formatReactions(msg *app.ChatMessage) string {
+	parts := make([]string, 0, len(msg.Reactions))
+	for _, reaction := range slices.Sorted(maps.Keys(msg.Reactions)) {
+		parts = append(parts, fmt.Sprintf("%s %d", reaction, len(msg.Reactions[reaction])))
+	}
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// displayText marks edited messages and shows deleted ones as tombstones
+func displayText(msg *app.ChatMessage) string {
+	switch {
+	case msg.Deleted:
+		return "[message deleted]"
+	case msg.Edited:
+		return msg.Content + " (edited)"
+	}
+	return msg.Content
+}
+
+// refreshActiveRoom updates the prompt and returns the active room's name
+func (c *Controller) refreshActiveRoom() string {
+	name := ""
+	if room := c.app.GetCurrentRoom(); room != nil {
+		name = room.Name
+	}
+	c.ui.SetActiveRoom(name)
+	c.refreshTyping()
+	return name
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
 func (c *Controller) Start() error {
 	return c.ui.Start()
 }
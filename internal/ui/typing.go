@@ -0,0 +1,74 @@
+package ui
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/matt0792/lanchat/internal/app"
+)
+
+// handleTyping tells the active room we are typing, the app keeps it from
+// being sent on every key
+func (c *Controller) handleTyping() {
+	if c.app.GetCurrentRoom() != nil {
+		c.app.Typing("")
+	}
+}
+
+func (c *Controller) startTyping(t *app.Typing) {
+	c.typingMu.Lock()
+	if c.typing == nil {
+		c.typing = make(map[string]*app.Typing)
+	}
+	c.typing[t.Room+"/"+t.From.String()] = t
+	c.typingMu.Unlock()
+
+	time.AfterFunc(time.Until(t.Expires), c.refreshTyping)
+	c.refreshTyping()
+}
+
+// stopTyping forgets a peer typing once their message arrives
+func (c *Controller) stopTyping(msg *app.ChatMessage) {
+	c.typingMu.Lock()
+	delete(c.typing, msg.Room+"/"+msg.From.String())
+	c.typingMu.Unlock()
+
+	c.refreshTyping()
+}
+
+// refreshTyping shows who is typing in the active room
+func (c *Controller) refreshTyping() {
+	active := ""
+	if room := c.app.GetCurrentRoom(); room != nil {
+		active = room.Name
+	}
+
+	var nicknames []string
+	c.typingMu.Lock()
+	for key, t := range c.typing {
+		switch {
+		case time.Now().After(t.Expires):
+			delete(c.typing, key)
+		case t.Room == active:
+			nicknames = append(nicknames, t.Nickname)
+		}
+	}
+	c.typingMu.Unlock()
+
+	slices.Sort(nicknames)
+	c.ui.ShowTyping(formatTyping(nicknames))
+}
+
+func formatTyping(nicknames []string) string {
+	switch len(nicknames) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("%s is typing…", nicknames[0])
+	case 2:
+		return fmt.Sprintf("%s and %s are typing…", nicknames[0], nicknames[1])
+	default:
+		return "several people are typing…"
+	}
+}
@@ -1,7 +1,13 @@
 package ui
 
+import "time"
+
 type UI interface {
-	ShowMessage(nickname, identity, message string)
+	// ShowMessage shows a room message sent at timestamp, quote is a snippet
+	// of the message it replies to or empty
+	ShowMessage(nickname, identity, message, quote string, timestamp time.Time)
+	// ShowDirectMessage shows a private message, sent by us when outgoing
+	ShowDirectMessage(nickname, identity, message string, outgoing bool)
 	ShowSystemMessage(message string)
 	ShowPeerJoined(nickname, identity string)
 	ShowPeerLeft(nickname, identity string)
@@ -9,10 +15,18 @@ type UI interface {
 	ShowRoomList(rooms []string)
 	ShowError(err error)
 	ShowPrompt()
+	// SetActiveRoom shows which room plain messages go to, empty for none
+	SetActiveRoom(room string)
+	// ShowTyping shows who is composing a message in the active room, empty
+	// when nobody is
+	ShowTyping(status string)
 
 	Start() error
 	Stop()
 	OnCommand(handler CommandHandler)
+	// OnTyping sets a function called on key presses while the user is
+	// composing a message, where the UI can tell
+	OnTyping(handler func())
 }
 
 type CommandHandler func(cmd Command) error
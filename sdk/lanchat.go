@@ -2,8 +2,10 @@ package sdk
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/matt0792/lanchat/internal/app"
 )
 
@@ -15,7 +17,19 @@ type Lanchat struct {
 }
 
 func New(ctx context.Context, nickname, domain string, handler EventHandler, logger Logger) (*Lanchat, error) {
-	app, err := app.NewApp(ctx, nickname, domain)
+	return NewWithOptions(ctx, nickname, domain, handler, logger, nil)
+}
+
+// NewWithOptions is like New but accepts additional configuration. A nil opts
+// keeps the bot's identity under the default data directory, keyed by domain
+// and nickname.
+func NewWithOptions(ctx context.Context, nickname, domain string, handler EventHandler, logger Logger, opts *Options) (*Lanchat, error) {
+	appOpts, err := convertOptions(nickname, domain, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	app, err := app.NewApp(ctx, nickname, domain, appOpts)
 	if err != nil {
 		return nil, err
 	}
@@ -42,14 +56,42 @@ func (l *Lanchat) RegisterBot(bot Bot) error {
 	return bot.Initialize(l)
 }
 
+// JoinRoom joins a room and makes it the active one, rooms joined earlier
+// are kept
 func (l *Lanchat) JoinRoom(roomName, password string) error {
 	return l.app.JoinRoom(roomName, password)
 }
 
+// LeaveRoom leaves the active room
 func (l *Lanchat) LeaveRoom() error {
 	return l.app.LeaveRoom()
 }
 
+func (l *Lanchat) LeaveRoomByName(roomName string) error {
+	return l.app.LeaveRoomByName(roomName)
+}
+
+// SwitchRoom makes a joined room the active one
+func (l *Lanchat) SwitchRoom(roomName string) error {
+	_, err := l.app.SwitchRoom(roomName)
+	return err
+}
+
+// GetJoinedRooms returns every room we are in, sorted by name
+func (l *Lanchat) GetJoinedRooms() []*Room {
+	rooms := l.app.GetJoinedRooms()
+	converted := make([]*Room, 0, len(rooms))
+	for _, r := range rooms {
+		converted = append(converted, convertRoom(r))
+	}
+	return converted
+}
+
+// GetRoomMembers returns the peer IDs of everyone else in a joined room
+func (l *Lanchat) GetRoomMembers(roomName string) []string {
+	return l.app.GetRoomMembers(roomName)
+}
+
 func (l *Lanchat) GetRoomList() []string {
 	return l.app.GetRoomList()
 }
@@ -58,10 +100,171 @@ func (l *Lanchat) GetPeerList() []string {
 	return l.app.GetPeerList()
 }
 
+// ConnectPeer dials a peer by multiaddr, which must include /p2p/<peer-id>
+func (l *Lanchat) ConnectPeer(addr string) error {
+	return l.app.ConnectPeer(addr)
+}
+
+func (l *Lanchat) GetPeerID() string {
+	return l.app.GetPeerID()
+}
+
+// GetAddrs returns the multiaddrs other peers can pass to ConnectPeer or
+// BootstrapPeers to reach us
+func (l *Lanchat) GetAddrs() []string {
+	return l.app.GetAddrs()
+}
+
+// SendMessage sends to the active room
 func (l *Lanchat) SendMessage(text string) error {
 	return l.app.SendMessage(text)
 }
 
+// SendMessageTo sends to one of the joined rooms, bots usually reply to
+// msg.Room
+func (l *Lanchat) SendMessageTo(roomName, text string) error {
+	return l.app.SendMessageTo(roomName, text)
+}
+
+// Reply answers a message in its thread. Replies to direct messages are
+// sent back privately.
+func (l *Lanchat) Reply(msg ChatMessage, text string) error {
+	if msg.Room == "" {
+		return l.app.SendDirect(msg.From, text)
+	}
+	return l.app.SendReply(msg.Room, msg.ID, text)
+}
+
+// React adds a reaction, an emoji or short token like "+1", to a message
+// by ID in a joined room. An empty room name means the active room.
+func (l *Lanchat) React(roomName, id, reaction string) error {
+	return l.app.React(roomName, id, reaction, false)
+}
+
+// Unreact takes back one of our reactions
+func (l *Lanchat) Unreact(roomName, id, reaction string) error {
+	return l.app.React(roomName, id, reaction, true)
+}
+
+// SetNickname changes the bot's nickname and announces it in its rooms
+func (l *Lanchat) SetNickname(nickname string) error {
+	return l.app.SetNickname(nickname)
+}
+
+// SetStatus changes the bot's status to StatusOnline, StatusAway,
+// StatusBusy or a short text of its own
+func (l *Lanchat) SetStatus(status string) error {
+	return l.app.SetStatus(status)
+}
+
+// Typing shows us as typing in a joined room for a few seconds, it can be
+// called as often as needed. An empty room name means the active room.
+func (l *Lanchat) Typing(roomName string) error {
+	return l.app.Typing(roomName)
+}
+
+// KeepTyping shows us as typing in a joined room until stop is called, e.g.
+// while waiting on a slow answer. An empty room name means the active room.
+func (l *Lanchat) KeepTyping(roomName string) (stop func(), err error) {
+	return l.app.KeepTyping(roomName)
+}
+
+// EditMessage replaces the text of one of our messages, by ID, in a joined
+// room. An empty room name means the active room.
+func (l *Lanchat) EditMessage(roomName, id, text string) error {
+	return l.app.EditMessage(roomName, id, text)
+}
+
+// DeleteMessage removes one of our messages, by ID, from a joined room. An
+// empty room name means the active room.
+func (l *Lanchat) DeleteMessage(roomName, id string) error {
+	return l.app.DeleteMessage(roomName, id)
+}
+
+// GetHistory returns the last n messages stored for a joined room, all of
+// them when n is not positive. An empty room name means the active room.
+func (l *Lanchat) GetHistory(roomName string, n int) ([]*ChatMessage, error) {
+	messages, err := l.app.GetHistory(roomName, n)
+	if err != nil {
+		return nil, err
+	}
+	return convertChatMessages(messages), nil
+}
+
+// SearchHistory returns the stored messages of a joined room whose text or
+// sender contains every one of the terms, ignoring case
+func (l *Lanchat) SearchHistory(roomName, terms string) ([]*ChatMessage, error) {
+	messages, err := l.app.SearchHistory(roomName, terms)
+	if err != nil {
+		return nil, err
+	}
+	return convertChatMessages(messages), nil
+}
+
+// SendDirect sends a private message to one peer, end-to-end encrypted to
+// its identity key
+func (l *Lanchat) SendDirect(peerID, text string) error {
+	return l.app.SendDirect(peerID, text)
+}
+
+// SendFile offers a file to a peer. The returned transfer's ID identifies
+// it in later events.
+func (l *Lanchat) SendFile(peerID, path string) (*FileTransfer, error) {
+	t, err := l.app.SendFile(peerID, path)
+	if err != nil {
+		return nil, err
+	}
+	return convertFileTransfer(t), nil
+}
+
+// AcceptFile downloads an offered file into the download directory, or
+// resumes a failed download
+func (l *Lanchat) AcceptFile(id string) error {
+	return l.app.AcceptFile(id)
+}
+
+func (l *Lanchat) DeclineFile(id string) error {
+	return l.app.DeclineFile(id)
+}
+
+func (l *Lanchat) GetTransfers() []*FileTransfer {
+	transfers := l.app.GetTransfers()
+	converted := make([]*FileTransfer, 0, len(transfers))
+	for _, t := range transfers {
+		converted = append(converted, convertFileTransfer(t))
+	}
+	return converted
+}
+
+// bot methods live in their own namespace so they can't shadow the ones
+// lanchat uses internally
+const rpcPrefix = "bot."
+
+// RegisterRPC serves method to other peers, which call it with CallRPC
+func (l *Lanchat) RegisterRPC(method string, handler RPCHandler) {
+	l.RegisterRPCWithOptions(method, handler, nil)
+}
+
+// RegisterRPCWithOptions is like RegisterRPC but sets the method's request
+// size limit and handler timeout
+func (l *Lanchat) RegisterRPCWithOptions(method string, handler RPCHandler, opts *RPCOptions) {
+	l.app.RegisterRPC(rpcPrefix+method, func(ctx context.Context, from peer.ID, payload json.RawMessage) (any, error) {
+		return handler(ctx, from.String(), payload)
+	}, opts)
+}
+
+// CallRPC invokes a method registered with RegisterRPC on another peer,
+// decoding the result into resp unless it is nil
+func (l *Lanchat) CallRPC(ctx context.Context, peerID, method string, req, resp any) error {
+	return l.CallRPCWithOptions(ctx, peerID, method, req, resp, nil)
+}
+
+// CallRPCWithOptions is like CallRPC but sets the call's deadline and
+// response size limit
+func (l *Lanchat) CallRPCWithOptions(ctx context.Context, peerID, method string, req, resp any, opts *RPCOptions) error {
+	return l.app.CallRPC(ctx, peerID, rpcPrefix+method, req, resp, opts)
+}
+
 func (l *Lanchat) HandleEvents() {
 	for event := range l.app.GetEvents() {
 		switch event.Type {
@@ -69,12 +272,122 @@ func (l *Lanchat) HandleEvents() {
 			msg := convertChatMessage(event.Data.(*app.ChatMessage))
 			l.handler.HandleMessageRecv(msg)
 
+			// bots shouldn't answer questions asked before they joined
+			if msg.History {
+				break
+			}
+
 			for _, bot := range l.bots {
 				if err := bot.OnMessage(*msg, l); err != nil {
 					l.logger.LogError(fmt.Sprintf("bot error: %s", err.Error()))
 				}
 			}
 
+		case app.EventMessageEdited, app.EventMessageDeleted:
+			eventType := convertEventType(event.Type)
+			msg := convertChatMessage(event.Data.(*app.ChatMessage))
+			if h, ok := l.handler.(MessageChangeHandler); ok {
+				h.HandleMessageChange(eventType, msg)
+			}
+
+			if msg.History {
+				break
+			}
+
+			for _, bot := range l.bots {
+				changeBot, ok := bot.(MessageChangeBot)
+				if !ok {
+					continue
+				}
+				if err := changeBot.OnMessageChange(eventType, *msg, l); err != nil {
+					l.logger.LogError(fmt.Sprintf("bot error: %s", err.Error()))
+				}
+			}
+
+		case app.EventReaction:
+			r := convertReaction(event.Data.(*app.Reaction))
+			if h, ok := l.handler.(ReactionHandler); ok {
+				h.HandleReaction(r)
+			}
+
+			if r.History {
+				break
+			}
+
+			for _, bot := range l.bots {
+				reactionBot, ok := bot.(ReactionBot)
+				if !ok {
+					continue
+				}
+				if err := reactionBot.OnReaction(*r, l); err != nil {
+					l.logger.LogError(fmt.Sprintf("bot error: %s", err.Error()))
+				}
+			}
+
+		case app.EventTyping:
+			t := convertTyping(event.Data.(*app.Typing))
+			if h, ok := l.handler.(TypingHandler); ok {
+				h.HandleTyping(t)
+			}
+
+			for _, bot := range l.bots {
+				typingBot, ok := bot.(TypingBot)
+				if !ok {
+					continue
+				}
+				if err := typingBot.OnTyping(*t, l); err != nil {
+					l.logger.LogError(fmt.Sprintf("bot error: %s", err.Error()))
+				}
+			}
+
+		case app.EventReceipt:
+			msg := convertChatMessage(event.Data.(*app.ChatMessage))
+			if h, ok := l.handler.(ReceiptHandler); ok {
+				h.HandleReceipt(msg)
+			}
+
+			for _, bot := range l.bots {
+				receiptBot, ok := bot.(ReceiptBot)
+				if !ok {
+					continue
+				}
+				if err := receiptBot.OnReceipt(*msg, l); err != nil {
+					l.logger.LogError(fmt.Sprintf("bot error: %s", err.Error()))
+				}
+			}
+
+		case app.EventNicknameChange:
+			change := convertNicknameChange(event.Data.(*app.NicknameChange))
+			if h, ok := l.handler.(NicknameHandler); ok {
+				h.HandleNicknameChange(change)
+			}
+
+			for _, bot := range l.bots {
+				nicknameBot, ok := bot.(NicknameBot)
+				if !ok {
+					continue
+				}
+				if err := nicknameBot.OnNicknameChange(*change, l); err != nil {
+					l.logger.LogError(fmt.Sprintf("bot error: %s", err.Error()))
+				}
+			}
+
+		case app.EventStatusChange:
+			peerInfo := convertPeerInfo(event.Data.(*app.PeerInfo))
+			if h, ok := l.handler.(StatusHandler); ok {
+				h.HandleStatusChange(peerInfo)
+			}
+
+			for _, bot := range l.bots {
+				statusBot, ok := bot.(StatusBot)
+				if !ok {
+					continue
+				}
+				if err := statusBot.OnStatusChange(*peerInfo, l); err != nil {
+					l.logger.LogError(fmt.Sprintf("bot error: %s", err.Error()))
+				}
+			}
+
 		case app.EventPeerJoined:
 			peerInfo := convertPeerInfo(event.Data.(*app.PeerInfo))
 			l.handler.HandlePeerJoined(peerInfo)
@@ -85,6 +398,39 @@ func (l *Lanchat) HandleEvents() {
 				}
 			}
 
+		case app.EventDirectMessage:
+			msg := convertChatMessage(event.Data.(*app.ChatMessage))
+			if h, ok := l.handler.(DirectMessageHandler); ok {
+				h.HandleDirectMessage(msg)
+			}
+
+			for _, bot := range l.bots {
+				dmBot, ok := bot.(DirectMessageBot)
+				if !ok {
+					continue
+				}
+				if err := dmBot.OnDirectMessage(*msg, l); err != nil {
+					l.logger.LogError(fmt.Sprintf("bot error: %s", err.Error()))
+				}
+			}
+
+		case app.EventFileOffer, app.EventFileProgress, app.EventFileComplete, app.EventFileFailed:
+			eventType := convertEventType(event.Type)
+			t := convertFileTransfer(event.Data.(*app.FileTransfer))
+			if h, ok := l.handler.(FileTransferHandler); ok {
+				h.HandleFileTransfer(eventType, t)
+			}
+
+			for _, bot := range l.bots {
+				fileBot, ok := bot.(FileTransferBot)
+				if !ok {
+					continue
+				}
+				if err := fileBot.OnFileTransfer(eventType, *t, l); err != nil {
+					l.logger.LogError(fmt.Sprintf("bot error: %s", err.Error()))
+				}
+			}
+
 		case app.EventRoomJoined:
 			room := convertRoom(event.Data.(*app.Room))
 			l.handler.HandleRoomJoined(room)
@@ -1,31 +1,85 @@
 package sdk
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
 func TestCreateDefault(t *testing.T) {
-	app, err := New(context.Background(), "test", "test", nil, nil)
+	app, err := NewWithOptions(context.Background(), "test", "test", nil, nil, testOptions(t))
 	if err != nil {
 		t.Errorf("failed to create app: %s", err)
 	}
 	defer app.Close()
 }
 
+func TestDefaultDataDir(t *testing.T) {
+	dir := func(nickname, domain string, opts *Options) string {
+		o, err := convertOptions(nickname, domain, opts)
+		if err != nil {
+			t.Fatalf("failed to convert options: %v", err)
+		}
+		return o.DataDir
+	}
+
+	base := dir("bot", "team", nil)
+	if other := dir("bot", "other", nil); other == base {
+		t.Errorf("bots in different domains share %s", base)
+	}
+	if named := dir("bot", "team", &Options{Name: "second"}); named == base {
+		t.Errorf("bots with different names share %s", base)
+	}
+	if again := dir("bot", "team", &Options{}); again != base {
+		t.Errorf("same bot got a different directory: %s, want %s", again, base)
+	}
+}
+
+// testOptions keeps state out of the user's config dir
+func testOptions(t *testing.T) *Options {
+	return &Options{
+		DataDir: t.TempDir(),
+	}
+}
+
 type testHandler struct {
 	BaseEventHandler
 	messages    chan *ChatMessage
+	directs     chan *ChatMessage
 	peersJoined chan *PeerInfo
 	roomsJoined chan *Room
+	transfers   chan *FileTransfer
+	changes     chan *ChatMessage
+	reactions   chan *Reaction
+	receipts    chan *ChatMessage
+	typing      chan *Typing
+	statuses    chan *PeerInfo
+	nicknames   chan *NicknameChange
 }
 
 func newTestHandler() *testHandler {
 	return &testHandler{
 		messages:    make(chan *ChatMessage, 10),
+		directs:     make(chan *ChatMessage, 10),
 		peersJoined: make(chan *PeerInfo, 10),
 		roomsJoined: make(chan *Room, 10),
+		transfers:   make(chan *FileTransfer, 10),
+		changes:     make(chan *ChatMessage, 10),
+		reactions:   make(chan *Reaction, 10),
+		receipts:    make(chan *ChatMessage, 10),
+		typing:      make(chan *Typing, 10),
+		statuses:    make(chan *PeerInfo, 10),
+		nicknames:   make(chan *NicknameChange, 10),
 	}
 }
 
@@ -33,6 +87,40 @@ func (h *testHandler) HandleMessageRecv(msg *ChatMessage) {
 	h.messages <- msg
 }
 
+func (h *testHandler) HandleDirectMessage(msg *ChatMessage) {
+	h.directs <- msg
+}
+
+func (h *testHandler) HandleFileTransfer(eventType EventType, t *FileTransfer) {
+	if eventType != EventFileProgress {
+		h.transfers <- t
+	}
+}
+
+func (h *testHandler) HandleMessageChange(eventType EventType, msg *ChatMessage) {
+	h.changes <- msg
+}
+
+func (h *testHandler) HandleReaction(r *Reaction) {
+	h.reactions <- r
+}
+
+func (h *testHandler) HandleReceipt(msg *ChatMessage) {
+	h.receipts <- msg
+}
+
+func (h *testHandler) HandleTyping(t *Typing) {
+	h.typing <- t
+}
+
+func (h *testHandler) HandleStatusChange(peer *PeerInfo) {
+	h.statuses <- peer
+}
+
+func (h *testHandler) HandleNicknameChange(change *NicknameChange) {
+	h.nicknames <- change
+}
+
 func (h *testHandler) HandlePeerJoined(peer *PeerInfo) {
 	h.peersJoined <- peer
 }
@@ -45,7 +133,7 @@ func TestMessageRoundTrip(t *testing.T) {
 	ctx := context.Background()
 
 	handler1 := newTestHandler()
-	app1, err := New(ctx, "testUser1", "test", handler1, nil)
+	app1, err := NewWithOptions(ctx, "testUser1", "test", handler1, nil, testOptions(t))
 	if err != nil {
 		t.Fatalf("failed to create app1: %v", err)
 	}
@@ -54,7 +142,7 @@ func TestMessageRoundTrip(t *testing.T) {
 	go app1.HandleEvents()
 
 	handler2 := newTestHandler()
-	app2, err := New(ctx, "testUser2", "test", handler2, nil)
+	app2, err := NewWithOptions(ctx, "testUser2", "test", handler2, nil, testOptions(t))
 	if err != nil {
 		t.Fatalf("failed to create app2: %v", err)
 	}
@@ -89,7 +177,7 @@ func TestMessageRoundTrip(t *testing.T) {
 		t.Fatal("timeout waiting for peer discovery")
 	}
 
-	time.Sleep(500 * time.Millisecond)
+	waitForMembers(t, roomName, app1, app2)
 
 	testMessage := "Hello World!"
 	if err := app1.SendMessage(testMessage); err != nil {
@@ -128,3 +216,1367 @@ func TestMessageRoundTrip(t *testing.T) {
 		t.Fatal("timeout waiting for reply")
 	}
 }
+
+// newUnlistedApp starts an instance that can only be reached by dialing it
+func newUnlistedApp(t *testing.T, nickname string, handler EventHandler, bootstrap []string) *Lanchat {
+	opts := testOptions(t)
+	opts.DisableMDNS = true
+	opts.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+	opts.BootstrapPeers = bootstrap
+
+	app, err := NewWithOptions(context.Background(), nickname, "test", handler, nil, opts)
+	if err != nil {
+		t.Fatalf("failed to create %s: %v", nickname, err)
+	}
+	t.Cleanup(func() { app.Close() })
+	go app.HandleEvents()
+	return app
+}
+
+func TestConnectPeer(t *testing.T) {
+	handler1 := newTestHandler()
+	app1 := newUnlistedApp(t, "testUser1", handler1, nil)
+	app2 := newUnlistedApp(t, "testUser2", newTestHandler(), nil)
+
+	addrs := app1.GetAddrs()
+	if len(addrs) == 0 {
+		t.Fatal("no dialable addresses")
+	}
+	for _, addr := range addrs {
+		if !strings.HasSuffix(addr, "/p2p/"+app1.GetPeerID()) {
+			t.Errorf("address %s doesn't end with our peer ID", addr)
+		}
+	}
+
+	if err := app2.ConnectPeer("/ip4/127.0.0.1/tcp/4001"); err == nil {
+		t.Error("expected error connecting without a peer ID")
+	}
+	if err := app2.ConnectPeer(app2.GetAddrs()[0]); err == nil {
+		t.Error("expected error connecting to ourselves")
+	}
+
+	select {
+	case <-handler1.peersJoined:
+		t.Fatal("peers met without dialing")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	if err := app2.ConnectPeer(addrs[0]); err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+
+	select {
+	case peer := <-handler1.peersJoined:
+		if peer.Nickname != "testUser2" {
+			t.Errorf("wrong peer: got %q, want %q", peer.Nickname, "testUser2")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for dialed peer")
+	}
+}
+
+func TestBootstrapPeers(t *testing.T) {
+	handler1 := newTestHandler()
+	app1 := newUnlistedApp(t, "testUser1", handler1, nil)
+	newUnlistedApp(t, "testUser2", newTestHandler(), append([]string{"not-an-addr"}, app1.GetAddrs()...))
+
+	select {
+	case peer := <-handler1.peersJoined:
+		if peer.Nickname != "testUser2" {
+			t.Errorf("wrong peer: got %q, want %q", peer.Nickname, "testUser2")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for bootstrap peer")
+	}
+}
+
+// newMemoryApp starts an instance on network that is closed when the test ends
+func newMemoryApp(t *testing.T, network *MemoryNetwork, nickname string, handler EventHandler) *Lanchat {
+	return newMemoryAppWithOptions(t, network, nickname, handler, testOptions(t))
+}
+
+func newMemoryAppWithOptions(t *testing.T, network *MemoryNetwork, nickname string, handler EventHandler, opts *Options) *Lanchat {
+	t.Helper()
+	return newMemoryAppInDomain(t, network, "test", nickname, handler, opts)
+}
+
+func newMemoryAppInDomain(t *testing.T, network *MemoryNetwork, domain, nickname string, handler EventHandler, opts *Options) *Lanchat {
+	t.Helper()
+	opts.DisableMDNS = true
+	opts.MemoryNetwork = network
+
+	app, err := NewWithOptions(context.Background(), nickname, domain, handler, nil, opts)
+	if err != nil {
+		t.Fatalf("failed to create %s: %v", nickname, err)
+	}
+	t.Cleanup(func() { app.Close() })
+	go app.HandleEvents()
+	return app
+}
+
+// waitForMembers blocks until every app lists all the others as members
+// of a room. Each side only learns of the others' subscriptions once its
+// streams to them are up, so from then on messages reach everyone.
+func waitForMembers(t *testing.T, room string, apps ...*Lanchat) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, app := range apps {
+		for _, other := range apps {
+			if other == app {
+				continue
+			}
+			for !slices.Contains(app.GetRoomMembers(room), other.GetPeerID()) {
+				if time.Now().After(deadline) {
+					t.Fatalf("timeout waiting for %s to see %s in %s", app.GetPeerID()[:8], other.GetPeerID()[:8], room)
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+		}
+	}
+}
+
+// waitForReceipt blocks until a peer acknowledges one of our messages. Our
+// own messages are queued for our handlers before they go out and handlers
+// run in order, so by then that message and everything we sent before it
+// is stored.
+func waitForReceipt(t *testing.T, handler *testHandler, content string) {
+	t.Helper()
+	for {
+		select {
+		case msg := <-handler.receipts:
+			if msg.Content == content {
+				return
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timeout waiting for a receipt of %q", content)
+		}
+	}
+}
+
+func TestMemoryDiscovery(t *testing.T) {
+	network := NewMemoryNetwork()
+
+	handler1 := newTestHandler()
+	newMemoryApp(t, network, "testUser1", handler1)
+	newMemoryApp(t, network, "testUser2", newTestHandler())
+
+	select {
+	case peer := <-handler1.peersJoined:
+		if peer.Nickname != "testUser2" {
+			t.Errorf("wrong peer: got %q, want %q", peer.Nickname, "testUser2")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for in-memory discovery")
+	}
+}
+
+func TestDomainIsolation(t *testing.T) {
+	network := NewMemoryNetwork()
+
+	handler1 := newTestHandler()
+	app1 := newMemoryAppInDomain(t, network, "team-a", "testUser1", handler1, testOptions(t))
+	app2 := newMemoryAppInDomain(t, network, "team-b", "testUser2", newTestHandler(), testOptions(t))
+	newMemoryAppInDomain(t, network, "", "testUser3", newTestHandler(), testOptions(t))
+	app4 := newMemoryAppInDomain(t, network, "team-a", "testUser4", newTestHandler(), testOptions(t))
+
+	select {
+	case peer := <-handler1.peersJoined:
+		if peer.Nickname != "testUser4" {
+			t.Fatalf("peer from another domain joined: %s", peer.Nickname)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for peer from our domain")
+	}
+
+	for _, app := range []*Lanchat{app1, app2, app4} {
+		if err := app.JoinRoom("general", ""); err != nil {
+			t.Fatalf("failed to join room: %v", err)
+		}
+	}
+	waitForMembers(t, "general", app1, app4)
+
+	if err := app2.SendMessage("from team b"); err != nil {
+		t.Fatalf("failed to send message: %v", err)
+	}
+	if err := app4.SendMessage("from team a"); err != nil {
+		t.Fatalf("failed to send message: %v", err)
+	}
+
+	select {
+	case msg := <-skipToText(t, handler1.messages):
+		if msg.Content != "from team a" {
+			t.Errorf("got %q from another domain", msg.Content)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+
+	if members := app1.GetRoomMembers("general"); len(members) != 1 || members[0] != app4.GetPeerID() {
+		t.Errorf("room members %v, want only %s", members, app4.GetPeerID())
+	}
+	if peers := app1.GetPeerList(); len(peers) != 1 {
+		t.Errorf("peer list %v, want only testUser4", peers)
+	}
+}
+
+func TestDomainSecret(t *testing.T) {
+	network := NewMemoryNetwork()
+
+	withSecret := func(secret string) *Options {
+		opts := testOptions(t)
+		opts.DomainSecret = secret
+		return opts
+	}
+
+	handler1 := newTestHandler()
+	newMemoryAppInDomain(t, network, "team", "testUser1", handler1, withSecret("secret"))
+	newMemoryAppInDomain(t, network, "team", "testUser2", newTestHandler(), withSecret("other"))
+	newMemoryAppInDomain(t, network, "team", "testUser3", newTestHandler(), testOptions(t))
+	newMemoryAppInDomain(t, network, "other", "testUser4", newTestHandler(), withSecret("secret"))
+	newMemoryAppInDomain(t, network, "team", "testUser5", newTestHandler(), withSecret("secret"))
+
+	select {
+	case peer := <-handler1.peersJoined:
+		if peer.Nickname != "testUser5" {
+			t.Fatalf("peer without our key joined: %s", peer.Nickname)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for peer with our key")
+	}
+
+	select {
+	case peer := <-handler1.peersJoined:
+		t.Errorf("peer without our key joined: %s", peer.Nickname)
+	case <-time.After(time.Second):
+	}
+}
+
+func TestMetadataPush(t *testing.T) {
+	network := NewMemoryNetwork()
+
+	handler1 := newTestHandler()
+	app1 := newMemoryApp(t, network, "testUser1", handler1)
+	app2 := newMemoryApp(t, network, "testUser2", newTestHandler())
+
+	select {
+	case <-handler1.peersJoined:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for peer")
+	}
+
+	if err := app2.JoinRoom("pushed", ""); err != nil {
+		t.Fatalf("failed to join room: %v", err)
+	}
+
+	// room lists change without an event, a status change pushed after
+	// the join tells us app1 is up to date
+	if err := app2.SetStatus("busy"); err != nil {
+		t.Fatalf("failed to set status: %v", err)
+	}
+	select {
+	case <-handler1.statuses:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for status")
+	}
+
+	if rooms := app1.GetRoomList(); len(rooms) != 1 || rooms[0] != "pushed" {
+		t.Fatalf("room list never updated: %v", rooms)
+	}
+}
+
+func TestMultipleRooms(t *testing.T) {
+	network := NewMemoryNetwork()
+
+	handler1 := newTestHandler()
+	app1 := newMemoryApp(t, network, "testUser1", handler1)
+	app2 := newMemoryApp(t, network, "testUser2", newTestHandler())
+
+	select {
+	case <-handler1.peersJoined:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for peer")
+	}
+
+	for _, app := range []*Lanchat{app1, app2} {
+		for _, room := range []string{"general", "ops"} {
+			if err := app.JoinRoom(room, ""); err != nil {
+				t.Fatalf("failed to join %s: %v", room, err)
+			}
+		}
+	}
+	if err := app1.SwitchRoom("general"); err != nil {
+		t.Fatalf("failed to switch room: %v", err)
+	}
+
+	for _, room := range []string{"general", "ops"} {
+		waitForMembers(t, room, app1, app2)
+	}
+
+	// app2's active room is ops, so this only goes to general because it
+	// says so
+	if err := app2.SendMessageTo("general", "in general"); err != nil {
+		t.Fatalf("failed to send to general: %v", err)
+	}
+	if err := app2.SendMessage("in ops"); err != nil {
+		t.Fatalf("failed to send to ops: %v", err)
+	}
+
+	want := map[string]string{"in general": "general", "in ops": "ops"}
+	for len(want) > 0 {
+		select {
+		case msg := <-handler1.messages:
+			if msg.Type != MessageTypeText {
+				continue
+			}
+			if room, ok := want[msg.Content]; !ok || msg.Room != room {
+				t.Errorf("message %q arrived in %q", msg.Content, msg.Room)
+			}
+			delete(want, msg.Content)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timeout waiting for messages: %v", want)
+		}
+	}
+
+	rooms := app1.GetJoinedRooms()
+	if len(rooms) != 2 || rooms[0].Name != "general" || rooms[1].Name != "ops" {
+		t.Fatalf("wrong joined rooms: %v", rooms)
+	}
+	if rooms[0].Unread != 0 || rooms[1].Unread == 0 {
+		t.Errorf("wrong unread counts: general %d, ops %d", rooms[0].Unread, rooms[1].Unread)
+	}
+
+	if err := app1.LeaveRoomByName("ops"); err != nil {
+		t.Fatalf("failed to leave ops: %v", err)
+	}
+	if err := app1.SendMessageTo("ops", "gone"); err == nil {
+		t.Error("expected an error sending to a room we left")
+	}
+	if err := app1.SendMessage("still here"); err != nil {
+		t.Errorf("failed to send to general after leaving ops: %v", err)
+	}
+}
+
+func TestConcurrentJoin(t *testing.T) {
+	app := newMemoryApp(t, NewMemoryNetwork(), "testUser1", newTestHandler())
+
+	// only the joins with the password of whichever join won may succeed
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		password := ""
+		if i%2 == 1 {
+			password = "secret"
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = app.JoinRoom("general", password)
+		}()
+	}
+	wg.Wait()
+
+	rooms := app.GetJoinedRooms()
+	if len(rooms) != 1 {
+		t.Fatalf("joined %d rooms, want 1", len(rooms))
+	}
+	joined := 0
+	for _, err := range errs {
+		if err == nil {
+			joined++
+		}
+	}
+	if joined != len(errs)/2 {
+		t.Errorf("%d joins succeeded, want %d: %v", joined, len(errs)/2, errs)
+	}
+
+	// the app allows ten rooms at once
+	const maxJoinedRooms = 10
+	for i := range 2 * maxJoinedRooms {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.JoinRoom(fmt.Sprintf("room%d", i), "")
+		}()
+	}
+	wg.Wait()
+
+	if rooms := app.GetJoinedRooms(); len(rooms) != maxJoinedRooms {
+		t.Errorf("joined %d rooms, want %d", len(rooms), maxJoinedRooms)
+	}
+}
+
+func TestHistorySync(t *testing.T) {
+	network := NewMemoryNetwork()
+
+	const room, password = "history", "secret"
+
+	handler1 := newTestHandler()
+	app1 := newMemoryApp(t, network, "testUser1", handler1)
+	handler2 := newTestHandler()
+	app2 := newMemoryApp(t, network, "testUser2", handler2)
+
+	select {
+	case <-handler1.peersJoined:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for peer")
+	}
+
+	for _, app := range []*Lanchat{app1, app2} {
+		if err := app.JoinRoom(room, password); err != nil {
+			t.Fatalf("failed to join room: %v", err)
+		}
+	}
+	waitForMembers(t, room, app1, app2)
+
+	said := []string{"one", "two", "three"}
+	for _, text := range said {
+		if err := app1.SendMessage(text); err != nil {
+			t.Fatalf("failed to send message: %v", err)
+		}
+	}
+	var ids []string
+	for len(ids) < len(said) {
+		select {
+		case msg := <-handler2.messages:
+			if msg.Type == MessageTypeText {
+				ids = append(ids, msg.ID)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout waiting for live messages")
+		}
+	}
+
+	// a late joiner gets the messages from both members, once each
+	handler3 := newTestHandler()
+	app3 := newMemoryApp(t, network, "testUser3", handler3)
+	for joined := 0; joined < 2; joined++ {
+		select {
+		case <-handler3.peersJoined:
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout waiting for peers")
+		}
+	}
+	for app1Knows3 := false; !app1Knows3; {
+		select {
+		case peer := <-handler1.peersJoined:
+			app1Knows3 = peer.ID == app3.GetPeerID()
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout waiting for peer")
+		}
+	}
+
+	// the topic name is no secret, without the password there is no history
+	var resp struct{ Messages [][]byte }
+	req := map[string]any{"topic": app1.app.GetRoom(room).Topic, "limit": 50}
+	if err := app3.app.CallRPC(context.Background(), app1.GetPeerID(), "history.get", req, &resp, nil); err != nil {
+		t.Fatalf("history call failed: %v", err)
+	}
+	if len(resp.Messages) != 0 {
+		t.Errorf("got %d history messages without proof of the password", len(resp.Messages))
+	}
+
+	if err := app3.JoinRoom(room, password); err != nil {
+		t.Fatalf("failed to join room: %v", err)
+	}
+
+	var got []string
+	timeout := time.After(5 * time.Second)
+	for len(got) < len(said) {
+		select {
+		case msg := <-handler3.messages:
+			if msg.Type != MessageTypeText {
+				continue
+			}
+			if !msg.History {
+				t.Errorf("message %q not marked as history", msg.Content)
+			}
+			// the sender's ID is kept however a message arrives
+			if msg.ID != ids[len(got)] {
+				t.Errorf("message %q has ID %s, was %s live", msg.Content, msg.ID, ids[len(got)])
+			}
+			got = append(got, msg.Content)
+		case <-timeout:
+			t.Fatalf("timeout waiting for history, got %v", got)
+		}
+	}
+	for i := range said {
+		if got[i] != said[i] {
+			t.Fatalf("wrong history: got %v, want %v", got, said)
+		}
+	}
+
+	select {
+	case msg := <-handler3.messages:
+		if msg.Type == MessageTypeText {
+			t.Errorf("duplicate history message %q", msg.Content)
+		}
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestStoredHistory(t *testing.T) {
+	network := NewMemoryNetwork()
+	dataDir := t.TempDir()
+
+	const room, password = "stored", "secret"
+
+	handler1 := newTestHandler()
+	app1 := newMemoryAppWithOptions(t, network, "testUser1", handler1, &Options{DataDir: dataDir})
+	handler2 := newTestHandler()
+	app2 := newMemoryApp(t, network, "testUser2", handler2)
+
+	select {
+	case <-handler1.peersJoined:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for peer")
+	}
+
+	for _, app := range []*Lanchat{app1, app2} {
+		if err := app.JoinRoom(room, password); err != nil {
+			t.Fatalf("failed to join room: %v", err)
+		}
+	}
+	waitForMembers(t, room, app1, app2)
+
+	if err := app2.SendMessage("the deploy is done"); err != nil {
+		t.Fatalf("failed to send message: %v", err)
+	}
+	select {
+	case msg := <-skipToText(t, handler1.messages):
+		if msg.Content != "the deploy is done" {
+			t.Fatalf("wrong message: %q", msg.Content)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+	if err := app1.SendMessage("thanks"); err != nil {
+		t.Fatalf("failed to send message: %v", err)
+	}
+
+	waitForReceipt(t, handler1, "thanks")
+
+	history, _ := app1.GetHistory("", 0)
+	if len(history) != 2 || history[0].Content != "the deploy is done" || history[1].Content != "thanks" {
+		t.Fatalf("wrong history: %v", history)
+	}
+
+	matches, err := app1.SearchHistory(room, "DEPLOY done")
+	if err != nil || len(matches) != 1 || matches[0].Nickname != "testUser2" {
+		t.Errorf("wrong search result: %v, %v", matches, err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dataDir, "history", "*"))
+	for _, file := range files {
+		data, _ := os.ReadFile(file)
+		if bytes.Contains(data, []byte("deploy")) {
+			t.Errorf("message stored in plaintext in %s", file)
+		}
+	}
+
+	// messages survive a restart
+	app1.Close()
+	app1 = newMemoryAppWithOptions(t, network, "testUser1", newTestHandler(), &Options{DataDir: dataDir})
+	if err := app1.JoinRoom(room, password); err != nil {
+		t.Fatalf("failed to rejoin room: %v", err)
+	}
+	history, err = app1.GetHistory(room, 1)
+	if err != nil || len(history) != 1 || history[0].Content != "thanks" {
+		t.Errorf("history lost on restart: %v, %v", history, err)
+	}
+}
+
+// skipToText passes on the first text message, it stops reading when the
+// test ends
+func skipToText(t *testing.T, messages <-chan *ChatMessage) <-chan *ChatMessage {
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+
+	out := make(chan *ChatMessage, 1)
+	go func() {
+		for {
+			select {
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				if msg.Type == MessageTypeText {
+					out <- msg
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func TestMessageOrder(t *testing.T) {
+	network := NewMemoryNetwork()
+
+	handler1 := newTestHandler()
+	app1 := newMemoryApp(t, network, "testUser1", handler1)
+	handler2 := newTestHandler()
+	app2 := newMemoryApp(t, network, "testUser2", handler2)
+
+	select {
+	case <-handler1.peersJoined:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for peer")
+	}
+
+	const room = "order"
+	for _, app := range []*Lanchat{app1, app2} {
+		if err := app.JoinRoom(room, ""); err != nil {
+			t.Fatalf("failed to join room: %v", err)
+		}
+	}
+	waitForMembers(t, room, app1, app2)
+
+	// both talk at once
+	const each = 5
+	done := make(chan error, 2)
+	for n, app := range []*Lanchat{app1, app2} {
+		go func() {
+			for i := range each {
+				if err := app.SendMessage(fmt.Sprintf("app%d %d", n+1, i)); err != nil {
+					done <- err
+					return
+				}
+			}
+			done <- nil
+		}()
+	}
+	for range 2 {
+		if err := <-done; err != nil {
+			t.Fatalf("failed to send message: %v", err)
+		}
+	}
+
+	// every message of the other side has arrived
+	waitForTexts := func(handler *testHandler, from string, n int) {
+		t.Helper()
+		// history sync may hand our own messages back, count only theirs
+		seen := make(map[string]bool)
+		timeout := time.After(3 * time.Second)
+		for len(seen) < n {
+			select {
+			case msg := <-handler.messages:
+				if msg.Type == MessageTypeText && msg.From == from {
+					seen[msg.Content] = true
+				}
+			case <-timeout:
+				t.Fatalf("timeout waiting for messages, got %d of %d", len(seen), n)
+			}
+		}
+	}
+
+	// an answer comes after its question on every peer
+	waitForTexts(handler2, app1.GetPeerID(), each)
+	if err := app2.SendMessage("answer"); err != nil {
+		t.Fatalf("failed to send answer: %v", err)
+	}
+	waitForTexts(handler1, app2.GetPeerID(), each+1)
+	waitForReceipt(t, handler2, "answer")
+
+	const total = 2*each + 1
+	histories := make([][]*ChatMessage, 2)
+	for i, app := range []*Lanchat{app1, app2} {
+		histories[i], _ = app.GetHistory(room, 0)
+		if len(histories[i]) != total {
+			t.Fatalf("got %d messages, want %d", len(histories[i]), total)
+		}
+		if last := histories[i][total-1]; last.Content != "answer" {
+			t.Errorf("answer not last: %q", last.Content)
+		}
+	}
+	for i := range total {
+		if histories[0][i].ID != histories[1][i].ID {
+			t.Fatalf("peers disagree on message %d: %q and %q", i, histories[0][i].Content, histories[1][i].Content)
+		}
+	}
+}
+
+func TestEditDelete(t *testing.T) {
+	network := NewMemoryNetwork()
+
+	handler1 := newTestHandler()
+	app1 := newMemoryApp(t, network, "testUser1", handler1)
+	handler2 := newTestHandler()
+	app2 := newMemoryApp(t, network, "testUser2", handler2)
+
+	select {
+	case <-handler1.peersJoined:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for peer")
+	}
+
+	const room, password = "edits", "secret"
+	for _, app := range []*Lanchat{app1, app2} {
+		if err := app.JoinRoom(room, password); err != nil {
+			t.Fatalf("failed to join room: %v", err)
+		}
+	}
+	waitForMembers(t, room, app1, app2)
+
+	if err := app1.SendMessage("helo"); err != nil {
+		t.Fatalf("failed to send message: %v", err)
+	}
+	var sent *ChatMessage
+	select {
+	case sent = <-skipToText(t, handler2.messages):
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+
+	if err := app2.EditMessage(room, sent.ID, "hijacked"); err == nil {
+		t.Error("edited someone else's message")
+	}
+
+	waitForReceipt(t, handler1, "helo")
+	if history, _ := app1.GetHistory(room, 0); len(history) != 1 || history[0].ID != sent.ID {
+		t.Fatalf("sender never stored its message: %v", history)
+	}
+
+	// changes get no receipts. app1 handles its own changes before they go
+	// out, so once a message app2 sends after seeing one arrives, app1 has
+	// applied it.
+	reply := func(text string) {
+		t.Helper()
+		if err := app2.SendMessage(text); err != nil {
+			t.Fatalf("failed to send message: %v", err)
+		}
+		// history sync may hand our own messages back first
+		timeout := time.After(3 * time.Second)
+		for {
+			select {
+			case msg := <-handler1.messages:
+				if msg.Type == MessageTypeText && msg.Content == text {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("timeout waiting for %q", text)
+			}
+		}
+	}
+
+	if err := app1.EditMessage(room, sent.ID, "hello"); err != nil {
+		t.Fatalf("failed to edit message: %v", err)
+	}
+	select {
+	case msg := <-handler2.changes:
+		if msg.ID != sent.ID || msg.Content != "hello" || !msg.Edited {
+			t.Errorf("wrong edit: %+v", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for edit")
+	}
+
+	// the sender gets no event for its own changes, but applies them too
+	reply("ok")
+	if history, err := app1.GetHistory(room, 0); err != nil || len(history) == 0 || history[0].Content != "hello" || !history[0].Edited {
+		t.Errorf("edit missing from the sender's history: %v, %v", history, err)
+	}
+
+	if err := app1.DeleteMessage(room, sent.ID); err != nil {
+		t.Fatalf("failed to delete message: %v", err)
+	}
+	select {
+	case msg := <-handler2.changes:
+		if msg.ID != sent.ID || msg.Content != "" || !msg.Deleted {
+			t.Errorf("wrong delete: %+v", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for delete")
+	}
+
+	// the stored history keeps a tombstone, the sender's too
+	reply("bye")
+	for _, app := range []*Lanchat{app1, app2} {
+		history, err := app.GetHistory(room, 0)
+		if err != nil || len(history) == 0 || history[0].ID != sent.ID || !history[0].Deleted {
+			t.Errorf("wrong history of %s: %v, %v", app.GetPeerID()[:8], history, err)
+		}
+	}
+}
+
+func TestReply(t *testing.T) {
+	network := NewMemoryNetwork()
+
+	handler1 := newTestHandler()
+	app1 := newMemoryApp(t, network, "testUser1", handler1)
+	handler2 := newTestHandler()
+	app2 := newMemoryApp(t, network, "testUser2", handler2)
+
+	select {
+	case <-handler1.peersJoined:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for peer")
+	}
+
+	const room = "threads"
+	for _, app := range []*Lanchat{app1, app2} {
+		if err := app.JoinRoom(room, ""); err != nil {
+			t.Fatalf("failed to join room: %v", err)
+		}
+	}
+	waitForMembers(t, room, app1, app2)
+
+	if err := app1.SendMessage("mathbot add 1 2"); err != nil {
+		t.Fatalf("failed to send message: %v", err)
+	}
+	var question *ChatMessage
+	select {
+	case question = <-skipToText(t, handler2.messages):
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for question")
+	}
+
+	if err := app2.Reply(*question, "3"); err != nil {
+		t.Fatalf("failed to reply: %v", err)
+	}
+	select {
+	case msg := <-skipToText(t, handler1.messages):
+		if msg.Content != "3" || msg.ReplyTo != question.ID {
+			t.Errorf("wrong reply: %q to %q, want %q", msg.Content, msg.ReplyTo, question.ID)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for reply")
+	}
+
+	history, err := app1.GetHistory(room, 1)
+	if err != nil || len(history) != 1 || history[0].ReplyTo != question.ID {
+		t.Errorf("reply not stored: %v, %v", history, err)
+	}
+}
+
+func TestReactions(t *testing.T) {
+	network := NewMemoryNetwork()
+
+	handler1 := newTestHandler()
+	app1 := newMemoryApp(t, network, "testUser1", handler1)
+	handler2 := newTestHandler()
+	app2 := newMemoryApp(t, network, "testUser2", handler2)
+
+	select {
+	case <-handler1.peersJoined:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for peer")
+	}
+
+	const room, password = "polls", "secret"
+	for _, app := range []*Lanchat{app1, app2} {
+		if err := app.JoinRoom(room, password); err != nil {
+			t.Fatalf("failed to join room: %v", err)
+		}
+	}
+	waitForMembers(t, room, app1, app2)
+
+	if err := app1.SendMessage("lunch at noon?"); err != nil {
+		t.Fatalf("failed to send message: %v", err)
+	}
+	var poll *ChatMessage
+	select {
+	case poll = <-skipToText(t, handler2.messages):
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for poll")
+	}
+
+	nextReaction := func() *Reaction {
+		select {
+		case r := <-handler1.reactions:
+			return r
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout waiting for reaction")
+			return nil
+		}
+	}
+
+	// emoji survive sanitization, escape sequences don't
+	for _, reaction := range []string{"👍🏽", "+1\x1b[2J"} {
+		if err := app2.React(room, poll.ID, reaction); err != nil {
+			t.Fatalf("failed to react: %v", err)
+		}
+	}
+	if r := nextReaction(); r.Reaction != "👍🏽" || r.MessageID != poll.ID || r.Nickname != "testUser2" {
+		t.Errorf("wrong reaction: %+v", r)
+	}
+	r := nextReaction()
+	if r.Reaction != "+12J" {
+		t.Errorf("reaction not sanitized: %q", r.Reaction)
+	}
+	if len(r.Message.Reactions) != 2 || len(r.Message.Reactions["👍🏽"]) != 1 {
+		t.Errorf("wrong reactions: %v", r.Message.Reactions)
+	}
+
+	if err := app2.Unreact(room, poll.ID, "👍🏽"); err != nil {
+		t.Fatalf("failed to take back reaction: %v", err)
+	}
+	if r := nextReaction(); !r.Removed || len(r.Message.Reactions) != 1 {
+		t.Errorf("reaction not removed: %+v, %v", r, r.Message.Reactions)
+	}
+}
+
+func TestRPC(t *testing.T) {
+	ctx := context.Background()
+	network := NewMemoryNetwork()
+
+	handler1 := newTestHandler()
+	app1 := newMemoryApp(t, network, "testUser1", handler1)
+	app2 := newMemoryApp(t, network, "testUser2", newTestHandler())
+
+	app2.RegisterRPC("echo", func(ctx context.Context, from string, payload json.RawMessage) (any, error) {
+		var text string
+		if err := json.Unmarshal(payload, &text); err != nil {
+			return nil, &RPCError{Code: RPCCodeBadRequest, Message: "expected a string"}
+		}
+		return text + " from " + from[:8], nil
+	})
+
+	var peer *PeerInfo
+	select {
+	case peer = <-handler1.peersJoined:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for peer")
+	}
+
+	var reply string
+	if err := app1.CallRPC(ctx, peer.ID, "echo", "hi", &reply); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if want := "hi from " + app1.app.GetPeerID()[:8]; reply != want {
+		t.Errorf("wrong reply: got %q, want %q", reply, want)
+	}
+
+	var rpcErr *RPCError
+	err := app1.CallRPC(ctx, peer.ID, "echo", 42, nil)
+	if !errors.As(err, &rpcErr) || rpcErr.Code != RPCCodeBadRequest {
+		t.Errorf("expected bad request error, got %v", err)
+	}
+
+	err = app1.CallRPC(ctx, peer.ID, "missing", nil, nil)
+	if !errors.As(err, &rpcErr) || rpcErr.Code != RPCCodeUnknownMethod {
+		t.Errorf("expected unknown method error, got %v", err)
+	}
+
+	app2.RegisterRPCWithOptions("small", func(ctx context.Context, from string, payload json.RawMessage) (any, error) {
+		return nil, nil
+	}, &RPCOptions{MaxPayload: 16})
+	err = app1.CallRPC(ctx, peer.ID, "small", strings.Repeat("a", 64), nil)
+	if !errors.As(err, &rpcErr) || rpcErr.Code != RPCCodeTooLarge {
+		t.Errorf("expected too large error, got %v", err)
+	}
+
+	// answers only once the test is over
+	release := make(chan struct{})
+	defer close(release)
+	app2.RegisterRPC("slow", func(ctx context.Context, from string, payload json.RawMessage) (any, error) {
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return nil, nil
+	})
+	start := time.Now()
+	if err := app1.CallRPCWithOptions(ctx, peer.ID, "slow", nil, nil, &RPCOptions{Timeout: 200 * time.Millisecond}); err == nil {
+		t.Error("expected the call to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 800*time.Millisecond {
+		t.Errorf("call took %v despite its deadline", elapsed)
+	}
+}
+
+func TestDirectMessage(t *testing.T) {
+	network := NewMemoryNetwork()
+
+	handler1 := newTestHandler()
+	handler2 := newTestHandler()
+	app1 := newMemoryApp(t, network, "testUser1", handler1)
+	newMemoryApp(t, network, "testUser2", handler2)
+
+	var peer *PeerInfo
+	select {
+	case peer = <-handler1.peersJoined:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for peer")
+	}
+
+	if err := app1.SendDirect(peer.ID, "psst"); err != nil {
+		t.Fatalf("failed to send direct message: %v", err)
+	}
+
+	var firstID string
+	select {
+	case msg := <-handler2.directs:
+		if msg.Content != "psst" || msg.Type != MessageTypeDirect {
+			t.Errorf("wrong message: got %q (%s)", msg.Content, msg.Type)
+		}
+		if msg.From != app1.app.GetPeerID() {
+			t.Errorf("wrong sender: got %s", msg.From)
+		}
+		// the ID comes from the sender, it is the same on both sides
+		if len(msg.ID) != 32 {
+			t.Errorf("wrong message id %q", msg.ID)
+		}
+		firstID = msg.ID
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for direct message")
+	}
+
+	if err := app1.SendDirect(peer.ID, "psst"); err != nil {
+		t.Fatalf("failed to send direct message: %v", err)
+	}
+	select {
+	case msg := <-handler2.directs:
+		if msg.ID == firstID {
+			t.Errorf("two messages share id %q", msg.ID)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for second direct message")
+	}
+
+	select {
+	case msg := <-handler2.messages:
+		t.Errorf("direct message leaked into the room: %q", msg.Content)
+	default:
+	}
+}
+
+func TestFileTransfer(t *testing.T) {
+	network := NewMemoryNetwork()
+
+	handler1 := newTestHandler()
+	handler2 := newTestHandler()
+	app1 := newMemoryApp(t, network, "testUser1", handler1)
+	app2 := newMemoryApp(t, network, "testUser2", handler2)
+
+	var peer *PeerInfo
+	select {
+	case peer = <-handler1.peersJoined:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for peer")
+	}
+
+	// spans several chunks, the last one partial
+	content := make([]byte, 600<<10)
+	rand.Read(content)
+	path := filepath.Join(t.TempDir(), "report.log")
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sent, err := app1.SendFile(peer.ID, path)
+	if err != nil {
+		t.Fatalf("failed to send file: %v", err)
+	}
+
+	waitFor := func(h *testHandler, state TransferState) *FileTransfer {
+		t.Helper()
+		for {
+			select {
+			case tr := <-h.transfers:
+				if tr.ID == sent.ID && tr.State == state {
+					return tr
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timeout waiting for transfer to be %s", state)
+			}
+		}
+	}
+
+	offer := waitFor(handler2, TransferOffered)
+	if offer.Name != "report.log" || offer.Size != int64(len(content)) {
+		t.Errorf("wrong offer: %s (%d bytes)", offer.Name, offer.Size)
+	}
+
+	if err := app2.AcceptFile(offer.ID); err != nil {
+		t.Fatalf("failed to accept: %v", err)
+	}
+
+	done := waitFor(handler2, TransferComplete)
+	received, err := os.ReadFile(done.Path)
+	if err != nil {
+		t.Fatalf("failed to read received file: %v", err)
+	}
+	if !bytes.Equal(received, content) {
+		t.Error("received file differs from the original")
+	}
+
+	waitFor(handler1, TransferComplete)
+}
+
+func TestReceipts(t *testing.T) {
+	network := NewMemoryNetwork()
+
+	handler1 := newTestHandler()
+	app1 := newMemoryApp(t, network, "testUser1", handler1)
+	app2 := newMemoryApp(t, network, "testUser2", newTestHandler())
+	opts := testOptions(t)
+	opts.DisableReadReceipts = true
+	app3 := newMemoryAppWithOptions(t, network, "testUser3", newTestHandler(), opts)
+
+	for range 2 {
+		select {
+		case <-handler1.peersJoined:
+		case <-time.After(3 * time.Second):
+			t.Fatal("timeout waiting for peers")
+		}
+	}
+
+	const room = "receipts"
+	for _, app := range []*Lanchat{app1, app2, app3} {
+		if err := app.JoinRoom(room, ""); err != nil {
+			t.Fatalf("failed to join room: %v", err)
+		}
+	}
+	waitForMembers(t, room, app1, app2, app3)
+
+	if err := app1.SendMessage("did everyone get this?"); err != nil {
+		t.Fatalf("failed to send message: %v", err)
+	}
+
+	// both peers have the room open, only testUser2 admits reading it
+	var msg *ChatMessage
+	deadline := time.After(10 * time.Second)
+	for msg == nil || len(msg.DeliveredTo) < 2 || len(msg.ReadBy) < 1 {
+		select {
+		case msg = <-handler1.receipts:
+		case <-deadline:
+			t.Fatalf("timeout waiting for receipts: %+v", msg)
+		}
+	}
+	if len(msg.DeliveredTo) != 2 || len(msg.ReadBy) != 1 {
+		t.Errorf("wrong receipts: delivered to %v, read by %v", msg.DeliveredTo, msg.ReadBy)
+	}
+	if msg.Content != "did everyone get this?" {
+		t.Errorf("receipt for wrong message: %q", msg.Content)
+	}
+
+	// a later read receipt from testUser3 would be a bug, give it time to show
+	select {
+	case msg = <-handler1.receipts:
+		t.Errorf("unexpected receipt: delivered to %v, read by %v", msg.DeliveredTo, msg.ReadBy)
+	case <-time.After(3 * time.Second):
+	}
+}
+
+func TestTyping(t *testing.T) {
+	network := NewMemoryNetwork()
+
+	handler1 := newTestHandler()
+	app1 := newMemoryApp(t, network, "testUser1", handler1)
+	app2 := newMemoryApp(t, network, "testUser2", newTestHandler())
+
+	select {
+	case <-handler1.peersJoined:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for peer")
+	}
+
+	const room = "typing"
+	for _, app := range []*Lanchat{app1, app2} {
+		if err := app.JoinRoom(room, "secret"); err != nil {
+			t.Fatalf("failed to join room: %v", err)
+		}
+	}
+	waitForMembers(t, room, app1, app2)
+
+	nextTyping := func(timeout time.Duration) *Typing {
+		select {
+		case typing := <-handler1.typing:
+			return typing
+		case <-time.After(timeout):
+			return nil
+		}
+	}
+
+	// key presses in quick succession make one signal
+	for range 5 {
+		if err := app2.Typing(room); err != nil {
+			t.Fatalf("failed to signal typing: %v", err)
+		}
+	}
+	typing := nextTyping(3 * time.Second)
+	if typing == nil {
+		t.Fatal("timeout waiting for typing signal")
+	}
+	if typing.Room != room || typing.Nickname != "testUser2" || !typing.Expires.After(time.Now()) {
+		t.Errorf("wrong typing signal: %+v", typing)
+	}
+	if typing := nextTyping(time.Second); typing != nil {
+		t.Errorf("typing signal not throttled: %+v", typing)
+	}
+
+	// KeepTyping repeats the signal until stopped
+	stop, err := app2.KeepTyping(room)
+	if err != nil {
+		t.Fatalf("failed to keep typing: %v", err)
+	}
+	for i := range 2 {
+		if nextTyping(5*time.Second) == nil {
+			t.Fatalf("timeout waiting for typing signal %d", i+1)
+		}
+	}
+	stop()
+
+	// typing is never stored
+	if err := app2.SendMessageTo(room, "done"); err != nil {
+		t.Fatalf("failed to send message: %v", err)
+	}
+	select {
+	case <-skipToText(t, handler1.messages):
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+	history, err := app1.GetHistory(room, 50)
+	if err != nil {
+		t.Fatalf("failed to read history: %v", err)
+	}
+	for _, msg := range history {
+		if msg.Type == "typing" {
+			t.Errorf("typing signal stored: %+v", msg)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	network := NewMemoryNetwork()
+
+	handler1 := newTestHandler()
+	app1 := newMemoryApp(t, network, "testUser1", handler1)
+	opts := testOptions(t)
+	opts.AwayAfter = 2 * time.Second
+	app2 := newMemoryAppWithOptions(t, network, "testUser2", newTestHandler(), opts)
+
+	select {
+	case <-handler1.peersJoined:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for peer")
+	}
+
+	const room = "presence"
+	for _, app := range []*Lanchat{app1, app2} {
+		if err := app.JoinRoom(room, ""); err != nil {
+			t.Fatalf("failed to join room: %v", err)
+		}
+	}
+	waitForMembers(t, room, app1, app2)
+
+	// app2 may go away on its own meanwhile, so wait for the wanted status
+	waitStatus := func(status string) *PeerInfo {
+		t.Helper()
+		deadline := time.After(5 * time.Second)
+		for {
+			select {
+			case peer := <-handler1.statuses:
+				if peer.Status == status {
+					return peer
+				}
+			case <-deadline:
+				t.Fatalf("timeout waiting for status %q", status)
+				return nil
+			}
+		}
+	}
+
+	if err := app2.SetStatus("busy"); err != nil {
+		t.Fatalf("failed to set status: %v", err)
+	}
+	if peer := waitStatus("busy"); peer.Nickname != "testUser2" {
+		t.Errorf("status change from wrong peer: %+v", peer)
+	}
+	if err := app2.SetStatus("in a meeting"); err != nil {
+		t.Fatalf("failed to set status: %v", err)
+	}
+	waitStatus("in a meeting")
+
+	found := false
+	for _, entry := range app1.GetPeerList() {
+		found = found || strings.HasPrefix(entry, "testUser2 [in a meeting]")
+	}
+	if !found {
+		t.Errorf("status missing from peer list: %v", app1.GetPeerList())
+	}
+
+	// back online by hand, then away for being idle, then online again
+	if err := app2.SetStatus(StatusOnline); err != nil {
+		t.Fatalf("failed to set status: %v", err)
+	}
+	waitStatus(StatusOnline)
+	waitStatus(StatusAway)
+	if err := app2.SendMessageTo(room, "back"); err != nil {
+		t.Fatalf("failed to send message: %v", err)
+	}
+	waitStatus(StatusOnline)
+}
+
+func TestNickname(t *testing.T) {
+	network := NewMemoryNetwork()
+
+	handler1 := newTestHandler()
+	app1 := newMemoryApp(t, network, "testUser1", handler1)
+	app2 := newMemoryApp(t, network, "testUser2", newTestHandler())
+
+	select {
+	case <-handler1.peersJoined:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for peer")
+	}
+
+	const room = "names"
+	for _, app := range []*Lanchat{app1, app2} {
+		if err := app.JoinRoom(room, ""); err != nil {
+			t.Fatalf("failed to join room: %v", err)
+		}
+	}
+	waitForMembers(t, room, app1, app2)
+
+	// taking our nickname, so testUser2 shows with a suffix
+	if err := app2.SetNickname("testUser1"); err != nil {
+		t.Fatalf("failed to change nickname: %v", err)
+	}
+	select {
+	case change := <-handler1.nicknames:
+		if change.Previous != "testUser2" || change.Nickname != "testUser1" {
+			t.Errorf("wrong nickname change: %+v", change)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for nickname change")
+	}
+
+	deadline := time.After(3 * time.Second)
+	for announced := false; !announced; {
+		select {
+		case msg := <-handler1.messages:
+			if msg.Type != MessageTypeNick {
+				continue
+			}
+			announced = true
+			if !strings.HasPrefix(msg.Content, "testUser2 is now testUser1@") {
+				t.Errorf("wrong announcement: %q", msg.Content)
+			}
+		case <-deadline:
+			t.Fatal("timeout waiting for announcement")
+		}
+	}
+
+	if err := app2.SendMessageTo(room, "who am i"); err != nil {
+		t.Fatalf("failed to send message: %v", err)
+	}
+	select {
+	case msg := <-skipToText(t, handler1.messages):
+		if !strings.HasPrefix(msg.Nickname, "testUser1@") {
+			t.Errorf("duplicate nickname not told apart: %q", msg.Nickname)
+		}
+		// the name shown is enough to address the peer
+		if p, err := app1.app.FindPeer(msg.Nickname); err != nil || p.ID.String() != app2.app.GetPeerID() {
+			t.Errorf("%q doesn't find the peer: %v", msg.Nickname, err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout waiting for message")
+	}
+
+	if peers := app1.GetPeerList(); len(peers) != 1 || !strings.HasPrefix(peers[0], "testUser1@") {
+		t.Errorf("wrong peer list: %v", peers)
+	}
+}
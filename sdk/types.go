@@ -1,37 +1,177 @@
 package sdk
 
 import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/matt0792/lanchat/internal/app"
+	"github.com/matt0792/lanchat/internal/p2p"
 )
 
+type Options struct {
+	// DataDir holds persistent state, defaults to a directory under the
+	// lanchat config directory keyed by domain and Name
+	DataDir string
+	// Name tells apart bots that share a nickname and domain, it keys the
+	// default DataDir and defaults to the nickname
+	Name string
+	// IdentityPath overrides the identity key location
+	IdentityPath string
+	// IdentityPassphrase encrypts the identity key on disk when set
+	IdentityPassphrase string
+
+	// DomainSecret restricts connections to peers with the same domain and
+	// secret. Forces the tcp transport.
+	DomainSecret string
+
+	// ListenAddrs are the multiaddrs to listen on
+	ListenAddrs []string
+	// Transports lists the enabled transports (tcp, quic), all when empty
+	Transports []string
+	// AnnounceAddrs replaces the addresses advertised to peers
+	AnnounceAddrs []string
+	// AllowInterfaces restricts networking to the named interfaces
+	AllowInterfaces []string
+	// DenyInterfaces excludes the named interfaces
+	DenyInterfaces []string
+
+	// BootstrapPeers are multiaddrs (including /p2p/<peer-id>) dialed on
+	// startup
+	BootstrapPeers []string
+	// DisableMDNS turns off multicast discovery
+	DisableMDNS bool
+	// RelayService relays traffic for peers that can't reach each other
+	// directly, e.g. on isolated VLANs
+	RelayService bool
+	// MemoryNetwork connects instances in the same process without multicast
+	MemoryNetwork *MemoryNetwork
+
+	// DownloadDir is where accepted files are saved, defaults to
+	// <DataDir>/downloads
+	DownloadDir string
+
+	// HistoryRetention is how long room messages are kept on disk, 30 days
+	// by default
+	HistoryRetention time.Duration
+	// DisableHistory keeps room messages in memory only
+	DisableHistory bool
+
+	// DisableReadReceipts stops telling senders which of their messages the
+	// bot has seen, delivery is still acknowledged
+	DisableReadReceipts bool
+
+	// AwayAfter is how long without sending anything before the bot's
+	// status turns to away, 10 minutes by default
+	AwayAfter time.Duration
+	// DisableAutoAway keeps the bot's status as it is however long it is
+	// idle
+	DisableAutoAway bool
+}
+
+// MemoryNetwork lets Lanchat instances in one process discover each other,
+// mainly useful for tests
+type MemoryNetwork struct {
+	net *p2p.MemoryNetwork
+}
+
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{net: p2p.NewMemoryNetwork()}
+}
+
 type User struct {
 	Nickname string
 	Status   string
 }
 
+// statuses with a meaning of their own, anything else is custom text
+const (
+	StatusOnline = app.StatusOnline
+	StatusAway   = app.StatusAway
+	StatusBusy   = app.StatusBusy
+)
+
 type PeerInfo struct {
-	ID       string
-	Nickname string
-	Status   string
-	LastSeen time.Time
-	Metadata map[string]string
+	ID          string
+	Nickname    string
+	Status      string
+	CurrentRoom string
+	Rooms       []string
+	LastSeen    time.Time
+	Metadata    map[string]string
 }
 
 type Room struct {
 	Name     string
 	Peers    []*PeerInfo
 	Messages []*ChatMessage
+	// Unread counts messages received while another room was active
+	Unread int
 }
 
 type ChatMessage struct {
 	ID        string
+	Room      string // empty for direct messages
 	From      string
 	Nickname  string
 	Content   string
 	Timestamp time.Time
-	Type      MessageType
+	// Clock is the sender's logical clock for the room. Messages are
+	// ordered by it, then by timestamp, sender and ID, the same way on
+	// every peer.
+	Clock uint64
+	Type  MessageType
+	// History is set for messages said before we joined, fetched from other
+	// members, and for messages read back from disk. Bots don't receive
+	// them as events.
+	History bool
+	// Edited is set once the sender changed the text, Deleted once they
+	// removed it, leaving Content empty
+	Edited  bool
+	Deleted bool
+	// ReplyTo is the ID of the message this one answers, empty for none
+	ReplyTo string
+	// Reactions lists the peer IDs that added each reaction
+	Reactions map[string][]string
+	// DeliveredTo lists the peer IDs that acknowledged the message, ReadBy
+	// those that also had it on screen
+	DeliveredTo []string
+	ReadBy      []string
+}
+
+// Reaction is a peer adding a reaction to a room message, or taking it
+// back. Message has the reaction applied, so counting a poll is a matter
+// of len(msg.Reactions["+1"]).
+type Reaction struct {
+	Room      string
+	MessageID string
+	From      string
+	Nickname  string
+	Reaction  string
+	Removed   bool
+	// History is set for reactions made before we joined
+	History bool
+	Message *ChatMessage
+}
+
+// NicknameChange is a peer taking a new nickname
+type NicknameChange struct {
+	From     string
+	Previous string
+	Nickname string
+}
+
+// Typing is a peer composing a message in a room
+type Typing struct {
+	Room     string
+	From     string
+	Nickname string
+	// Expires is when the peer stops counting as typing, unless they send a
+	// message or signal again before
+	Expires time.Time
 }
 
 type MessageType string
@@ -40,18 +180,142 @@ const (
 	MessageTypeText  MessageType = "text"
 	MessageTypeJoin  MessageType = "join"
 	MessageTypeLeave MessageType = "leave"
+	// MessageTypeNick announces a peer's new nickname in a room
+	MessageTypeNick MessageType = "nick"
+	// MessageTypeDirect is a private message sent with SendDirect
+	MessageTypeDirect MessageType = "direct"
+)
+
+// RPCHandler serves a custom RPC method. from is the caller's peer ID and
+// the returned value is sent back as JSON.
+type RPCHandler func(ctx context.Context, from string, payload json.RawMessage) (any, error)
+
+// RPCOptions sets a method's request size limit and handler timeout when
+// registering, or the response size limit and deadline of one call. Zero
+// values use the defaults.
+type RPCOptions = p2p.RPCOptions
+
+// RPCError is returned by CallRPC when the remote handler fails. Handlers
+// can return one to pick the code.
+type RPCError = p2p.RPCError
+
+const (
+	RPCCodeBadRequest    = p2p.RPCCodeBadRequest
+	RPCCodeUnknownMethod = p2p.RPCCodeUnknownMethod
+	RPCCodeTooLarge      = p2p.RPCCodeTooLarge
+	RPCCodeInternal      = p2p.RPCCodeInternal
+	RPCCodeTimeout       = p2p.RPCCodeTimeout
 )
 
 type EventType string
 
 const (
-	EventPeerJoined   EventType = "peer_joined"
-	EventPeerLeft     EventType = "peer_left"
-	EventMessageRecv  EventType = "message_received"
-	EventRoomJoined   EventType = "room_joined"
-	EventStatusChange EventType = "status_change"
+	EventPeerJoined    EventType = "peer_joined"
+	EventPeerLeft      EventType = "peer_left"
+	EventMessageRecv   EventType = "message_received"
+	EventRoomJoined    EventType = "room_joined"
+	EventStatusChange  EventType = "status_change"
+	EventDirectMessage EventType = "direct_message"
+	EventFileOffer     EventType = "file_offer"
+	EventFileProgress  EventType = "file_progress"
+	EventFileComplete  EventType = "file_complete"
+	EventFileFailed    EventType = "file_failed"
+	// EventMessageEdited and EventMessageDeleted carry the changed message
+	EventMessageEdited  EventType = "message_edited"
+	EventMessageDeleted EventType = "message_deleted"
+	EventReaction       EventType = "reaction"
+	// EventReceipt carries one of our messages after a peer received or
+	// read it
+	EventReceipt EventType = "receipt"
+	EventTyping  EventType = "typing"
+	// EventNicknameChange is a peer taking a new nickname
+	EventNicknameChange EventType = "nickname_change"
 )
 
+// FileTransfer is a file being sent to or received from a peer
+type FileTransfer struct {
+	ID          string
+	Peer        string
+	Nickname    string
+	Name        string
+	Size        int64
+	Transferred int64
+	Outgoing    bool
+	State       TransferState
+	// Path is the file being sent, or where a received file was saved
+	Path  string
+	Error string
+}
+
+type TransferState string
+
+const (
+	TransferOffered  TransferState = "offered"
+	TransferActive   TransferState = "active"
+	TransferComplete TransferState = "complete"
+	TransferFailed   TransferState = "failed"
+	TransferDeclined TransferState = "declined"
+)
+
+func convertOptions(nickname, domain string, o *Options) (app.Options, error) {
+	if o == nil {
+		o = &Options{}
+	}
+
+	opts := app.Options{
+		DataDir:            o.DataDir,
+		IdentityPath:       o.IdentityPath,
+		IdentityPassphrase: o.IdentityPassphrase,
+		DomainSecret:       o.DomainSecret,
+		ListenAddrs:        o.ListenAddrs,
+		Transports:         o.Transports,
+		AnnounceAddrs:      o.AnnounceAddrs,
+		AllowInterfaces:    o.AllowInterfaces,
+		DenyInterfaces:     o.DenyInterfaces,
+		BootstrapPeers:     o.BootstrapPeers,
+		DisableMDNS:        o.DisableMDNS,
+		RelayService:       o.RelayService,
+		DownloadDir:        o.DownloadDir,
+		HistoryRetention:   o.HistoryRetention,
+		DisableHistory:     o.DisableHistory,
+
+		DisableReadReceipts: o.DisableReadReceipts,
+		AwayAfter:           o.AwayAfter,
+		DisableAutoAway:     o.DisableAutoAway,
+	}
+
+	if o.MemoryNetwork != nil {
+		opts.Discovery = append(opts.Discovery, o.MemoryNetwork.net.Discovery())
+	}
+
+	if opts.DataDir == "" {
+		dir, err := app.DefaultDataDir()
+		if err != nil {
+			return opts, err
+		}
+		name := o.Name
+		if name == "" {
+			name = nickname
+		}
+		opts.DataDir = filepath.Join(dir, "bots", safeFileName(domain, "default"), safeFileName(name, "bot"))
+	}
+
+	return opts, nil
+}
+
+func safeFileName(name, fallback string) string {
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+	if name == "" {
+		name = fallback
+	}
+	return name
+}
+
 func convertUser(u *app.User) *User {
 	if u == nil {
 		return nil
@@ -67,11 +331,31 @@ func convertPeerInfo(p *app.PeerInfo) *PeerInfo {
 		return nil
 	}
 	return &PeerInfo{
-		ID:       p.ID.String(),
-		Nickname: p.Nickname,
-		Status:   p.Status,
-		LastSeen: p.LastSeen,
-		Metadata: p.Metadata,
+		ID:          p.ID.String(),
+		Nickname:    p.Nickname,
+		Status:      p.Status,
+		CurrentRoom: p.CurrentRoom,
+		Rooms:       p.Rooms,
+		LastSeen:    p.LastSeen,
+		Metadata:    p.Metadata,
+	}
+}
+
+func convertFileTransfer(t *app.FileTransfer) *FileTransfer {
+	if t == nil {
+		return nil
+	}
+	return &FileTransfer{
+		ID:          t.ID,
+		Peer:        t.Peer.String(),
+		Nickname:    t.Nickname,
+		Name:        t.Name,
+		Size:        t.Size,
+		Transferred: t.Transferred,
+		Outgoing:    t.Outgoing,
+		State:       TransferState(t.State),
+		Path:        t.Path,
+		Error:       t.Error,
 	}
 }
 
@@ -80,37 +364,106 @@ func convertRoom(r *app.Room) *Room {
 		return nil
 	}
 
-	peers := make([]*PeerInfo, 0, len(r.Peers))
-	for _, p := range r.Peers {
-		peers = append(peers, convertPeerInfo(p))
+	room := &Room{Name: r.Name}
+	r.View(func(r *app.Room) {
+		room.Peers = make([]*PeerInfo, 0, len(r.Peers))
+		for _, p := range r.Peers {
+			room.Peers = append(room.Peers, convertPeerInfo(p))
+		}
+		room.Messages = convertChatMessages(r.Messages)
+		room.Unread = r.Unread
+	})
+	return room
+}
+
+func convertChatMessage(msg *app.ChatMessage) *ChatMessage {
+	if msg == nil {
+		return nil
+	}
+	return &ChatMessage{
+		ID:          msg.ID,
+		Room:        msg.Room,
+		From:        msg.From.String(),
+		Nickname:    msg.Nickname,
+		Content:     msg.Content,
+		Timestamp:   msg.Timestamp,
+		Clock:       msg.Clock,
+		Type:        MessageType(msg.Type),
+		History:     msg.History,
+		Edited:      msg.Edited,
+		Deleted:     msg.Deleted,
+		ReplyTo:     msg.ReplyTo,
+		Reactions:   convertReactions(msg.Reactions),
+		DeliveredTo: convertPeerIDs(msg.DeliveredTo),
+		ReadBy:      convertPeerIDs(msg.ReadBy),
 	}
+}
 
-	messages := make([]*ChatMessage, len(r.Messages))
-	for i, m := range r.Messages {
-		messages[i] = convertChatMessage(m)
+func convertPeerIDs(ids []peer.ID) []string {
+	if len(ids) == 0 {
+		return nil
+	}
+	converted := make([]string, len(ids))
+	for i, id := range ids {
+		converted[i] = id.String()
 	}
+	return converted
+}
 
-	return &Room{
-		Name:     r.Name,
-		Peers:    peers,
-		Messages: messages,
+func convertReactions(reactions map[string][]peer.ID) map[string][]string {
+	if len(reactions) == 0 {
+		return nil
 	}
+	converted := make(map[string][]string, len(reactions))
+	for reaction, peers := range reactions {
+		for _, p := range peers {
+			converted[reaction] = append(converted[reaction], p.String())
+		}
+	}
+	return converted
 }
 
-func convertChatMessage(msg *app.ChatMessage) *ChatMessage {
-	if msg == nil {
+func convertReaction(r *app.Reaction) *Reaction {
+	if r == nil {
 		return nil
 	}
-	return &ChatMessage{
-		ID:        msg.ID,
-		From:      msg.From.String(),
-		Nickname:  msg.Nickname,
-		Content:   msg.Content,
-		Timestamp: msg.Timestamp,
-		Type:      MessageType(msg.Type),
+	return &Reaction{
+		Room:      r.Room,
+		MessageID: r.MessageID,
+		From:      r.From.String(),
+		Nickname:  r.Nickname,
+		Reaction:  r.Reaction,
+		Removed:   r.Removed,
+		History:   r.History,
+		Message:   convertChatMessage(r.Message),
+	}
+}
+
+func convertNicknameChange(c *app.NicknameChange) *NicknameChange {
+	return &NicknameChange{
+		From:     c.From.String(),
+		Previous: c.Previous,
+		Nickname: c.Nickname,
+	}
+}
+
+func convertTyping(t *app.Typing) *Typing {
+	return &Typing{
+		Room:     t.Room,
+		From:     t.From.String(),
+		Nickname: t.Nickname,
+		Expires:  t.Expires,
 	}
 }
 
+func convertChatMessages(messages []*app.ChatMessage) []*ChatMessage {
+	converted := make([]*ChatMessage, len(messages))
+	for i, m := range messages {
+		converted[i] = convertChatMessage(m)
+	}
+	return converted
+}
+
 func convertMessageType(mt app.MessageType) MessageType {
 	return MessageType(mt)
 }
@@ -143,9 +496,103 @@ func (h *BaseEventHandler) HandlePeerJoined(peerInfo *PeerInfo) {}
 
 func (h *BaseEventHandler) HandleRoomJoined(room *Room) {}
 
+func (h *BaseEventHandler) HandleDirectMessage(msg *ChatMessage) {}
+
+// FileTransferHandler is implemented by event handlers that follow file
+// transfers. eventType is one of the EventFile* events.
+type FileTransferHandler interface {
+	HandleFileTransfer(eventType EventType, transfer *FileTransfer)
+}
+
+// MessageChangeHandler is implemented by event handlers that follow edits
+// and deletes. eventType is EventMessageEdited or EventMessageDeleted.
+type MessageChangeHandler interface {
+	HandleMessageChange(eventType EventType, msg *ChatMessage)
+}
+
+// ReactionHandler is implemented by event handlers that follow reactions
+type ReactionHandler interface {
+	HandleReaction(*Reaction)
+}
+
+// ReceiptHandler is implemented by event handlers that want to know who
+// received and read the messages they send
+type ReceiptHandler interface {
+	HandleReceipt(*ChatMessage)
+}
+
+// NicknameHandler is implemented by event handlers that follow peers
+// changing their nickname
+type NicknameHandler interface {
+	HandleNicknameChange(*NicknameChange)
+}
+
+// StatusHandler is implemented by event handlers that follow the status
+// of peers
+type StatusHandler interface {
+	HandleStatusChange(*PeerInfo)
+}
+
+// TypingHandler is implemented by event handlers that show who is typing
+type TypingHandler interface {
+	HandleTyping(*Typing)
+}
+
+// DirectMessageHandler is implemented by event handlers that want private
+// messages, BaseEventHandler already does
+type DirectMessageHandler interface {
+	HandleDirectMessage(*ChatMessage)
+}
+
 type Bot interface {
 	Initialize(lc *Lanchat) error
 	OnMessage(msg ChatMessage, lc *Lanchat) error
 	OnPeerJoined(peer PeerInfo, lc *Lanchat) error
 	OnRoomJoined(room Room, lc *Lanchat) error
 }
+
+// DirectMessageBot is implemented by bots that answer private messages
+type DirectMessageBot interface {
+	OnDirectMessage(msg ChatMessage, lc *Lanchat) error
+}
+
+// FileTransferBot is implemented by bots that handle file offers and
+// transfer updates
+type FileTransferBot interface {
+	OnFileTransfer(eventType EventType, transfer FileTransfer, lc *Lanchat) error
+}
+
+// MessageChangeBot is implemented by bots that follow edits and deletes of
+// room messages
+type MessageChangeBot interface {
+	OnMessageChange(eventType EventType, msg ChatMessage, lc *Lanchat) error
+}
+
+// ReactionBot is implemented by bots that follow reactions, e.g. to count
+// votes
+type ReactionBot interface {
+	OnReaction(reaction Reaction, lc *Lanchat) error
+}
+
+// ReceiptBot is implemented by bots that follow who received and read the
+// messages they send
+type ReceiptBot interface {
+	OnReceipt(msg ChatMessage, lc *Lanchat) error
+}
+
+// TypingBot is implemented by bots that want to know who is typing
+type TypingBot interface {
+	OnTyping(t Typing, lc *Lanchat) error
+}
+
+// StatusBot is implemented by bots that follow the status of peers, peer
+// has the new status
+type StatusBot interface {
+	OnStatusChange(peer PeerInfo, lc *Lanchat) error
+}
+
+// NicknameBot is implemented by bots that follow peers changing their
+// nickname
+type NicknameBot interface {
+	OnNicknameChange(change NicknameChange, lc *Lanchat) error
+}